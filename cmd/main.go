@@ -41,16 +41,8 @@ func main() {
 		os.Exit(5)
 	}
 
-	// Make a collection
-	collection := &orm.Collection{
-		Connection: conn,
-		TableName:  "foo",
-		AllocateRecord: func() interface{} {
-			// This is for allocating a record of the correct type
-			return &TestDocument{}
-		},
-		OrganizationIdKey: "organization_id",
-	}
+	// Make a collection, records come back as *TestDocument
+	collection := orm.NewTypedCollection[TestDocument](conn, "foo")
 
 	if err := collection.Drop(ctx); err != nil {
 		fmt.Printf("Failed to drop collection!\n")
@@ -75,19 +67,12 @@ func main() {
 	fmt.Printf("created doc %s", id)
 
 	// simple query within an org where an email equals
-	obj, err := collection.Query().WithinOrg("3434").Filter("email", "freddy@mycorp.com").First(ctx)
+	doc, err := collection.Query().WithinOrg("3434").Filter("email", "freddy@mycorp.com").First(ctx)
 	if err != nil {
 		fmt.Printf("Failure! %s\n", err)
 		os.Exit(4)
 	}
 
-	// convert the obj to our type
-	doc, ok := obj.(*TestDocument)
-	if !ok {
-		fmt.Printf("Failure2 couldn't convert doc\n")
-		os.Exit(5)
-	}
-
 	fmt.Printf("Found user with id = %s\n", doc.Id)
 
 	q := collection.Query()
@@ -106,18 +91,12 @@ func main() {
 
 	fmt.Printf("Fount a total of %d docs\n", count)
 
-	obj, err = q.First(ctx)
+	doc, err = q.First(ctx)
 	if err != nil {
 		fmt.Printf("Failed to get the doc, what? %s\n", err)
 		os.Exit(8)
 	}
 
-	doc, ok = obj.(*TestDocument)
-	if !ok {
-		fmt.Printf("We couldn't convert to doc... weird\n")
-		os.Exit(9)
-	}
-
 	fmt.Printf("Ok, the doc is %+v\n", doc)
 
 }
@@ -0,0 +1,55 @@
+package encoding
+
+// -------------------------------------
+// GeoJSON shapes for records with geo attributes. They're stored the way arango's geo
+// functions and geo indexes (with GeoJSON enabled) expect, coordinates being [longitude, latitude].
+// -------------------------------------
+
+const GeoJSONPoint = "Point"
+const GeoJSONPolygon = "Polygon"
+
+type GeoPoint struct {
+	Type        string    `json:"type"`
+	Coordinates []float64 `json:"coordinates"`
+}
+
+func NewGeoPoint(latitude, longitude float64) GeoPoint {
+	return GeoPoint{Type: GeoJSONPoint, Coordinates: []float64{longitude, latitude}}
+}
+
+func (c GeoPoint) Latitude() float64 {
+	if len(c.Coordinates) < 2 {
+		return 0
+	}
+	return c.Coordinates[1]
+}
+
+func (c GeoPoint) Longitude() float64 {
+	if len(c.Coordinates) < 1 {
+		return 0
+	}
+	return c.Coordinates[0]
+}
+
+// GeoPolygon is an outer ring followed by any holes, each ring a closed list of [longitude, latitude] positions
+type GeoPolygon struct {
+	Type        string        `json:"type"`
+	Coordinates [][][]float64 `json:"coordinates"`
+}
+
+// NewGeoPolygon makes a polygon from points given as GeoPoints, closing the ring if needed
+func NewGeoPolygon(points ...GeoPoint) GeoPolygon {
+	ring := make([][]float64, 0, len(points)+1)
+	for _, point := range points {
+		ring = append(ring, []float64{point.Longitude(), point.Latitude()})
+	}
+
+	if len(ring) > 0 {
+		first, last := ring[0], ring[len(ring)-1]
+		if first[0] != last[0] || first[1] != last[1] {
+			ring = append(ring, first)
+		}
+	}
+
+	return GeoPolygon{Type: GeoJSONPolygon, Coordinates: [][][]float64{ring}}
+}
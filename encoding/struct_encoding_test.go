@@ -1,6 +1,7 @@
 package encoding
 
 import (
+	"encoding/json"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -73,3 +74,35 @@ func TestObjectToMap(t *testing.T) {
 	assert.Equal(t, 440, m["complaints"])
 	assert.Equal(t, "2023-01-01", m["created_date"])
 }
+
+type Place struct {
+	Id       string     `json:"id"`
+	Location GeoPoint   `json:"location"`
+	Area     GeoPolygon `json:"area"`
+}
+
+func TestGeoJSONRoundTrip(t *testing.T) {
+	place := Place{
+		Id:       "dispatch",
+		Location: NewGeoPoint(37.77, -122.41),
+		Area:     NewGeoPolygon(NewGeoPoint(37, -123), NewGeoPoint(38, -123), NewGeoPoint(38, -122)),
+	}
+	assert.Equal(t, 37.77, place.Location.Latitude())
+	assert.Equal(t, -122.41, place.Location.Longitude())
+	assert.Equal(t, 4, len(place.Area.Coordinates[0])) // the ring got closed
+
+	m, err := ObjectToMap(place)
+	assert.Nil(t, err)
+
+	// what arango stores, and hands back after a json round trip
+	data, err := json.Marshal(m)
+	assert.Nil(t, err)
+	assert.Contains(t, string(data), `"location":{"coordinates":[-122.41,37.77],"type":"Point"}`)
+
+	stored := make(map[string]interface{})
+	assert.Nil(t, json.Unmarshal(data, &stored))
+
+	back := Place{}
+	assert.Nil(t, MapToObject(stored, &back))
+	assert.Equal(t, place, back)
+}
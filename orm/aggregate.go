@@ -0,0 +1,261 @@
+package orm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/ridelabs/simply_arango/encoding"
+)
+
+// -------------------------------------
+// Aggregations. Aggregate ends the filter chaining with a COLLECT that groups the
+// matching documents and computes numbers per group:
+//
+//	var rows []struct {
+//		OrganizationId string  `json:"organization_id"`
+//		Total          float64 `json:"total"`
+//		Docs           int     `json:"docs"`
+//	}
+//	err := collection.Query().Aggregate().GroupBy("organization_id").
+//		Sum("total", "amount").Count("docs").All(ctx, &rows)
+//
+// Every result is returned under the alias it was given (group keys under their own name).
+// -------------------------------------
+
+type aggregateField struct {
+	alias    string
+	function string // empty for group keys and pushed values
+	key      string
+}
+
+type Aggregation struct {
+	collectionFilter *CollectionFilter
+
+	groups     []aggregateField
+	aggregates []aggregateField
+	pushes     []aggregateField
+	aliases    map[string]bool
+	err        error
+}
+
+func (c *CollectionFilter) Aggregate() *Aggregation {
+	return &Aggregation{
+		collectionFilter: c,
+		aliases:          make(map[string]bool),
+	}
+}
+
+func (c *Aggregation) addAlias(alias string) bool {
+	if c.err != nil {
+		return false
+	}
+
+	if alias == "" {
+		c.err = errors.New("aggregate results need a name")
+		return false
+	}
+
+	if c.aliases[alias] {
+		c.err = fmt.Errorf("aggregate result %s is defined twice", alias)
+		return false
+	}
+
+	c.aliases[alias] = true
+	return true
+}
+
+// GroupBy groups the documents by the given attributes, each is returned under its own name
+func (c *Aggregation) GroupBy(keys ...string) *Aggregation {
+	for _, key := range keys {
+		c.GroupByAs(key, key)
+	}
+
+	return c
+}
+
+// GroupByAs groups the documents by an attribute that's returned as alias, handy for nested attributes
+func (c *Aggregation) GroupByAs(alias, key string) *Aggregation {
+	if c.addAlias(alias) {
+		c.groups = append(c.groups, aggregateField{alias: alias, key: key})
+	}
+
+	return c
+}
+
+func (c *Aggregation) aggregate(alias, function, key string) *Aggregation {
+	if c.addAlias(alias) {
+		c.aggregates = append(c.aggregates, aggregateField{alias: alias, function: function, key: key})
+	}
+
+	return c
+}
+
+func (c *Aggregation) Sum(alias, key string) *Aggregation {
+	return c.aggregate(alias, "SUM", key)
+}
+
+func (c *Aggregation) Avg(alias, key string) *Aggregation {
+	return c.aggregate(alias, "AVERAGE", key)
+}
+
+func (c *Aggregation) Min(alias, key string) *Aggregation {
+	return c.aggregate(alias, "MIN", key)
+}
+
+func (c *Aggregation) Max(alias, key string) *Aggregation {
+	return c.aggregate(alias, "MAX", key)
+}
+
+// CountDistinct counts the different values of key in each group
+func (c *Aggregation) CountDistinct(alias, key string) *Aggregation {
+	return c.aggregate(alias, "COUNT_DISTINCT", key)
+}
+
+// Count counts the documents in each group
+func (c *Aggregation) Count(alias string) *Aggregation {
+	return c.aggregate(alias, "COUNT", "")
+}
+
+// Push collects the values of key in each group into an array
+func (c *Aggregation) Push(alias, key string) *Aggregation {
+	if c.addAlias(alias) {
+		c.pushes = append(c.pushes, aggregateField{alias: alias, key: key})
+	}
+
+	return c
+}
+
+// query builds the COLLECT. The AQL variables are generated (g0, a0, ...) so aliases can be anything,
+// they only show up as (quoted) attribute names in the returned rows.
+func (c *Aggregation) query() (string, error) {
+	if c.err != nil {
+		return "", c.err
+	}
+	if err := c.collectionFilter.checkScope(); err != nil {
+		return "", err
+	}
+
+	if len(c.groups) == 0 && len(c.aggregates) == 0 && len(c.pushes) == 0 {
+		return "", errors.New("nothing to aggregate, add a GroupBy or an aggregate function")
+	}
+
+	groups := make([]string, 0, len(c.groups))
+	aggregates := make([]string, 0, len(c.aggregates))
+	pushes := make([]string, 0, len(c.pushes))
+	returns := make([]string, 0, len(c.aliases))
+
+	for i, field := range c.groups {
+		variable := fmt.Sprintf("g%d", i)
+		groups = append(groups, fmt.Sprintf("%s = %s", variable, &DocumentAttribute{name: field.key}))
+		returns = append(returns, fmt.Sprintf("%s: %s", quoteName(field.alias), variable))
+	}
+
+	for i, field := range c.aggregates {
+		variable := fmt.Sprintf("a%d", i)
+		argument := "1"
+		if field.key != "" {
+			argument = (&DocumentAttribute{name: field.key}).String()
+		}
+		aggregates = append(aggregates, fmt.Sprintf("%s = %s(%s)", variable, field.function, argument))
+		returns = append(returns, fmt.Sprintf("%s: %s", quoteName(field.alias), variable))
+	}
+
+	for i, field := range c.pushes {
+		pushes = append(pushes, fmt.Sprintf("p%d: %s", i, &DocumentAttribute{name: field.key}))
+		returns = append(returns, fmt.Sprintf("%s: pushed[*].p%d", quoteName(field.alias), i))
+	}
+
+	collect := "COLLECT " + strings.Join(groups, ", ")
+	if len(aggregates) > 0 {
+		collect += " AGGREGATE " + strings.Join(aggregates, ", ")
+	}
+	if len(pushes) > 0 {
+		collect += " INTO pushed = { " + strings.Join(pushes, ", ") + " }"
+	}
+
+	return fmt.Sprintf(`
+FOR doc IN @@collection
+ %s
+ %s
+ RETURN { %s }`, c.collectionFilter.formatExpressions(), collect, strings.Join(returns, ", ")), nil
+}
+
+// All runs the aggregation and decodes the rows into out,
+// a pointer to a slice of structs (or pointers to them) or of map[string]interface{}
+func (c *Aggregation) All(ctx context.Context, out interface{}) error {
+	slice := reflect.ValueOf(out)
+	if slice.Kind() != reflect.Pointer || slice.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("aggregate results go into a pointer to a slice, not %T", out)
+	}
+	slice = slice.Elem()
+
+	query, err := c.query()
+	if err != nil {
+		return err
+	}
+
+	variables := c.collectionFilter.variableFactory.SymbolTable()
+	variables["@collection"] = c.collectionFilter.collection.TableName
+
+	collection := c.collectionFilter.collection
+	cursor, err := collection.Connection.query(ctx, OpAggregate, collection.TableName, query, variables)
+	if err != nil {
+		return err
+	}
+
+	defer cursor.Close()
+
+	rows := reflect.MakeSlice(slice.Type(), 0, 0)
+	for cursor.HasMore() {
+		row := make(map[string]interface{})
+		if _, err := cursor.ReadDocument(ctx, &row); err != nil {
+			return err
+		}
+
+		element, err := decodeRow(row, slice.Type().Elem())
+		if err != nil {
+			return err
+		}
+		rows = reflect.Append(rows, element)
+	}
+
+	slice.Set(rows)
+	return nil
+}
+
+// decodeRow turns a result row into a value of the slice's element type
+func decodeRow(row map[string]interface{}, elementType reflect.Type) (reflect.Value, error) {
+	if reflect.TypeOf(row).AssignableTo(elementType) {
+		return reflect.ValueOf(row), nil
+	}
+
+	isPointer := elementType.Kind() == reflect.Pointer
+	structType := elementType
+	if isPointer {
+		structType = elementType.Elem()
+	}
+
+	if structType.Kind() != reflect.Struct {
+		return reflect.Value{}, fmt.Errorf("can't decode aggregate rows into %s", elementType)
+	}
+
+	element := reflect.New(structType)
+	if err := encoding.MapToObject(row, element.Interface()); err != nil {
+		return reflect.Value{}, err
+	}
+
+	if isPointer {
+		return element, nil
+	}
+	return element.Elem(), nil
+}
+
+// quoteName makes a name safe to use as an attribute name in an AQL object literal
+func quoteName(name string) string {
+	quoted, _ := json.Marshal(name)
+	return string(quoted)
+}
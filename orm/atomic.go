@@ -0,0 +1,257 @@
+package orm
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/arangodb/go-driver"
+)
+
+// -------------------------------------
+// Atomic updates. Field operations run inside the database in a single UPDATE, so concurrent
+// updates don't lose each other's changes the way read-modify-write does:
+//
+//	record, err := collection.Atomic(id).Inc("views", 1).AddToSet("tags", "go").Set("stats.last_seen", now).One(ctx)
+//	records, err := collection.Query().Filter("team", "red").Atomic().Dec("credits", 5).All(ctx)
+//
+// Fields can be nested paths ("stats.views"). Values always go in bind variables, and field
+// names must be plain identifiers.
+// -------------------------------------
+
+type AtomicUpdate struct {
+	collectionFilter *CollectionFilter
+	operation        string
+	fields           *updateNode
+	unsets           bool
+	err              error
+}
+
+func (c *CollectionFilter) Atomic() *AtomicUpdate {
+	return &AtomicUpdate{collectionFilter: c, operation: OpAtomic, fields: &updateNode{}}
+}
+
+// Atomic updates the document with this id
+func (c *Collection) Atomic(id string) *AtomicUpdate {
+	return c.Query().ById(id).Atomic()
+}
+
+// Atomic updates the organization's document with this id
+func (c *OrgCollection) Atomic(id string) *AtomicUpdate {
+	return c.Query().ById(id).Atomic()
+}
+
+// Inc adds n to the field, a missing field counts as 0
+func (c *AtomicUpdate) Inc(field string, n float64) *AtomicUpdate {
+	return c.set(field, func(attribute string) string {
+		return fmt.Sprintf("%s + %s", attribute, c.variable(n))
+	})
+}
+
+// Dec subtracts n from the field, a missing field counts as 0
+func (c *AtomicUpdate) Dec(field string, n float64) *AtomicUpdate {
+	return c.set(field, func(attribute string) string {
+		return fmt.Sprintf("%s - %s", attribute, c.variable(n))
+	})
+}
+
+func (c *AtomicUpdate) Set(field string, value interface{}) *AtomicUpdate {
+	return c.set(field, func(attribute string) string {
+		return c.variable(value).String()
+	})
+}
+
+// Unset removes the fields from the document. The update then drops every field set to nil.
+func (c *AtomicUpdate) Unset(fields ...string) *AtomicUpdate {
+	c.unsets = true
+	for _, field := range fields {
+		c.set(field, func(attribute string) string {
+			return "null"
+		})
+	}
+	return c
+}
+
+// Push appends the values to the array field
+func (c *AtomicUpdate) Push(field string, values ...interface{}) *AtomicUpdate {
+	return c.set(field, func(attribute string) string {
+		return fmt.Sprintf("APPEND(NOT_NULL(%s, []), %s)", attribute, c.variable(values))
+	})
+}
+
+// AddToSet appends the values the array field doesn't have yet
+func (c *AtomicUpdate) AddToSet(field string, values ...interface{}) *AtomicUpdate {
+	return c.set(field, func(attribute string) string {
+		return fmt.Sprintf("APPEND(NOT_NULL(%s, []), %s, true)", attribute, c.variable(values))
+	})
+}
+
+// Pull removes every occurrence of the values from the array field
+func (c *AtomicUpdate) Pull(field string, values ...interface{}) *AtomicUpdate {
+	return c.set(field, func(attribute string) string {
+		return fmt.Sprintf("REMOVE_VALUES(NOT_NULL(%s, []), %s)", attribute, c.variable(values))
+	})
+}
+
+// Max sets the field to value if that's bigger, or if the field is missing
+func (c *AtomicUpdate) Max(field string, value interface{}) *AtomicUpdate {
+	return c.set(field, func(attribute string) string {
+		return fmt.Sprintf("MAX([%s, %s])", attribute, c.variable(value))
+	})
+}
+
+// Min sets the field to value if that's smaller, or if the field is missing
+func (c *AtomicUpdate) Min(field string, value interface{}) *AtomicUpdate {
+	return c.set(field, func(attribute string) string {
+		return fmt.Sprintf("MIN([%s, %s])", attribute, c.variable(value))
+	})
+}
+
+func (c *AtomicUpdate) variable(value interface{}) Variable {
+	return c.collectionFilter.variableFactory.MakeVariable(value)
+}
+
+// fieldName is what field names (and each part of a path) must look like
+var fieldName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// set adds the operation on a field, the first error sticks
+func (c *AtomicUpdate) set(field string, expression func(attribute string) string) *AtomicUpdate {
+	if c.err != nil {
+		return c
+	}
+
+	path := strings.Split(field, ".")
+	for _, name := range path {
+		if !fieldName.MatchString(name) {
+			c.err = fmt.Errorf("invalid field name %q", field)
+			return c
+		}
+	}
+	if len(path) == 1 && (field == "_key" || field == "_id" || field == "_rev") {
+		c.err = fmt.Errorf("%s can't be updated", field)
+		return c
+	}
+
+	c.err = c.fields.add(path, field, expression((&DocumentAttribute{name: field}).String()))
+	return c
+}
+
+// updateNode is the object given to UPDATE WITH, nested paths become nested objects
+type updateNode struct {
+	expression string
+	children   map[string]*updateNode
+}
+
+func (c *updateNode) add(path []string, field, expression string) error {
+	node := c
+	for _, name := range path {
+		if node.expression != "" {
+			return fmt.Errorf("field %s is updated along with a parent", field)
+		}
+		if node.children == nil {
+			node.children = make(map[string]*updateNode)
+		}
+		if node.children[name] == nil {
+			node.children[name] = &updateNode{}
+		}
+		node = node.children[name]
+	}
+
+	if node.expression != "" {
+		return fmt.Errorf("field %s is updated twice", field)
+	}
+	if len(node.children) > 0 {
+		return fmt.Errorf("field %s is updated along with a nested field", field)
+	}
+	node.expression = expression
+	return nil
+}
+
+func (c *updateNode) String() string {
+	if c.expression != "" {
+		return c.expression
+	}
+
+	names := make([]string, 0, len(c.children))
+	for name := range c.children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fields := make([]string, len(names))
+	for i, name := range names {
+		fields[i] = fmt.Sprintf("%s: %s", name, c.children[name])
+	}
+	return "{ " + strings.Join(fields, ", ") + " }"
+}
+
+// ----------------
+// These functions end the chaining by running the update
+// ----------------
+
+// ToAQL returns the query All would run, and its bind variables
+func (c *AtomicUpdate) ToAQL() (string, map[string]interface{}, error) {
+	if c.err != nil {
+		return "", nil, c.err
+	}
+	if len(c.fields.children) == 0 {
+		return "", nil, fmt.Errorf("atomic update of %s has nothing to update", c.collectionFilter.collection.TableName)
+	}
+	if err := c.collectionFilter.checkScope(); err != nil {
+		return "", nil, err
+	}
+
+	options := ""
+	if c.unsets {
+		options = " OPTIONS { keepNull: false }"
+	}
+
+	query := fmt.Sprintf(`
+FOR doc IN @@collection
+ %s
+ UPDATE doc WITH %s IN @@collection%s
+ RETURN NEW`, c.collectionFilter.formatExpressions(), c.fields, options)
+
+	variables := c.collectionFilter.variableFactory.SymbolTable()
+	variables["@collection"] = c.collectionFilter.collection.TableName
+
+	return query, variables, nil
+}
+
+// All updates every matching document, and returns their new versions
+func (c *AtomicUpdate) All(ctx context.Context) ([]interface{}, error) {
+	query, variables, err := c.ToAQL()
+	if err != nil {
+		return nil, err
+	}
+
+	collection := c.collectionFilter.collection
+	cursor, err := collection.Connection.query(ctx, c.operation, collection.TableName, query, variables)
+	if err != nil {
+		return nil, err
+	}
+
+	defer cursor.Close()
+
+	return readDocs(ctx, cursor, collection.AllocateRecord, collection.Hooks.AfterRead, nil)
+}
+
+// One updates the one matching document and returns its new version, or a not found error if nothing matched
+func (c *AtomicUpdate) One(ctx context.Context) (interface{}, error) {
+	records, err := c.All(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, driver.ArangoError{
+			HasError:     true,
+			Code:         404,
+			ErrorNum:     driver.ErrArangoDocumentNotFound,
+			ErrorMessage: fmt.Sprintf("%s: no document matched the atomic update", c.collectionFilter.collection.TableName),
+		}
+	}
+
+	return records[0], nil
+}
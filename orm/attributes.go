@@ -7,7 +7,8 @@ import "fmt"
 // ---------------------
 
 type DocumentAttribute struct {
-	name string
+	name     string
+	document string // the variable holding the document, defaults to DocumentName
 }
 
 func (c *DocumentAttribute) Name() string {
@@ -15,7 +16,11 @@ func (c *DocumentAttribute) Name() string {
 }
 
 func (c *DocumentAttribute) String() string {
-	return fmt.Sprintf("%s.%s", DocumentName, c.name)
+	document := c.document
+	if document == "" {
+		document = DocumentName
+	}
+	return fmt.Sprintf("%s.%s", document, c.name)
 }
 
 func NewAttribute(name string) interface{} {
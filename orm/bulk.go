@@ -0,0 +1,334 @@
+package orm
+
+import (
+	"context"
+
+	"github.com/arangodb/go-driver"
+	"github.com/ridelabs/simply_arango/encoding"
+)
+
+// -------------------------------------
+// Bulk operations. These use arango's batch document apis, split the input into
+// Collection.BatchSize chunks and report a result per input object, so a few bad
+// documents don't hide what happened to the rest. The error returned alongside the
+// results is for failures of a whole request (connection trouble etc.)
+// -------------------------------------
+
+const DefaultBatchSize = 500
+
+type BulkResult struct {
+	Key string
+	Rev string
+	Err error
+}
+
+type BulkResults []BulkResult
+
+func (c BulkResults) Keys() []string {
+	keys := make([]string, len(c))
+	for i, result := range c {
+		keys[i] = result.Key
+	}
+
+	return keys
+}
+
+// Errors has an entry per input object, nil where it succeeded
+func (c BulkResults) Errors() driver.ErrorSlice {
+	errs := make(driver.ErrorSlice, len(c))
+	for i, result := range c {
+		errs[i] = result.Err
+	}
+
+	return errs
+}
+
+// Err returns the first per-document error, if any
+func (c BulkResults) Err() error {
+	return c.Errors().FirstNonNil()
+}
+
+func (c *Collection) batchSize() int {
+	if c.BatchSize > 0 {
+		return c.BatchSize
+	}
+
+	return DefaultBatchSize
+}
+
+// fail records err against the objects at positions
+func (c BulkResults) fail(positions []int, err error) {
+	for _, i := range positions {
+		c[i].Err = err
+	}
+}
+
+// inBatches calls fn with the start and end of every batch of results. When a batch fails
+// the objects in the batches that were never sent get its error, fn marks the failed batch itself.
+func (c *Collection) inBatches(results BulkResults, fn func(start, end int) error) error {
+	n := len(results)
+	size := c.batchSize()
+	for start := 0; start < n; start += size {
+		end := start + size
+		if end > n {
+			end = n
+		}
+		if err := fn(start, end); err != nil {
+			for i := end; i < n; i++ {
+				results[i].Err = err
+			}
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *Collection) CreateMany(ctx context.Context, objs []interface{}) (BulkResults, error) {
+	results := make(BulkResults, len(objs))
+
+	col, err := c.Connection.Database.Collection(ctx, c.TableName)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.inBatches(results, func(start, end int) error {
+		docs := make([]map[string]interface{}, 0, end-start)
+		positions := make([]int, 0, end-start)
+		for i := start; i < end; i++ {
+			if err := c.beforeCreate(ctx, objs[i]); err != nil {
+				results[i].Err = err
+				continue
+			}
+			if err := c.validate(objs[i]); err != nil {
+				results[i].Err = err
+				continue
+			}
+
+			doc, err := prepareCreate(objs[i])
+			if err != nil {
+				results[i].Err = err
+				continue
+			}
+			docs = append(docs, doc)
+			positions = append(positions, i)
+		}
+
+		if len(docs) == 0 {
+			return nil
+		}
+
+		op := c.Connection.startOperation(ctx, OpCreateMany, c.TableName)
+		var metas driver.DocumentMetaSlice
+		var errs driver.ErrorSlice
+		err := op.retry(func() (err error) {
+			metas, errs, err = col.CreateDocuments(op.ctx, docs)
+			return err
+		})
+		op.end(batchRows(errs), err)
+		if err != nil {
+			results.fail(positions, err)
+			return err
+		}
+
+		for j, i := range positions {
+			results[i] = BulkResult{Key: metas[j].Key, Rev: metas[j].Rev, Err: errs[j]}
+			if errs[j] == nil {
+				results[i].Err = c.afterCreate(ctx, objs[i])
+			}
+		}
+
+		return nil
+	})
+
+	return results, err
+}
+
+type bulkWriter func(ctx context.Context, col driver.Collection, keys []string, docs interface{}) (driver.DocumentMetaSlice, driver.ErrorSlice, error)
+
+// UpdateMany updates each object by its id. Objects carrying a revision are only updated if it still matches.
+func (c *Collection) UpdateMany(ctx context.Context, objs []interface{}) (BulkResults, error) {
+	return c.writeMany(ctx, OpUpdateMany, objs, func(ctx context.Context, col driver.Collection, keys []string, docs interface{}) (driver.DocumentMetaSlice, driver.ErrorSlice, error) {
+		return col.UpdateDocuments(ctx, keys, docs)
+	})
+}
+
+// ReplaceMany replaces each document by its id with the object, rather than merging the changes in
+func (c *Collection) ReplaceMany(ctx context.Context, objs []interface{}) (BulkResults, error) {
+	return c.writeMany(ctx, OpReplaceMany, objs, func(ctx context.Context, col driver.Collection, keys []string, docs interface{}) (driver.DocumentMetaSlice, driver.ErrorSlice, error) {
+		return col.ReplaceDocuments(ctx, keys, docs)
+	})
+}
+
+func (c *Collection) writeMany(ctx context.Context, operation string, objs []interface{}, write bulkWriter) (BulkResults, error) {
+	results := make(BulkResults, len(objs))
+
+	col, err := c.Connection.Database.Collection(ctx, c.TableName)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.inBatches(results, func(start, end int) error {
+		keys := make([]string, 0, end-start)
+		revs := make([]string, 0, end-start)
+		docs := make([]map[string]interface{}, 0, end-start)
+		positions := make([]int, 0, end-start)
+		checkRevisions := false
+
+		for i := start; i < end; i++ {
+			if err := c.beforeUpdate(ctx, objs[i]); err != nil {
+				results[i].Err = err
+				continue
+			}
+			if err := c.validate(objs[i]); err != nil {
+				results[i].Err = err
+				continue
+			}
+
+			doc, err := encoding.ObjectToMap(objs[i])
+			if err != nil {
+				results[i].Err = err
+				continue
+			}
+
+			id, err := getId(doc)
+			if err != nil {
+				results[i].Err = err
+				continue
+			}
+
+			delete(doc, "id") // don't store the id in the database record
+
+			// leave the revision in the document, arango checks it when we don't ignore revisions
+			rev, _ := doc[RevisionKey].(string)
+			if rev == "" {
+				delete(doc, RevisionKey)
+			} else {
+				checkRevisions = true
+			}
+
+			keys = append(keys, id)
+			revs = append(revs, rev)
+			docs = append(docs, doc)
+			positions = append(positions, i)
+		}
+
+		if len(docs) == 0 {
+			return nil
+		}
+
+		op := c.Connection.startOperation(ctx, operation, c.TableName)
+		batchCtx := op.ctx
+		if checkRevisions {
+			batchCtx = driver.WithIgnoreRevisions(op.ctx, false)
+		}
+
+		var metas driver.DocumentMetaSlice
+		var errs driver.ErrorSlice
+		err := op.retry(func() (err error) {
+			metas, errs, err = write(batchCtx, col, keys, docs)
+			return err
+		})
+		op.end(batchRows(errs), err)
+		if err != nil {
+			results.fail(positions, err)
+			return err
+		}
+
+		for j, i := range positions {
+			results[i] = BulkResult{Key: keys[j], Rev: metas[j].Rev, Err: c.conflictError(errs[j], keys[j], revs[j])}
+			if errs[j] == nil {
+				if err := storeRevision(objs[i], metas[j].Rev); err != nil {
+					results[i].Err = err
+				}
+			}
+		}
+
+		return nil
+	})
+
+	return results, err
+}
+
+// DeleteMany removes each object by its id. Objects carrying a revision are only removed if it still matches.
+func (c *Collection) DeleteMany(ctx context.Context, objs []interface{}) (BulkResults, error) {
+	results := make(BulkResults, len(objs))
+
+	col, err := c.Connection.Database.Collection(ctx, c.TableName)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.inBatches(results, func(start, end int) error {
+		// arango takes the revisions for a whole request, so documents with and without one go separately
+		type removal struct {
+			keys      []string
+			revs      []string
+			positions []int
+		}
+		withRev := &removal{}
+		withoutRev := &removal{}
+
+		for i := start; i < end; i++ {
+			if err := c.beforeDelete(ctx, objs[i]); err != nil {
+				results[i].Err = err
+				continue
+			}
+
+			doc, err := encoding.ObjectToMap(objs[i])
+			if err != nil {
+				results[i].Err = err
+				continue
+			}
+
+			id, err := getId(doc)
+			if err != nil {
+				results[i].Err = err
+				continue
+			}
+
+			group := withoutRev
+			rev, _ := doc[RevisionKey].(string)
+			if rev != "" {
+				group = withRev
+			}
+			group.keys = append(group.keys, id)
+			group.revs = append(group.revs, rev)
+			group.positions = append(group.positions, i)
+		}
+
+		groups := []*removal{withoutRev, withRev}
+		for g, group := range groups {
+			if len(group.keys) == 0 {
+				continue
+			}
+
+			op := c.Connection.startOperation(ctx, OpDeleteMany, c.TableName)
+			removeCtx := op.ctx
+			if group == withRev {
+				removeCtx = driver.WithRevisions(op.ctx, group.revs)
+			}
+
+			var errs driver.ErrorSlice
+			err := op.retry(func() (err error) {
+				_, errs, err = col.RemoveDocuments(removeCtx, group.keys)
+				return err
+			})
+			op.end(batchRows(errs), err)
+			if err != nil {
+				for _, unsent := range groups[g:] {
+					results.fail(unsent.positions, err)
+				}
+				return err
+			}
+
+			for j, i := range group.positions {
+				results[i] = BulkResult{Key: group.keys[j], Err: c.conflictError(errs[j], group.keys[j], group.revs[j])}
+			}
+		}
+
+		return nil
+	})
+
+	return results, err
+}
@@ -7,7 +7,6 @@ import (
 	"fmt"
 	"github.com/google/uuid"
 	"github.com/ridelabs/simply_arango/encoding"
-	log "github.com/sirupsen/logrus"
 
 	"github.com/arangodb/go-driver"
 )
@@ -26,24 +25,81 @@ type Collection struct {
 	TableName         string
 	OrganizationIdKey string
 	AllocateRecord    ObjectFactory
+
+	// Indexes are created by Initialize if they don't already exist. OrganizationIdKey gets
+	// an index too unless NoOrganizationIndex is set. PruneIndexes drops indexes not listed
+	// here and recreates the ones whose definition changed.
+	Indexes             []IndexSpec
+	NoOrganizationIndex bool
+	PruneIndexes        bool
+	// LastIndexDiff is what the last Initialize found and did to the indexes, nil before it ran
+	LastIndexDiff *IndexDiff
+
+	// BatchSize is how many documents the bulk operations send per request, DefaultBatchSize if unset
+	BatchSize int
+
+	// RequireOrg makes queries that aren't scoped to an organization fail with ErrUnscopedQuery, see tenant.go
+	RequireOrg bool
+
+	// NoOrganization marks a global collection (organizations, countries, plans...) whose documents
+	// don't belong to an organization. Scoped queries don't filter what they include or traverse from it.
+	NoOrganization bool
+
+	// Hooks run around the writes and reads of every record, see hooks.go
+	Hooks Hooks
+
+	// ValidateRecords checks records before Create, Update and the bulk writes, and SchemaLevel
+	// has Initialize give arangodb the same rules as a JSON schema, see validation.go
+	ValidateRecords bool
+	SchemaLevel     driver.CollectionSchemaLevel
 }
 
 func (c *Collection) Initialize(ctx context.Context) error {
+	return c.initialize(ctx, nil)
+}
+
+func (c *Collection) initialize(ctx context.Context, options *driver.CreateCollectionOptions) error {
 	exists, err := c.Connection.Database.CollectionExists(ctx, c.TableName)
 	if err != nil {
 		return err
 	}
+	schema, err := c.schema()
+	if err != nil {
+		return err
+	}
+
 	if !exists {
-		_, err := c.Connection.Database.CreateCollection(ctx, c.TableName, nil)
+		if schema != nil {
+			if options == nil {
+				options = &driver.CreateCollectionOptions{}
+			}
+			options.Schema = schema
+		}
+		_, err := c.Connection.Database.CreateCollection(ctx, c.TableName, options)
 		if err != nil {
 			return err
 		}
+	} else if schema != nil {
+		col, err := c.Connection.Database.Collection(ctx, c.TableName)
+		if err != nil {
+			return err
+		}
+		if err := col.SetProperties(ctx, driver.SetCollectionPropertiesOptions{Schema: schema}); err != nil {
+			return err
+		}
 	}
 
 	if c.OrganizationIdKey == "" {
 		c.OrganizationIdKey = "organization_id"
 	}
 
+	diff, err := c.SyncIndexes(ctx)
+	if err != nil {
+		return err
+	}
+	c.LastIndexDiff = diff
+	c.logIndexDiff(ctx, diff)
+
 	return nil
 }
 
@@ -59,6 +115,11 @@ func (c *Collection) Drop(ctx context.Context) error {
 	return col.Remove(ctx)
 }
 
+// Handle returns the document handle (collection/key) for an id in this collection, as used by _from and _to in edges
+func (c *Collection) Handle(id string) string {
+	return c.TableName + "/" + id
+}
+
 // users of this api must supply an id with their objects
 func getId(doc map[string]interface{}) (string, error) {
 	if id, exists := doc["id"]; !exists {
@@ -72,6 +133,7 @@ func getId(doc map[string]interface{}) (string, error) {
 	}
 }
 
+// Increment adds 1 to a field of the record, see Atomic for other field operations
 func (c *Collection) Increment(ctx context.Context, obj interface{}, varName string) error {
 	// get the object details
 	doc, err := encoding.ObjectToMap(obj)
@@ -84,29 +146,16 @@ func (c *Collection) Increment(ctx context.Context, obj interface{}, varName str
 		return err
 	}
 
-	organizationId, ok := doc[c.OrganizationIdKey]
+	organizationId, ok := doc[c.organizationIdKey()]
 	if !ok {
-		return errors.New("must have organization_id in record")
-	}
-
-	// build query
-	query := `FOR d IN @@collection
-  FILTER d._key == @key && d.organization_id == @org_id
-  UPDATE d WITH { ` + varName + `: d.` + varName + ` + 1 } IN @@collection
-	`
-	cursor, err := c.Connection.Database.Query(ctx, query, map[string]interface{}{
-		"@collection": c.TableName,
-		"key":         id,
-		"org_id":      organizationId,
-	})
-
-	if err != nil {
-		return err
+		return fmt.Errorf("must have %s in record", c.organizationIdKey())
 	}
 
-	defer cursor.Close()
+	update := c.Query().ById(id).WithinOrg(fmt.Sprint(organizationId)).Atomic().Inc(varName, 1)
+	update.operation = OpIncrement
 
-	return nil
+	_, err = update.All(ctx)
+	return err
 }
 
 func (c *Collection) Get(ctx context.Context, id string) (interface{}, error) {
@@ -117,13 +166,26 @@ func (c *Collection) Get(ctx context.Context, id string) (interface{}, error) {
 	}
 
 	// read the doc
-	return ReadDoc(c.AllocateRecord, func(doc map[string]interface{}) error {
-		_, err := collection.ReadDocument(ctx, id, &doc)
-		return err
+	op := c.Connection.startOperation(ctx, OpGet, c.TableName)
+	obj, err := readDoc(ctx, c.AllocateRecord, c.Hooks.AfterRead, func(doc map[string]interface{}) error {
+		return op.retry(func() error {
+			_, err := collection.ReadDocument(op.ctx, id, &doc)
+			return err
+		})
 	})
+	op.end(rowCount(err), err)
+
+	return obj, err
 }
 
 func (c *Collection) Update(ctx context.Context, obj interface{}) error {
+	if err := c.beforeUpdate(ctx, obj); err != nil {
+		return err
+	}
+	if err := c.validate(obj); err != nil {
+		return err
+	}
+
 	// get the object ready to update
 	doc, err := encoding.ObjectToMap(obj)
 	if err != nil {
@@ -136,6 +198,7 @@ func (c *Collection) Update(ctx context.Context, obj interface{}) error {
 	}
 
 	delete(doc, "id") // don't store the id in the database record
+	ctx, rev := withRevision(ctx, doc)
 
 	collection, err := c.Connection.Database.Collection(ctx, c.TableName)
 	if err != nil {
@@ -143,25 +206,47 @@ func (c *Collection) Update(ctx context.Context, obj interface{}) error {
 	}
 
 	// store it
-	fmt.Printf("sending in update for database=%s, table=%s, id=%s, doc=%+v\n", c.Connection.Database.Name(), c.TableName, id, doc)
-	meta, err := collection.UpdateDocument(ctx, id, doc)
-	fmt.Printf("meta=%+v, err=%s\n", meta, err)
-	if err != nil {
+	op := c.Connection.startOperation(ctx, OpUpdate, c.TableName)
+	var meta driver.DocumentMeta
+	err = op.retry(func() (err error) {
+		meta, err = collection.UpdateDocument(op.ctx, id, doc)
 		return err
+	})
+	op.end(rowCount(err), err)
+	if err != nil {
+		return c.conflictError(err, id, rev)
 	}
 
-	return nil
+	return storeRevision(obj, meta.Rev)
 }
 
-func (c *Collection) Create(ctx context.Context, obj interface{}) (string, error) {
-	// get the object ready to update
+// prepareCreate gets an object ready to be stored as a new document
+func prepareCreate(obj interface{}) (map[string]interface{}, error) {
 	doc, err := encoding.ObjectToMap(obj)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	doc["_key"] = uuid.NewString() // convert id to a key for arango's meta key
 	delete(doc, "id")              // don't store the id in the database record
+	delete(doc, RevisionKey)       // arango assigns the revision
+
+	return doc, nil
+}
+
+func (c *Collection) Create(ctx context.Context, obj interface{}) (string, error) {
+	if err := c.beforeCreate(ctx, obj); err != nil {
+		return "", err
+	}
+	if err := c.validate(obj); err != nil {
+		return "", err
+	}
+
+	// get the object ready to create
+	doc, err := prepareCreate(obj)
+	if err != nil {
+		return "", err
+	}
 
 	collection, err := c.Connection.Database.Collection(ctx, c.TableName)
 	if err != nil {
@@ -169,15 +254,25 @@ func (c *Collection) Create(ctx context.Context, obj interface{}) (string, error
 	}
 
 	// store it
-	meta, err := collection.CreateDocument(ctx, doc)
+	op := c.Connection.startOperation(ctx, OpCreate, c.TableName)
+	var meta driver.DocumentMeta
+	err = op.retry(func() (err error) {
+		meta, err = collection.CreateDocument(op.ctx, doc)
+		return err
+	})
+	op.end(rowCount(err), err)
 	if err != nil {
-		return "", nil
+		return "", err
 	}
 
-	return meta.Key, nil
+	return meta.Key, c.afterCreate(ctx, obj)
 }
 
 func (c *Collection) Delete(ctx context.Context, obj interface{}) error {
+	if err := c.beforeDelete(ctx, obj); err != nil {
+		return err
+	}
+
 	// get the object ready to update
 	doc, err := encoding.ObjectToMap(obj)
 	if err != nil {
@@ -189,15 +284,23 @@ func (c *Collection) Delete(ctx context.Context, obj interface{}) error {
 		return err
 	}
 
+	ctx, rev := withRevision(ctx, doc)
+
 	collection, err := c.Connection.Database.Collection(ctx, c.TableName)
 	if err != nil {
 		return err
 	}
 
 	// un-store it
-	k, err := collection.RemoveDocument(ctx, id)
-	if err != nil {
+	op := c.Connection.startOperation(ctx, OpDelete, c.TableName)
+	var k driver.DocumentMeta
+	err = op.retry(func() (err error) {
+		k, err = collection.RemoveDocument(op.ctx, id)
 		return err
+	})
+	op.end(rowCount(err), err)
+	if err != nil {
+		return c.conflictError(err, id, rev)
 	}
 
 	if k.Key != id {
@@ -225,6 +328,13 @@ type CollectionFilter struct {
 	collection      *Collection
 	expressions     []interface{}
 	variableFactory *VariableFactory
+
+	// searching a view instead of the collection, see search.go
+	view     *SearchView
+	searches []Expression
+
+	orgScoped bool   // WithinOrg was used
+	orgId     string // and the organization it was given
 }
 
 func (c *CollectionFilter) Operator() *Operator {
@@ -265,6 +375,7 @@ func (c *CollectionFilter) Filter(key string, value interface{}) *CollectionFilt
 
 func (c *CollectionFilter) formatExpressions() string {
 	var buffer bytes.Buffer
+	buffer.WriteString(c.formatSearch())
 	for _, expression := range c.expressions {
 		buffer.WriteString(fmt.Sprintf("FILTER %s\n", expression))
 	}
@@ -294,8 +405,10 @@ func (c *CollectionFilter) ArrayEmpty(arrayName string) *CollectionFilter {
 // ---------------------
 
 func (c *CollectionFilter) WithinOrg(orgId string) *CollectionFilter {
+	c.orgScoped = true
+	c.orgId = orgId
 	return c.Where(&EqualityExpression{
-		left:     &DocumentAttribute{name: c.collection.OrganizationIdKey},
+		left:     &DocumentAttribute{name: c.collection.organizationIdKey()},
 		operator: EqualityExpressionEqual,
 		right:    c.variableFactory.MakeVariable(orgId),
 	})
@@ -327,6 +440,10 @@ func (c *CollectionFilter) First(ctx context.Context) (interface{}, error) {
 }
 
 func (c *CollectionFilter) Count(ctx context.Context) (int, error) {
+	if err := c.checkScope(); err != nil {
+		return -1, err
+	}
+
 	query := fmt.Sprintf(`
 FOR doc IN @@collection
  %s
@@ -335,9 +452,8 @@ FOR doc IN @@collection
 
 	variables := c.variableFactory.SymbolTable()
 	variables["@collection"] = c.collection.TableName
-	log.Info("ORM Count ", log.Fields{"query": query, "filters": variables})
 
-	cursor, err := c.collection.Connection.Database.Query(ctx, query, variables)
+	cursor, err := c.collection.Connection.query(ctx, OpCount, c.collection.TableName, query, variables)
 
 	if err != nil {
 		return -1, err
@@ -361,6 +477,10 @@ FOR doc IN @@collection
 // ----------------
 
 func (c *CollectionFilter) DeleteAll(ctx context.Context) ([]string, error) {
+	if err := c.checkScope(); err != nil {
+		return nil, err
+	}
+
 	query := fmt.Sprintf(`
 FOR doc IN @@collection
  %s
@@ -371,9 +491,7 @@ LET removed = OLD
 	variables := c.variableFactory.SymbolTable()
 	variables["@collection"] = c.collection.TableName
 
-	log.Info("ORM ", log.Fields{"query": query, "filters": variables})
-
-	cursor, err := c.collection.Connection.Database.Query(ctx, query, variables)
+	cursor, err := c.collection.Connection.query(ctx, OpDeleteAll, c.collection.TableName, query, variables)
 
 	if err != nil {
 		return nil, err
@@ -395,46 +513,16 @@ LET removed = OLD
 	return ids, nil
 }
 
-func (c *CollectionFilter) formatUpdates(updates map[string]interface{}) string {
-	var buffer bytes.Buffer
-	buffer.WriteString("{")
-	for k, v := range updates {
-		buffer.WriteString(fmt.Sprintf("%s:%s", k, c.variableFactory.MakeVariable(v)))
-	}
-	buffer.WriteString("}")
-
-	return buffer.String()
-}
-
+// UpdateAll sets the fields of every matching document and returns their keys, see update.go
 func (c *CollectionFilter) UpdateAll(ctx context.Context, updates map[string]interface{}) ([]string, error) {
-	query := fmt.Sprintf(`
-FOR doc IN @@collection
- %s
- UPDATE doc with %s in @@collection
- RETURN doc._key`, c.formatExpressions(), c.formatUpdates(updates))
-
-	variables := c.variableFactory.SymbolTable()
-	variables["@collection"] = c.collection.TableName
-
-	log.Info("ORM ", log.Fields{"query": query, "filters": variables})
-
-	cursor, err := c.collection.Connection.Database.Query(ctx, query, variables)
-
+	results, err := c.UpdateAllWith(ctx, updates, UpdateOptions{})
 	if err != nil {
 		return nil, err
 	}
 
-	defer cursor.Close()
-
-	// read the ids
-	ids := make([]string, 0)
-	for cursor.HasMore() {
-		var modifiedId string
-		_, err := cursor.ReadDocument(ctx, &modifiedId)
-		if err != nil {
-			return nil, err
-		}
-		ids = append(ids, modifiedId)
+	ids := make([]string, len(results))
+	for i, result := range results {
+		ids[i] = result.Key
 	}
 
 	return ids, nil
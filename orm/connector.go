@@ -3,58 +3,35 @@ package orm
 import (
 	"context"
 	"github.com/arangodb/go-driver"
-	"github.com/arangodb/go-driver/http"
-	log "github.com/sirupsen/logrus"
 )
 
 type Connection struct {
 	Database driver.Database
 	Client   driver.Client
-}
-
-func NewConnection(ctx context.Context, databaseName, dbUser, dbPass, dbUrl string) (*Connection, error) {
-	conn, err := http.NewConnection(http.ConnectionConfig{
-		Endpoints: []string{dbUrl},
-	})
-	if err != nil {
-		return nil, err
-	}
 
-	arangoClient, err := driver.NewClient(driver.ClientConfig{
-		Connection:     conn,
-		Authentication: driver.BasicAuthentication(dbUser, dbPass),
-	})
-	if err != nil {
-		return nil, err
-	}
+	// PageTokenSecret signs keyset page tokens, set it to keep tokens valid across restarts and instances
+	PageTokenSecret []byte
 
-	if err != nil {
-		log.Error("Failed to connect to arangodb", log.Fields{"err": err})
-		return nil, err
-		//os.Exit(3)
-	}
+	// Logger gets a line for each database operation, see logging.go. Nothing is logged when it's nil.
+	Logger    Logger
+	LogLevels map[string]Level // by operation, DefaultLogLevel for the rest
+	Redaction *Redaction       // DefaultRedaction when nil
 
-	exists, err := arangoClient.DatabaseExists(ctx, databaseName)
-	if err != nil {
-		log.Error("Failed query for database!", log.Fields{"err": err})
-		return nil, err
-	}
+	// RetryPolicy retries operations that fail with transient errors, see retry.go. Nothing is retried when it's nil.
+	RetryPolicy *RetryPolicy
 
-	var db driver.Database
-	if !exists {
-		db, err = arangoClient.CreateDatabase(ctx, databaseName, nil)
-		if err != nil {
-			return nil, err
-		}
-	} else {
-		db, err = arangoClient.Database(ctx, databaseName)
-		if err != nil {
-			return nil, err
-		}
-	}
+	// Telemetry traces and measures each database operation, see telemetry.go
+	Telemetry *Telemetry
+}
 
-	return &Connection{
-		Database: db,
-		Client:   arangoClient,
-	}, nil
+// NewConnection connects to a single server with basic auth, and creates the database if it doesn't exist.
+// See Connect for everything else.
+func NewConnection(ctx context.Context, databaseName, dbUser, dbPass, dbUrl string) (*Connection, error) {
+	return Connect(ctx, ConnectionOptions{
+		Endpoints:               []string{dbUrl},
+		Database:                databaseName,
+		Username:                dbUser,
+		Password:                dbPass,
+		CreateDatabaseIfMissing: true,
+	})
 }
@@ -0,0 +1,289 @@
+package orm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/arangodb/go-driver"
+	"github.com/ridelabs/simply_arango/encoding"
+)
+
+// -------------------------------------
+// Edge collections. Edges are regular records that also carry _from and _to
+// document handles (see Collection.Handle), so tag those fields on your edge type:
+//
+//	type Follows struct {
+//		Id   string `json:"id"`
+//		From string `json:"_from"`
+//		To   string `json:"_to"`
+//	}
+// -------------------------------------
+
+const (
+	EdgeFromKey = "_from"
+	EdgeToKey   = "_to"
+)
+
+type EdgeCollection struct {
+	*Collection
+}
+
+func (c *EdgeCollection) Initialize(ctx context.Context) error {
+	return c.Collection.initialize(ctx, &driver.CreateCollectionOptions{
+		Type: driver.CollectionTypeEdge,
+	})
+}
+
+func (c *EdgeCollection) Create(ctx context.Context, obj interface{}) (string, error) {
+	doc, err := encoding.ObjectToMap(obj)
+	if err != nil {
+		return "", err
+	}
+
+	for _, key := range []string{EdgeFromKey, EdgeToKey} {
+		if handle, ok := doc[key].(string); !ok || handle == "" {
+			return "", fmt.Errorf("edges in %s must have a %s document handle", c.TableName, key)
+		}
+	}
+
+	return c.Collection.Create(ctx, obj)
+}
+
+// Connect creates an edge between the from and to document handles, edgeData (which may be nil) is stored on the edge.
+// A record passed by pointer gets the handles set and is created itself, so its hooks and validation run.
+func (c *EdgeCollection) Connect(ctx context.Context, from, to string, edgeData interface{}) (string, error) {
+	handles := map[string]interface{}{EdgeFromKey: from, EdgeToKey: to}
+
+	record := edgeData
+	switch data := edgeData.(type) {
+	case nil:
+		record = handles
+	case map[string]interface{}:
+		doc := make(map[string]interface{}, len(data)+2)
+		for key, value := range data {
+			doc[key] = value
+		}
+		for key, value := range handles {
+			doc[key] = value
+		}
+		record = doc
+	default:
+		if reflect.ValueOf(edgeData).Kind() == reflect.Ptr {
+			if err := encoding.MapToObject(handles, edgeData); err != nil {
+				return "", err
+			}
+			break
+		}
+
+		// a struct value can't be changed, so it's stored as a document
+		doc, err := encoding.ObjectToMap(edgeData)
+		if err != nil {
+			return "", err
+		}
+		for key, value := range handles {
+			doc[key] = value
+		}
+		record = doc
+	}
+
+	return c.Create(ctx, record)
+}
+
+// Disconnect removes every edge going from -> to, returning the ids of the removed edges
+func (c *EdgeCollection) Disconnect(ctx context.Context, from, to string) ([]string, error) {
+	if from == "" || to == "" {
+		return nil, errors.New("disconnect needs both a from and a to document handle")
+	}
+
+	return c.Query().Filter(EdgeFromKey, from).Filter(EdgeToKey, to).DeleteAll(ctx)
+}
+
+// ----------------
+// Traversals
+// ----------------
+
+const (
+	VertexName = "v"
+	EdgeName   = "e"
+	PathName   = "p"
+)
+
+type TraversalDirection string
+
+const TraversalOutbound = TraversalDirection("OUTBOUND")
+const TraversalInbound = TraversalDirection("INBOUND")
+const TraversalAny = TraversalDirection("ANY")
+
+type Traversal struct {
+	collectionFilter *CollectionFilter
+	edges            *EdgeCollection
+	direction        TraversalDirection
+	minDepth         int
+	maxDepth         int
+	expressions      []interface{}
+	vertices         *Collection
+	limit            Variable
+}
+
+// Traverse walks the graph from every document matched by this filter along the given edges.
+// By default it goes one step OUTBOUND and the vertices are read as records of this collection.
+func (c *CollectionFilter) Traverse(edges *EdgeCollection) *Traversal {
+	return &Traversal{
+		collectionFilter: c,
+		edges:            edges,
+		direction:        TraversalOutbound,
+		minDepth:         1,
+		maxDepth:         1,
+		expressions:      make([]interface{}, 0),
+		vertices:         c.collection,
+	}
+}
+
+func (c *Traversal) Outbound() *Traversal {
+	c.direction = TraversalOutbound
+	return c
+}
+
+func (c *Traversal) Inbound() *Traversal {
+	c.direction = TraversalInbound
+	return c
+}
+
+func (c *Traversal) Any() *Traversal {
+	c.direction = TraversalAny
+	return c
+}
+
+func (c *Traversal) Depth(min, max int) *Traversal {
+	c.minDepth = min
+	c.maxDepth = max
+	return c
+}
+
+// Into reads the vertices as records of another collection, for when the edges lead to a different vertex collection
+func (c *Traversal) Into(vertices *Collection) *Traversal {
+	c.vertices = vertices
+	return c
+}
+
+// Operator makes expressions about the vertices (v) found by the traversal
+func (c *Traversal) Operator() *Operator {
+	return &Operator{
+		variableFactory: c.collectionFilter.variableFactory,
+		document:        VertexName,
+	}
+}
+
+// EdgeOperator makes expressions about the edges (e) walked by the traversal
+func (c *Traversal) EdgeOperator() *Operator {
+	return &Operator{
+		variableFactory: c.collectionFilter.variableFactory,
+		document:        EdgeName,
+	}
+}
+
+func (c *Traversal) Where(expression Expression) *Traversal {
+	c.expressions = append(c.expressions, expression)
+	return c
+}
+
+// Filter restricts the vertices found to ones where key equals value
+func (c *Traversal) Filter(key string, value interface{}) *Traversal {
+	return c.Where(c.Operator().Equal(key, value))
+}
+
+// FilterEdge restricts the traversal to edges where key equals value
+func (c *Traversal) FilterEdge(key string, value interface{}) *Traversal {
+	return c.Where(c.EdgeOperator().Equal(key, value))
+}
+
+func (c *Traversal) Limit(count int) *Traversal {
+	c.limit = c.collectionFilter.variableFactory.MakeVariable(count)
+	return c
+}
+
+func (c *Traversal) formatQuery() string {
+	edges := c.collectionFilter.variableFactory.MakeCollectionVariable(c.edges.TableName)
+
+	var filters string
+	if org := c.collectionFilter.orgVariable(); org != nil {
+		// every vertex on the path from the scoped collections, so other organizations' documents
+		// aren't walked through either. Vertices of other collections are left alone.
+		for _, vertices := range c.scopedVertices() {
+			filters += fmt.Sprintf("  FILTER %s.vertices[* FILTER IS_SAME_COLLECTION(%s, CURRENT)].%s ALL == %s\n", PathName,
+				c.collectionFilter.variableFactory.MakeVariable(vertices.TableName), vertices.organizationKey(), org)
+		}
+	}
+	for _, expression := range c.expressions {
+		filters += fmt.Sprintf("  FILTER %s\n", expression)
+	}
+
+	var limit string
+	if c.limit != nil {
+		limit = fmt.Sprintf("LIMIT %s", c.limit)
+	}
+
+	return fmt.Sprintf(`
+FOR doc IN @@collection
+ %s
+ FOR %s, %s, %s IN %d..%d %s doc %s
+%s
+  %s
+  RETURN DISTINCT %s`, c.collectionFilter.formatExpressions(), VertexName, EdgeName, PathName,
+		c.minDepth, c.maxDepth, c.direction, edges, filters, limit, VertexName)
+}
+
+// scopedVertices are the collections the traversal starts from and reads, unless they're NoOrganization
+func (c *Traversal) scopedVertices() []*Collection {
+	collections := []*Collection{c.collectionFilter.collection}
+	if c.vertices.TableName != c.collectionFilter.collection.TableName {
+		collections = append(collections, c.vertices)
+	}
+
+	scoped := make([]*Collection, 0, len(collections))
+	for _, collection := range collections {
+		if collection.organizationKey() != "" {
+			scoped = append(scoped, collection)
+		}
+	}
+
+	return scoped
+}
+
+func (c *Traversal) First(ctx context.Context) (interface{}, error) {
+	matches, err := c.Limit(1).All(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(matches) < 1 {
+		return nil, nil
+	}
+
+	return matches[0], nil
+}
+
+func (c *Traversal) All(ctx context.Context) ([]interface{}, error) {
+	if c.minDepth < 0 || c.maxDepth < c.minDepth {
+		return nil, fmt.Errorf("invalid traversal depth %d..%d", c.minDepth, c.maxDepth)
+	}
+	if err := c.collectionFilter.checkScope(); err != nil {
+		return nil, err
+	}
+
+	query := c.formatQuery()
+	variables := c.collectionFilter.variableFactory.SymbolTable()
+	variables["@collection"] = c.collectionFilter.collection.TableName
+
+	collection := c.collectionFilter.collection
+	cursor, err := collection.Connection.query(ctx, OpTraverse, collection.TableName, query, variables)
+	if err != nil {
+		return nil, err
+	}
+
+	defer cursor.Close()
+
+	return readDocs(ctx, cursor, c.vertices.AllocateRecord, c.vertices.Hooks.AfterRead, nil)
+}
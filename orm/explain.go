@@ -0,0 +1,329 @@
+package orm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"path"
+	"time"
+
+	"github.com/arangodb/go-driver"
+)
+
+// -------------------------------------
+// Query inspection. ToAQL shows the query a chain builds without running it, Explain asks
+// arangodb how it would run it, and Profile runs it and reports what actually happened.
+// Handy for asserting in CI that hot queries use their indexes:
+//
+//	plan, err := users.Query().Filter("email", email).List().Explain(ctx)
+//	assert.True(t, plan.UsesIndex("idx_persistent_email"))
+//	assert.Empty(t, plan.FullCollectionScans())
+// -------------------------------------
+
+// ToAQL returns the query List().All() would run, and its bind variables
+func (c *CollectionFilter) ToAQL() (string, map[string]interface{}, error) {
+	return c.List().ToAQL()
+}
+
+func (c *CollectionFilter) Explain(ctx context.Context) (*QueryPlan, error) {
+	return c.List().Explain(ctx)
+}
+
+func (c *CollectionFilter) Profile(ctx context.Context) (*QueryProfile, error) {
+	return c.List().Profile(ctx)
+}
+
+// ToAQL returns the query All would run, and its bind variables
+func (c *ItemsOperator) ToAQL() (string, map[string]interface{}, error) {
+	return c.build()
+}
+
+// Explain asks arangodb for the execution plan of the query, without running it
+func (c *ItemsOperator) Explain(ctx context.Context) (*QueryPlan, error) {
+	query, variables, err := c.build()
+	if err != nil {
+		return nil, err
+	}
+
+	collection := c.collectionFilter.collection
+	return collection.Connection.explain(ctx, collection.TableName, query, variables)
+}
+
+// Profile runs the query (throwing the results away) and returns the plan it ran with and where the time went
+func (c *ItemsOperator) Profile(ctx context.Context) (*QueryProfile, error) {
+	query, variables, err := c.build()
+	if err != nil {
+		return nil, err
+	}
+
+	collection := c.collectionFilter.collection
+	cursor, err := collection.Connection.query(driver.WithQueryProfile(ctx, 2), OpProfile, collection.TableName, query, variables)
+	if err != nil {
+		return nil, err
+	}
+
+	defer cursor.Close()
+
+	return readProfile(cursor)
+}
+
+// ----------------
+// Plans
+// ----------------
+
+type QueryPlan struct {
+	Nodes               []PlanNode
+	Rules               []string // the optimizer rules that were applied
+	Collections         []PlanCollection
+	EstimatedCost       float64
+	EstimatedNrItems    int64
+	IsModificationQuery bool
+	Cacheable           bool
+	Warnings            []QueryWarning
+}
+
+type PlanNode struct {
+	Id               int
+	Type             string // EnumerateCollectionNode, IndexNode, FilterNode, ...
+	Dependencies     []int
+	EstimatedCost    float64
+	EstimatedNrItems int64
+	Collection       string
+	Indexes          []PlanIndex
+}
+
+type PlanIndex struct {
+	Id     string
+	Name   string
+	Type   string
+	Fields []string
+	Unique bool
+	Sparse bool
+}
+
+type PlanCollection struct {
+	Name string `json:"name"`
+	Type string `json:"type"` // read, write or exclusive
+}
+
+type QueryWarning struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// IndexesUsed lists each index the plan uses once
+func (c *QueryPlan) IndexesUsed() []PlanIndex {
+	seen := make(map[string]bool)
+	indexes := make([]PlanIndex, 0)
+	for _, node := range c.Nodes {
+		for _, index := range node.Indexes {
+			if !seen[index.Id] {
+				seen[index.Id] = true
+				indexes = append(indexes, index)
+			}
+		}
+	}
+
+	return indexes
+}
+
+// UsesIndex tells if the plan uses the index with this name
+func (c *QueryPlan) UsesIndex(name string) bool {
+	for _, index := range c.IndexesUsed() {
+		if index.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// FullCollectionScans lists the collections the plan reads from start to end, rather than through an index
+func (c *QueryPlan) FullCollectionScans() []string {
+	collections := make([]string, 0)
+	for _, node := range c.Nodes {
+		if node.Type == "EnumerateCollectionNode" {
+			collections = append(collections, node.Collection)
+		}
+	}
+
+	return collections
+}
+
+// planJSON is the plan the way arangodb describes it
+type planJSON struct {
+	Nodes []struct {
+		Id               int        `json:"id"`
+		Type             string     `json:"type"`
+		Dependencies     []int      `json:"dependencies"`
+		EstimatedCost    float64    `json:"estimatedCost"`
+		EstimatedNrItems int64      `json:"estimatedNrItems"`
+		Collection       string     `json:"collection"`
+		Indexes          []rawIndex `json:"indexes"`
+	} `json:"nodes"`
+	Rules               []string         `json:"rules"`
+	Collections         []PlanCollection `json:"collections"`
+	EstimatedCost       float64          `json:"estimatedCost"`
+	EstimatedNrItems    int64            `json:"estimatedNrItems"`
+	IsModificationQuery bool             `json:"isModificationQuery"`
+}
+
+func (c *planJSON) plan() *QueryPlan {
+	plan := &QueryPlan{
+		Nodes:               make([]PlanNode, 0, len(c.Nodes)),
+		Rules:               c.Rules,
+		Collections:         c.Collections,
+		EstimatedCost:       c.EstimatedCost,
+		EstimatedNrItems:    c.EstimatedNrItems,
+		IsModificationQuery: c.IsModificationQuery,
+	}
+
+	for _, node := range c.Nodes {
+		indexes := make([]PlanIndex, 0, len(node.Indexes))
+		for _, index := range node.Indexes {
+			spec := index.spec()
+			indexes = append(indexes, PlanIndex{
+				Id:     index.Id,
+				Name:   index.Name,
+				Type:   index.Type,
+				Fields: spec.Fields,
+				Unique: index.Unique,
+				Sparse: index.Sparse,
+			})
+		}
+
+		plan.Nodes = append(plan.Nodes, PlanNode{
+			Id:               node.Id,
+			Type:             node.Type,
+			Dependencies:     node.Dependencies,
+			EstimatedCost:    node.EstimatedCost,
+			EstimatedNrItems: node.EstimatedNrItems,
+			Collection:       node.Collection,
+			Indexes:          indexes,
+		})
+	}
+
+	return plan
+}
+
+// ExplainQuery asks arangodb's explain api for the plan of any query. The go driver can't
+// explain queries, so this needs a Connection with a Client.
+func ExplainQuery(ctx context.Context, conn *Connection, query string, bindVars map[string]interface{}) (*QueryPlan, error) {
+	if conn == nil {
+		return nil, errors.New("explaining queries needs a Connection with a Client")
+	}
+
+	return conn.explain(ctx, "", query, bindVars)
+}
+
+// explain runs the explain request as an operation, so it's logged, traced and retried like the others
+func (c *Connection) explain(ctx context.Context, collection, query string, bindVars map[string]interface{}) (*QueryPlan, error) {
+	if c.Client == nil {
+		return nil, errors.New("explaining queries needs a Connection with a Client")
+	}
+
+	op := c.startOperation(ctx, OpExplain, collection)
+	op.query, op.bindVars = query, bindVars
+
+	var plan *QueryPlan
+	err := op.retry(func() (err error) {
+		plan, err = c.requestPlan(op.ctx, query, bindVars)
+		return err
+	})
+	op.end(0, err)
+	if err != nil {
+		return nil, err
+	}
+
+	return plan, nil
+}
+
+func (c *Connection) requestPlan(ctx context.Context, query string, bindVars map[string]interface{}) (*QueryPlan, error) {
+	client := c.Client.Connection()
+	req, err := client.NewRequest("POST", path.Join("_db", url.PathEscape(c.Database.Name()), "_api/explain"))
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := req.SetBody(map[string]interface{}{"query": query, "bindVars": bindVars}); err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Do(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if err := resp.CheckStatus(200); err != nil {
+		return nil, err
+	}
+
+	var explained struct {
+		Plan      planJSON       `json:"plan"`
+		Cacheable bool           `json:"cacheable"`
+		Warnings  []QueryWarning `json:"warnings"`
+	}
+	if err := resp.ParseBody("", &explained); err != nil {
+		return nil, err
+	}
+
+	plan := explained.Plan.plan()
+	plan.Cacheable = explained.Cacheable
+	plan.Warnings = explained.Warnings
+
+	return plan, nil
+}
+
+// ----------------
+// Profiles
+// ----------------
+
+type QueryProfile struct {
+	Plan *QueryPlan
+
+	// Phases is how long (in seconds) each phase took: parsing, optimizing plan, executing, ...
+	Phases map[string]float64
+
+	WritesExecuted int64
+	ScannedFull    int64 // documents read by full collection scans
+	ScannedIndex   int64 // documents read through indexes
+	Filtered       int64
+	ExecutionTime  time.Duration
+}
+
+func readProfile(cursor driver.Cursor) (*QueryProfile, error) {
+	extra := cursor.Extra()
+	if extra == nil {
+		return nil, errors.New("the database didn't return a query profile")
+	}
+
+	profile := &QueryProfile{Phases: make(map[string]float64)}
+
+	if raw, ok, err := extra.GetProfileRaw(); err != nil {
+		return nil, err
+	} else if ok {
+		if err := json.Unmarshal(raw, &profile.Phases); err != nil {
+			return nil, err
+		}
+	}
+
+	if raw, ok, err := extra.GetPlanRaw(); err != nil {
+		return nil, err
+	} else if ok {
+		var plan planJSON
+		if err := json.Unmarshal(raw, &plan); err != nil {
+			return nil, err
+		}
+		profile.Plan = plan.plan()
+	}
+
+	if stats := cursor.Statistics(); stats != nil {
+		profile.WritesExecuted = stats.WritesExecuted()
+		profile.ScannedFull = stats.ScannedFull()
+		profile.ScannedIndex = stats.ScannedIndex()
+		profile.Filtered = stats.Filtered()
+		profile.ExecutionTime = stats.ExecutionTime()
+	}
+
+	return profile, nil
+}
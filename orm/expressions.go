@@ -1,6 +1,9 @@
 package orm
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
 
 // ---------------------
 // logical operators
@@ -109,3 +112,53 @@ func (c *NativeExpression) String() string {
 func RawValue(value interface{}) Expression {
 	return &NativeExpression{value: value}
 }
+
+// ---------------------
+// geo operators
+// ---------------------
+
+type GeoDistanceExpression struct {
+	left      interface{}
+	longitude interface{}
+	latitude  interface{}
+}
+
+func (c *GeoDistanceExpression) String() string {
+	return fmt.Sprintf("GEO_DISTANCE(%s, GEO_POINT(%s, %s))", c.left, c.longitude, c.latitude)
+}
+
+type GeoContainsExpression struct {
+	shape interface{}
+	right interface{}
+}
+
+func (c *GeoContainsExpression) String() string {
+	return fmt.Sprintf("GEO_CONTAINS(%s, %s) ", c.shape, c.right)
+}
+
+// ---------------------
+// computed values
+// ---------------------
+
+type ArithmeticExpression struct {
+	left     Expression
+	operator string
+	right    Expression
+}
+
+func (c *ArithmeticExpression) String() string {
+	return fmt.Sprintf("(%s %s %s)", c.left, c.operator, c.right)
+}
+
+type FunctionExpression struct {
+	name      string
+	arguments []Expression
+}
+
+func (c *FunctionExpression) String() string {
+	arguments := make([]string, len(c.arguments))
+	for i, argument := range c.arguments {
+		arguments[i] = argument.String()
+	}
+	return fmt.Sprintf("%s(%s)", c.name, strings.Join(arguments, ", "))
+}
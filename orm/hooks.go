@@ -0,0 +1,115 @@
+package orm
+
+import (
+	"context"
+)
+
+// -------------------------------------
+// Lifecycle hooks. Records can implement any of BeforeCreator, AfterCreator, BeforeUpdater,
+// BeforeDeleter and AfterReader to normalize themselves, fill in derived fields and so on:
+//
+//	func (c *User) BeforeCreate(ctx context.Context) error {
+//		c.Email = strings.ToLower(c.Email)
+//		return nil
+//	}
+//
+// Concerns that cut across record types (audit stamps, say) go in the collection's Hooks,
+// which run after the record's own method:
+//
+//	collection.Hooks.BeforeUpdate = append(collection.Hooks.BeforeUpdate, func(ctx context.Context, record interface{}) error {
+//		return stamp(ctx, record)
+//	})
+//
+// An error from a Before hook stops the write. An error from AfterCreate is returned
+// alongside the key, the document was stored by then (unless a transaction rolls it back).
+// Before hooks run ahead of validation, and the bulk writes run them for each record.
+// -------------------------------------
+
+type BeforeCreator interface {
+	BeforeCreate(ctx context.Context) error
+}
+
+type AfterCreator interface {
+	AfterCreate(ctx context.Context) error
+}
+
+type BeforeUpdater interface {
+	BeforeUpdate(ctx context.Context) error
+}
+
+type BeforeDeleter interface {
+	BeforeDelete(ctx context.Context) error
+}
+
+// AfterReader is called on records read by Get, the queries and ReadDoc
+type AfterReader interface {
+	AfterRead(ctx context.Context) error
+}
+
+// Hook is a collection level hook, it gets the record the operation is about
+type Hook func(ctx context.Context, record interface{}) error
+
+type Hooks struct {
+	BeforeCreate []Hook
+	AfterCreate  []Hook
+	BeforeUpdate []Hook
+	BeforeDelete []Hook
+	AfterRead    []Hook
+}
+
+func runHooks(ctx context.Context, record interface{}, method func() error, hooks []Hook) error {
+	if method != nil {
+		if err := method(); err != nil {
+			return err
+		}
+	}
+
+	for _, hook := range hooks {
+		if err := hook(ctx, record); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *Collection) beforeCreate(ctx context.Context, obj interface{}) error {
+	var method func() error
+	if record, ok := obj.(BeforeCreator); ok {
+		method = func() error { return record.BeforeCreate(ctx) }
+	}
+	return runHooks(ctx, obj, method, c.Hooks.BeforeCreate)
+}
+
+func (c *Collection) afterCreate(ctx context.Context, obj interface{}) error {
+	var method func() error
+	if record, ok := obj.(AfterCreator); ok {
+		method = func() error { return record.AfterCreate(ctx) }
+	}
+	return runHooks(ctx, obj, method, c.Hooks.AfterCreate)
+}
+
+func (c *Collection) beforeUpdate(ctx context.Context, obj interface{}) error {
+	var method func() error
+	if record, ok := obj.(BeforeUpdater); ok {
+		method = func() error { return record.BeforeUpdate(ctx) }
+	}
+	return runHooks(ctx, obj, method, c.Hooks.BeforeUpdate)
+}
+
+func (c *Collection) beforeDelete(ctx context.Context, obj interface{}) error {
+	var method func() error
+	if record, ok := obj.(BeforeDeleter); ok {
+		method = func() error { return record.BeforeDelete(ctx) }
+	}
+	return runHooks(ctx, obj, method, c.Hooks.BeforeDelete)
+}
+
+// afterRead runs the AfterRead hooks, hooks are the collection's (nil for records that aren't the collection's type)
+func afterRead(ctx context.Context, obj interface{}, hooks []Hook) error {
+	var method func() error
+	if record, ok := obj.(AfterReader); ok {
+		method = func() error { return record.AfterRead(ctx) }
+	}
+	return runHooks(ctx, obj, method, hooks)
+}
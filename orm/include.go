@@ -0,0 +1,131 @@
+package orm
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// -------------------------------------
+// Eager loading. Include joins the documents a foreign key points at into each
+// result, in the same query, instead of a Get per record:
+//
+//	type Widget struct {
+//		Id             string        `json:"id"`
+//		OrganizationId string        `json:"organization_id"`
+//		Organization   *Organization `json:"organization"`
+//		TagIds         []string      `json:"tag_ids"`
+//		Tags           []*Tag        `json:"tags"`
+//	}
+//	widgets.Query().List().Include("organization_id", orgs, "organization").Include("tag_ids", tags, "tags").All(ctx)
+//
+// A foreign key holding a single key gets a single document (or null), one holding
+// an array of keys gets an array of documents in the same order. The included records
+// get their collection's AfterRead hooks, after the record's own AfterRead method.
+// Mark global collections like orgs with NoOrganization, so scoped queries still include them.
+// -------------------------------------
+
+type include struct {
+	foreignKey string
+	related    *Collection
+	collection Variable
+	field      string
+}
+
+// Include loads the documents of related whose keys are in foreignKey into the attribute field
+func (c *ItemsOperator) Include(foreignKey string, related *Collection, field string) *ItemsOperator {
+	c.includes = append(c.includes, include{
+		foreignKey: foreignKey,
+		related:    related,
+		collection: c.collectionFilter.variableFactory.MakeCollectionVariable(related.TableName),
+		field:      field,
+	})
+
+	return c
+}
+
+// formatIncludes makes a LET subquery per relation, it goes after the LIMIT so only the returned documents are joined
+func (c *ItemsOperator) formatIncludes() string {
+	lets := make([]string, 0, len(c.includes))
+	for i, relation := range c.includes {
+		foreignKey := &DocumentAttribute{name: relation.foreignKey}
+		name := fmt.Sprintf("include_%d", i)
+
+		// a scoped query only includes the organization's documents, unless the related collection is global
+		orgFilter := ""
+		if key := relation.related.organizationKey(); key != "" {
+			if org := c.collectionFilter.orgVariable(); org != nil {
+				orgFilter = fmt.Sprintf("FILTER %s_doc.%s == %s ", name, key, org)
+			}
+		}
+
+		lets = append(lets, fmt.Sprintf(
+			"LET %s = (LET %s_keys = IS_ARRAY(%s) ? %s : [%s] "+
+				"FOR %s_doc IN %s FILTER %s_doc._key IN %s_keys %sSORT POSITION(%s_keys, %s_doc._key, true) "+
+				"RETURN MERGE(UNSET(%s_doc, \"_key\", \"_id\"), { id: %s_doc._key }))",
+			name, name, foreignKey, foreignKey, foreignKey,
+			name, relation.collection, name, name, orgFilter, name, name, name, name))
+	}
+
+	return strings.Join(lets, "\n ")
+}
+
+// mergeIncludes adds the loaded documents to the returned projection
+func (c *ItemsOperator) mergeIncludes(projection string) string {
+	if len(c.includes) == 0 {
+		return projection
+	}
+
+	fields := make([]string, 0, len(c.includes))
+	for i, relation := range c.includes {
+		fields = append(fields, fmt.Sprintf("%s: IS_ARRAY(%s) ? include_%d : FIRST(include_%d)",
+			quoteName(relation.field), &DocumentAttribute{name: relation.foreignKey}, i, i))
+	}
+
+	return fmt.Sprintf("MERGE(%s, { %s })", projection, strings.Join(fields, ", "))
+}
+
+// readIncludes is an AfterRead hook running the related collections' AfterRead hooks on the included records
+func (c *ItemsOperator) readIncludes(ctx context.Context, record interface{}) error {
+	value := reflect.Indirect(reflect.ValueOf(record))
+	if value.Kind() != reflect.Struct {
+		return nil
+	}
+
+	for _, relation := range c.includes {
+		for i := 0; i < value.NumField(); i++ {
+			if jsonName(value.Type().Field(i)) != relation.field {
+				continue
+			}
+			if err := readIncluded(ctx, value.Field(i), relation.related.Hooks.AfterRead); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// readIncluded runs the AfterRead hooks on a loaded record, or on each record of a slice
+func readIncluded(ctx context.Context, value reflect.Value, hooks []Hook) error {
+	switch value.Kind() {
+	case reflect.Ptr:
+		if value.IsNil() || value.Elem().Kind() != reflect.Struct {
+			return nil
+		}
+		return afterRead(ctx, value.Interface(), hooks)
+	case reflect.Struct:
+		if value.CanAddr() {
+			return afterRead(ctx, value.Addr().Interface(), hooks)
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < value.Len(); i++ {
+			if err := readIncluded(ctx, value.Index(i), hooks); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,404 @@
+package orm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"reflect"
+	"strings"
+
+	"github.com/arangodb/go-driver"
+)
+
+// -------------------------------------
+// Declarative index management. List the indexes a collection needs in Collection.Indexes
+// and Initialize will create whichever ones are missing.
+// -------------------------------------
+
+type IndexKind string
+
+const IndexPersistent = IndexKind("persistent")
+const IndexHash = IndexKind("hash") // arangodb treats hash indexes as persistent ones these days
+const IndexTTL = IndexKind("ttl")
+const IndexGeo = IndexKind("geo")
+const IndexFulltext = IndexKind("fulltext")
+const IndexInverted = IndexKind("inverted")
+
+const OrganizationIndexName = "idx_organization"
+
+type IndexSpec struct {
+	Name        string // defaults to idx_<kind>_<fields>
+	Kind        IndexKind
+	Fields      []string
+	Unique      bool
+	Sparse      bool
+	ExpireAfter int  // seconds, TTL indexes only
+	GeoJSON     bool // geo indexes only
+	MinLength   int  // fulltext indexes only
+}
+
+func (c IndexSpec) IndexName() string {
+	if c.Name != "" {
+		return c.Name
+	}
+
+	name := fmt.Sprintf("idx_%s_%s", c.Kind, strings.Join(c.Fields, "_"))
+	return strings.ReplaceAll(name, ".", "_")
+}
+
+func (c IndexSpec) normalizedKind() IndexKind {
+	switch c.Kind {
+	case IndexHash, IndexKind(driver.SkipListIndex), "":
+		return IndexPersistent
+	case "geo1", "geo2": // older servers report geo indexes by their number of fields
+		return IndexGeo
+	}
+	return c.Kind
+}
+
+// sameDefinition tells if two specs describe the same index, ignoring the name
+func (c IndexSpec) sameDefinition(other IndexSpec) bool {
+	if c.normalizedKind() != other.normalizedKind() || !reflect.DeepEqual(c.Fields, other.Fields) {
+		return false
+	}
+
+	switch c.normalizedKind() {
+	case IndexPersistent:
+		return c.Unique == other.Unique && c.Sparse == other.Sparse
+	case IndexTTL:
+		return c.ExpireAfter == other.ExpireAfter
+	case IndexGeo:
+		return c.GeoJSON == other.GeoJSON
+	case IndexFulltext:
+		return c.MinLength == other.MinLength
+	}
+
+	return true
+}
+
+// IndexDiff reports what Initialize (or SyncIndexes) found and did to the collection's indexes
+type IndexDiff struct {
+	Created   []string
+	Unchanged []string
+	Changed   []string // managed indexes whose definition in the database differs from the IndexSpec
+	Unmanaged []string // indexes in the database that aren't listed in Collection.Indexes
+	Dropped   []string // only when Collection.PruneIndexes is set
+}
+
+func (c *IndexDiff) InSync() bool {
+	return len(c.Created) == 0 && len(c.Changed) == 0 && len(c.Unmanaged) == 0 && len(c.Dropped) == 0
+}
+
+// ----------------
+// Reconciling
+// ----------------
+
+type existingIndex struct {
+	spec   IndexSpec
+	remove func(ctx context.Context) error
+}
+
+func (c *Collection) managedIndexes() []IndexSpec {
+	specs := make([]IndexSpec, 0, len(c.Indexes)+1)
+	if !c.NoOrganizationIndex && !c.NoOrganization && c.OrganizationIdKey != "" {
+		specs = append(specs, IndexSpec{
+			Name:   OrganizationIndexName,
+			Kind:   IndexPersistent,
+			Fields: []string{c.OrganizationIdKey},
+		})
+	}
+
+	return append(specs, c.Indexes...)
+}
+
+// planIndexes works out which specs need creating and which existing indexes need dropping.
+// Existing indexes are matched to specs by name, or else by definition, so an index made by
+// hand (or given a generated name) isn't recreated or dropped.
+func planIndexes(existing []existingIndex, specs []IndexSpec, prune bool) (*IndexDiff, []IndexSpec, []existingIndex) {
+	diff := &IndexDiff{}
+	toCreate := make([]IndexSpec, 0)
+	toDrop := make([]existingIndex, 0)
+
+	byName := make(map[string]int)
+	for i, index := range existing {
+		byName[index.spec.Name] = i
+	}
+
+	// names first, so a definition match can't take an index another spec names
+	managed := make([]bool, len(existing))
+	for _, spec := range specs {
+		if i, exists := byName[spec.IndexName()]; exists {
+			managed[i] = true
+		}
+	}
+
+	for _, spec := range specs {
+		name := spec.IndexName()
+
+		i, exists := byName[name]
+		if !exists {
+			i = sameIndex(existing, managed, spec)
+			exists = i >= 0
+			if exists {
+				managed[i] = true
+			}
+		}
+
+		switch {
+		case !exists:
+			diff.Created = append(diff.Created, name)
+			toCreate = append(toCreate, spec)
+		case existing[i].spec.sameDefinition(spec):
+			diff.Unchanged = append(diff.Unchanged, name)
+		default:
+			diff.Changed = append(diff.Changed, name)
+			if prune {
+				toDrop = append(toDrop, existing[i])
+				toCreate = append(toCreate, spec)
+			}
+		}
+	}
+
+	for i, index := range existing {
+		if managed[i] {
+			continue
+		}
+		diff.Unmanaged = append(diff.Unmanaged, index.spec.Name)
+		if prune {
+			diff.Dropped = append(diff.Dropped, index.spec.Name)
+			toDrop = append(toDrop, index)
+		}
+	}
+
+	return diff, toCreate, toDrop
+}
+
+// sameIndex finds an existing index, not yet matched to a spec, with the spec's definition. -1 if there's none.
+func sameIndex(existing []existingIndex, managed []bool, spec IndexSpec) int {
+	for i, index := range existing {
+		if !managed[i] && index.spec.sameDefinition(spec) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// SyncIndexes makes the collection's indexes match Indexes (plus the organization index), idempotently
+func (c *Collection) SyncIndexes(ctx context.Context) (*IndexDiff, error) {
+	col, err := c.Connection.Database.Collection(ctx, c.TableName)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, spec := range c.Indexes {
+		if len(spec.Fields) == 0 {
+			return nil, fmt.Errorf("index %s on %s has no fields", spec.IndexName(), c.TableName)
+		}
+	}
+
+	existing, err := c.existingIndexes(ctx, col)
+	if err != nil {
+		return nil, err
+	}
+
+	diff, toCreate, toDrop := planIndexes(existing, c.managedIndexes(), c.PruneIndexes)
+
+	// drop first, so redefined indexes can reuse their name
+	for _, index := range toDrop {
+		if err := index.remove(ctx); err != nil {
+			return diff, err
+		}
+	}
+
+	for _, spec := range toCreate {
+		if err := c.createIndex(ctx, col, spec); err != nil {
+			return diff, err
+		}
+	}
+
+	return diff, nil
+}
+
+func (c *Collection) createIndex(ctx context.Context, col driver.Collection, spec IndexSpec) error {
+	name := spec.IndexName()
+
+	var err error
+	switch spec.Kind {
+	case IndexPersistent, IndexKind(driver.SkipListIndex), "": // skiplists are persistent indexes now
+		_, _, err = col.EnsurePersistentIndex(ctx, spec.Fields, &driver.EnsurePersistentIndexOptions{Name: name, Unique: spec.Unique, Sparse: spec.Sparse})
+	case IndexHash:
+		_, _, err = col.EnsureHashIndex(ctx, spec.Fields, &driver.EnsureHashIndexOptions{Name: name, Unique: spec.Unique, Sparse: spec.Sparse})
+	case IndexTTL:
+		if len(spec.Fields) != 1 {
+			return fmt.Errorf("ttl index %s must have exactly one field", name)
+		}
+		_, _, err = col.EnsureTTLIndex(ctx, spec.Fields[0], spec.ExpireAfter, &driver.EnsureTTLIndexOptions{Name: name})
+	case IndexGeo:
+		_, _, err = col.EnsureGeoIndex(ctx, spec.Fields, &driver.EnsureGeoIndexOptions{Name: name, GeoJSON: spec.GeoJSON})
+	case IndexFulltext:
+		_, _, err = col.EnsureFullTextIndex(ctx, spec.Fields, &driver.EnsureFullTextIndexOptions{Name: name, MinLength: spec.MinLength})
+	case IndexInverted:
+		// the go driver doesn't know about inverted indexes, so talk to the index api directly
+		err = c.indexRequest(ctx, "POST", "", map[string]interface{}{
+			"type":   IndexInverted,
+			"name":   name,
+			"fields": spec.Fields,
+			"sparse": spec.Sparse,
+		}, nil, 200, 201)
+	default:
+		return fmt.Errorf("unknown index kind %s for index %s", spec.Kind, name)
+	}
+
+	return err
+}
+
+type rawIndex struct {
+	Id          string        `json:"id"`
+	Name        string        `json:"name"`
+	Type        string        `json:"type"`
+	Fields      []interface{} `json:"fields"`
+	Unique      bool          `json:"unique"`
+	Sparse      bool          `json:"sparse"`
+	ExpireAfter int           `json:"expireAfter"`
+	GeoJSON     bool          `json:"geoJson"`
+	MinLength   int           `json:"minLength"`
+}
+
+func (c *rawIndex) spec() IndexSpec {
+	fields := make([]string, 0, len(c.Fields))
+	for _, field := range c.Fields {
+		switch f := field.(type) {
+		case string:
+			fields = append(fields, f)
+		case map[string]interface{}: // inverted indexes describe their fields as objects
+			fields = append(fields, fmt.Sprint(f["name"]))
+		}
+	}
+
+	return IndexSpec{
+		Name:        c.Name,
+		Kind:        IndexKind(c.Type),
+		Fields:      fields,
+		Unique:      c.Unique,
+		Sparse:      c.Sparse,
+		ExpireAfter: c.ExpireAfter,
+		GeoJSON:     c.GeoJSON,
+		MinLength:   c.MinLength,
+	}
+}
+
+// existingIndexes lists the user defined (not primary or edge) indexes on the collection
+func (c *Collection) existingIndexes(ctx context.Context, col driver.Collection) ([]existingIndex, error) {
+	existing := make([]existingIndex, 0)
+	isSystem := func(kind string) bool {
+		return kind == string(driver.PrimaryIndex) || kind == string(driver.EdgeIndex)
+	}
+
+	if c.Connection.Client == nil {
+		// no client to make raw requests with, rely on the collection (which can't list inverted indexes)
+		indexes, err := col.Indexes(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, index := range indexes {
+			if isSystem(string(index.Type())) {
+				continue
+			}
+			existing = append(existing, existingIndex{
+				spec: IndexSpec{
+					Name:        index.UserName(),
+					Kind:        IndexKind(index.Type()),
+					Fields:      index.Fields(),
+					Unique:      index.Unique(),
+					Sparse:      index.Sparse(),
+					ExpireAfter: index.ExpireAfter(),
+					GeoJSON:     index.GeoJSON(),
+					MinLength:   index.MinLength(),
+				},
+				remove: index.Remove,
+			})
+		}
+		return existing, nil
+	}
+
+	indexes := make([]rawIndex, 0)
+	if err := c.indexRequest(ctx, "GET", "", nil, &indexes, 200); err != nil {
+		return nil, err
+	}
+
+	for _, index := range indexes {
+		if isSystem(index.Type) {
+			continue
+		}
+		id := index.Id
+		existing = append(existing, existingIndex{
+			spec: index.spec(),
+			remove: func(ctx context.Context) error {
+				return c.indexRequest(ctx, "DELETE", id, nil, nil, 200)
+			},
+		})
+	}
+
+	return existing, nil
+}
+
+// indexRequest calls arangodb's index api, for the things the go driver can't do
+func (c *Collection) indexRequest(ctx context.Context, method, id string, body interface{}, indexes *[]rawIndex, validStatus ...int) error {
+	if c.Connection.Client == nil {
+		return errors.New("managing this kind of index needs a Connection with a Client")
+	}
+
+	conn := c.Connection.Client.Connection()
+	apiPath := path.Join("_db", url.PathEscape(c.Connection.Database.Name()), "_api/index")
+	if id != "" {
+		apiPath = path.Join("_db", url.PathEscape(c.Connection.Database.Name()), "_api/index", id)
+	}
+
+	req, err := conn.NewRequest(method, apiPath)
+	if err != nil {
+		return err
+	}
+	if id == "" {
+		req.SetQuery("collection", c.TableName)
+	}
+	if body != nil {
+		if _, err := req.SetBody(body); err != nil {
+			return err
+		}
+	}
+
+	resp, err := conn.Do(ctx, req)
+	if err != nil {
+		return err
+	}
+	if err := resp.CheckStatus(validStatus...); err != nil {
+		return err
+	}
+
+	if indexes != nil {
+		return resp.ParseBody("indexes", indexes)
+	}
+
+	return nil
+}
+
+func (c *Collection) logIndexDiff(ctx context.Context, diff *IndexDiff) {
+	fields := func(indexes []string) func() Fields {
+		return func() Fields {
+			return Fields{"collection": c.TableName, "operation": OpIndexes, "indexes": indexes, "dropped": diff.Dropped}
+		}
+	}
+
+	if len(diff.Created) > 0 {
+		c.Connection.log(ctx, c.Connection.logLevel(OpIndexes), "ORM created indexes", fields(diff.Created))
+	}
+	if len(diff.Changed) > 0 {
+		c.Connection.log(ctx, LevelWarn, "ORM indexes differ from their spec", fields(diff.Changed))
+	}
+	if len(diff.Unmanaged) > 0 {
+		c.Connection.log(ctx, LevelWarn, "ORM unmanaged indexes", fields(diff.Unmanaged))
+	}
+}
@@ -4,8 +4,10 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"github.com/arangodb/go-driver"
 	"github.com/ridelabs/simply_arango/encoding"
-	log "github.com/sirupsen/logrus"
+	"strings"
+	"time"
 )
 
 // ------------------
@@ -52,11 +54,33 @@ type ItemsOperator struct {
 	limit   Variable
 	orderBy Order
 	paging  *Paging
+
+	batchSize int
+	ttl       time.Duration
+
+	// projections
+	selected   []string
+	omitted    []string
+	objFactory ObjectFactory
+
+	// related documents to load, see include.go
+	includes []include
+
+	// keyset pagination, see keyset.go
+	keyset     bool
+	afterToken string
+	nextToken  string
+	lastDoc    map[string]interface{}
+	readCount  int
+}
+
+func (c *ItemsOperator) warn(msg string) {
+	c.collectionFilter.collection.Connection.warn(msg, Fields{"collection": c.collectionFilter.collection.TableName})
 }
 
 func (c *ItemsOperator) OrderBy(key string) *OrderBy {
 	if c.orderBy != nil {
-		log.Warn("RandomOrder: dropping old order for these items")
+		c.warn("OrderBy: dropping old order for these items")
 	}
 	o := &OrderBy{items: c, key: key}
 	c.orderBy = o
@@ -65,13 +89,33 @@ func (c *ItemsOperator) OrderBy(key string) *OrderBy {
 
 func (c *ItemsOperator) RandomOrder() *ItemsOperator {
 	if c.orderBy != nil {
-		log.Warn("RandomOrder: dropping old order for these items")
+		c.warn("RandomOrder: dropping old order for these items")
 	}
 	c.orderBy = &Rand{}
 	return c
 }
 
+// SortByDistance lists the closest to the point first, see Operator.Near
+func (c *ItemsOperator) SortByDistance(attribute string, latitude, longitude float64) *ItemsOperator {
+	if c.orderBy != nil {
+		c.warn("SortByDistance: dropping old order for these items")
+	}
+	c.orderBy = &DistanceOrder{distance: c.collectionFilter.Operator().Near(attribute, latitude, longitude)}
+	return c
+}
+
+type DistanceOrder struct {
+	distance Expression
+}
+
+func (c *DistanceOrder) OrderFormat() string {
+	return fmt.Sprintf("SORT %s ASC ", c.distance)
+}
+
 func (c *ItemsOperator) formatOrder() string {
+	if c.keyset {
+		return c.formatKeysetOrder()
+	}
 	if c.orderBy != nil {
 		return c.orderBy.OrderFormat()
 	}
@@ -130,20 +174,146 @@ func (c *ItemsOperator) First(ctx context.Context) (interface{}, error) {
 	return matches[0], nil
 }
 
-func (c *ItemsOperator) All(ctx context.Context) ([]interface{}, error) {
+// --------------------
+// projections
+// --------------------
+
+// Select only returns the given top level attributes (and _key, so the records still get their id)
+func (c *ItemsOperator) Select(keys ...string) *ItemsOperator {
+	c.selected = append(c.selected, keys...)
+	return c
+}
+
+// Omit returns the documents without the given top level attributes
+func (c *ItemsOperator) Omit(keys ...string) *ItemsOperator {
+	c.omitted = append(c.omitted, keys...)
+	return c
+}
+
+// As decodes the results into records made by objFactory instead of the collection's AllocateRecord,
+// handy for a slimmer struct that goes with a Select
+func (c *ItemsOperator) As(objFactory ObjectFactory) *ItemsOperator {
+	c.objFactory = objFactory
+	return c
+}
+
+func (c *ItemsOperator) recordFactory() ObjectFactory {
+	if c.objFactory != nil {
+		return c.objFactory
+	}
+
+	return c.collectionFilter.collection.AllocateRecord
+}
+
+// afterRead are the collection's AfterRead hooks, unless the records are of another type (see As),
+// and the included records' hooks
+func (c *ItemsOperator) afterRead() []Hook {
+	hooks := make([]Hook, 0)
+	if len(c.includes) > 0 {
+		hooks = append(hooks, c.readIncludes)
+	}
+	if c.objFactory == nil {
+		hooks = append(hooks, c.collectionFilter.collection.Hooks.AfterRead...)
+	}
+
+	return hooks
+}
+
+func (c *ItemsOperator) formatReturn() string {
+	projection := DocumentName
+
+	// page tokens need the order key
+	orderKey := ""
+	if order, _ := c.keysetOrder(); c.keyset && order != nil {
+		orderKey = strings.Split(order.key, ".")[0]
+	}
+
+	if len(c.selected) > 0 {
+		keep := append([]string{"_key"}, c.selected...)
+		if orderKey != "" {
+			keep = append(keep, orderKey)
+		}
+		projection = fmt.Sprintf("KEEP(%s, %s)", projection, c.collectionFilter.variableFactory.MakeVariable(keep))
+	}
+
+	if len(c.omitted) > 0 {
+		unset := make([]string, 0, len(c.omitted))
+		for _, key := range c.omitted {
+			if key != "_key" && key != orderKey {
+				unset = append(unset, key)
+			}
+		}
+		projection = fmt.Sprintf("UNSET(%s, %s)", projection, c.collectionFilter.variableFactory.MakeVariable(unset))
+	}
+
+	return "RETURN " + c.mergeIncludes(projection)
+}
+
+// --------------------
+// cursor settings
+// --------------------
+
+// BatchSize sets how many documents arango sends back per round trip while reading the results
+func (c *ItemsOperator) BatchSize(size int) *ItemsOperator {
+	c.batchSize = size
+	return c
+}
+
+// TTL sets how long arango keeps the cursor alive between round trips
+func (c *ItemsOperator) TTL(ttl time.Duration) *ItemsOperator {
+	c.ttl = ttl
+	return c
+}
+
+func (c *ItemsOperator) cursorContext(ctx context.Context) context.Context {
+	if c.batchSize > 0 {
+		ctx = driver.WithQueryBatchSize(ctx, c.batchSize)
+	}
+	if c.ttl > 0 {
+		ctx = driver.WithQueryTTL(ctx, c.ttl)
+	}
+
+	return ctx
+}
+
+// build makes the query and its bind variables, see ToAQL
+func (c *ItemsOperator) build() (string, map[string]interface{}, error) {
+	if err := c.collectionFilter.checkScope(); err != nil {
+		return "", nil, err
+	}
+
+	keysetFilter, err := c.formatKeysetFilter()
+	if err != nil {
+		return "", nil, err
+	}
+
 	query := fmt.Sprintf(`
 FOR doc IN @@collection
  %s
  %s
  %s
- RETURN doc`, c.collectionFilter.formatExpressions(), c.formatOrder(), c.formatLimitOrPaging())
+ %s
+ %s
+ %s`, c.collectionFilter.formatExpressions(), keysetFilter, c.formatOrder(), c.formatLimitOrPaging(), c.formatIncludes(), c.formatReturn())
 
 	variables := c.collectionFilter.variableFactory.SymbolTable()
-	variables["@collection"] = c.collectionFilter.collection.TableName
+	variables["@collection"] = c.collectionFilter.source()
+
+	return query, variables, nil
+}
+
+func (c *ItemsOperator) query(ctx context.Context) (driver.Cursor, error) {
+	query, variables, err := c.build()
+	if err != nil {
+		return nil, err
+	}
 
-	log.Info("ORM ", log.Fields{"query": query, "filters": variables})
+	collection := c.collectionFilter.collection
+	return collection.Connection.query(c.cursorContext(ctx), OpQuery, collection.TableName, query, variables)
+}
 
-	cursor, err := c.collectionFilter.collection.Connection.Database.Query(ctx, query, variables)
+func (c *ItemsOperator) All(ctx context.Context) ([]interface{}, error) {
+	cursor, err := c.query(ctx)
 
 	if err != nil {
 		return nil, err
@@ -151,12 +321,47 @@ FOR doc IN @@collection
 
 	defer cursor.Close()
 
-	// read data
+	c.startPage()
+	items, err := readDocs(ctx, cursor, c.recordFactory(), c.afterRead(), c.rememberLast)
+	if err != nil {
+		return nil, err
+	}
+
+	return items, c.finishPage()
+}
+
+// Iterate runs the query and hands back an Iterator that reads the records one at a time,
+// rather than loading them all like All does. Close the iterator if you stop early.
+func (c *ItemsOperator) Iterate(ctx context.Context) (*Iterator, error) {
+	cursor, err := c.query(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	c.startPage()
+	return &Iterator{
+		ctx:        ctx,
+		cursor:     cursor,
+		objFactory: c.recordFactory(),
+		hooks:      c.afterRead(),
+		onDoc:      c.rememberLast,
+		onDone:     c.finishPage,
+	}, nil
+}
+
+// readDocs reads every document left in the cursor as records made by objFactory, running the AfterRead hooks,
+// onDoc (if not nil) sees each raw document before it's converted
+func readDocs(ctx context.Context, cursor driver.Cursor, objFactory ObjectFactory, hooks []Hook, onDoc func(map[string]interface{})) ([]interface{}, error) {
 	items := make([]interface{}, 0)
 	for cursor.HasMore() {
-		obj, err := ReadDoc(c.collectionFilter.collection.AllocateRecord, func(doc map[string]interface{}) error {
-			_, err := cursor.ReadDocument(ctx, &doc)
-			return err
+		obj, err := readDoc(ctx, objFactory, hooks, func(doc map[string]interface{}) error {
+			if _, err := cursor.ReadDocument(ctx, &doc); err != nil {
+				return err
+			}
+			if onDoc != nil {
+				onDoc(doc)
+			}
+			return nil
 		})
 		if err != nil {
 			return nil, err
@@ -167,9 +372,92 @@ FOR doc IN @@collection
 	return items, nil
 }
 
+// ------------------
+// Iterator
+// ------------------
+
+// Iterator reads query results one record at a time:
+//
+//	it, err := collection.Query().List().BatchSize(1000).Iterate(ctx)
+//	if err != nil {
+//		return err
+//	}
+//	defer it.Close()
+//	for it.Next() {
+//		doc := it.Item().(*MyDoc)
+//	}
+//	return it.Err()
+type Iterator struct {
+	ctx        context.Context
+	cursor     driver.Cursor
+	objFactory ObjectFactory
+	hooks      []Hook // AfterRead
+	item       interface{}
+	err        error
+	closed     bool
+
+	onDoc  func(map[string]interface{})
+	onDone func() error
+}
+
+// Next reads the next record, returning false when there are no more (or reading failed, see Err)
+func (c *Iterator) Next() bool {
+	if c.closed {
+		return false
+	}
+
+	if !c.cursor.HasMore() {
+		c.err = c.Close()
+		if c.err == nil && c.onDone != nil {
+			c.err = c.onDone()
+		}
+		return false
+	}
+
+	c.item, c.err = readDoc(c.ctx, c.objFactory, c.hooks, func(doc map[string]interface{}) error {
+		if _, err := c.cursor.ReadDocument(c.ctx, &doc); err != nil {
+			return err
+		}
+		if c.onDoc != nil {
+			c.onDoc(doc)
+		}
+		return nil
+	})
+	if c.err != nil {
+		c.item = nil
+		_ = c.Close()
+		return false
+	}
+
+	return true
+}
+
+func (c *Iterator) Item() interface{} {
+	return c.item
+}
+
+func (c *Iterator) Err() error {
+	return c.err
+}
+
+// Close releases the cursor on the server, it's safe to call more than once
+func (c *Iterator) Close() error {
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+
+	return c.cursor.Close()
+}
+
 type Reader func(map[string]interface{}) error
 
+// ReadDoc makes a record of the document reader fills in, then runs the record's AfterRead hook
 func ReadDoc(objFactory ObjectFactory, reader Reader) (interface{}, error) {
+	return readDoc(context.Background(), objFactory, nil, reader)
+}
+
+func readDoc(ctx context.Context, objFactory ObjectFactory, hooks []Hook, reader Reader) (interface{}, error) {
 	doc := make(map[string]interface{})
 
 	if err := reader(doc); err != nil {
@@ -182,6 +470,7 @@ func ReadDoc(objFactory ObjectFactory, reader Reader) (interface{}, error) {
 		doc["id"] = id // convert _key to id
 		delete(doc, "_key")
 		delete(doc, "_id")
+		// _rev stays, records with a _rev field use it for optimistic concurrency on Update/Delete
 	}
 
 	obj := objFactory()
@@ -189,5 +478,9 @@ func ReadDoc(objFactory ObjectFactory, reader Reader) (interface{}, error) {
 		return nil, err
 	}
 
+	if err := afterRead(ctx, obj, hooks); err != nil {
+		return nil, err
+	}
+
 	return obj, nil
 }
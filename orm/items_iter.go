@@ -0,0 +1,59 @@
+//go:build go1.23
+
+package orm
+
+import (
+	"context"
+	"iter"
+)
+
+// Seq runs the query and yields the records one at a time for use with range:
+//
+//	for doc, err := range collection.Query().List().Seq(ctx) {
+//		if err != nil {
+//			return err
+//		}
+//	}
+//
+// The cursor is closed when the loop finishes or breaks early.
+func (c *ItemsOperator) Seq(ctx context.Context) iter.Seq2[interface{}, error] {
+	return func(yield func(interface{}, error) bool) {
+		it, err := c.Iterate(ctx)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		defer it.Close()
+
+		for it.Next() {
+			if !yield(it.Item(), nil) {
+				return
+			}
+		}
+
+		if it.Err() != nil {
+			yield(nil, it.Err())
+		}
+	}
+}
+
+func (c *TypedItemsOperator[T]) Seq(ctx context.Context) iter.Seq2[*T, error] {
+	return func(yield func(*T, error) bool) {
+		it, err := c.Iterate(ctx)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		defer it.Close()
+
+		for it.Next() {
+			if !yield(it.Item(), nil) {
+				return
+			}
+		}
+
+		if it.Err() != nil {
+			yield(nil, it.Err())
+		}
+	}
+}
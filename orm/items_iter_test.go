@@ -0,0 +1,34 @@
+//go:build go1.23
+
+package orm
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ridelabs/simply_arango/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestItemsSeq(t *testing.T) {
+	suite := &OrmTests{}
+	suite.BeforeEach(t)
+
+	names := make([]string, 0)
+	for record, err := range Typed[MyDoc](suite.collection).Query().List().Seq(context.TODO()) {
+		assert.Nil(t, err)
+		names = append(names, record.Name)
+		if len(names) == 2 {
+			break
+		}
+	}
+
+	assert.Equal(t, []string{"Suzie Q", "Bill"}, names)
+	assert.True(t, suite.database.MyCursor.Closed, "breaking out of the loop closes the cursor")
+
+	suite.database.MyCursor = &utils.MockCursor{Items: []string{`{"name": "no key"}`}}
+	for record, err := range suite.collection.Query().List().Seq(context.TODO()) {
+		assert.Nil(t, record)
+		assert.EqualError(t, err, "arango db record should have had an _key attribute, but didn't")
+	}
+}
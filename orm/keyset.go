@@ -0,0 +1,328 @@
+package orm
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// -------------------------------------
+// Keyset (cursor based) pagination. Rather than skipping `offset` documents like Paging,
+// the next page starts right after the last document of the previous one, using the
+// OrderBy key with _key as a tiebreaker:
+//
+//	items := collection.Query().List().OrderBy("name").Asc().Limit(20).After(token)
+//	docs, err := items.All(ctx)
+//	next := items.PageToken() // "" after a short page
+//
+// Tokens are signed with Connection.PageTokenSecret so clients can't tamper with them, and
+// only work for the collection and filters they were made for.
+// -------------------------------------
+
+var ErrInvalidPageToken = errors.New("invalid page token")
+
+type pageToken struct {
+	Collection string      `json:"c"`
+	Filters    string      `json:"f,omitempty"` // filtersHash of the query
+	OrderKey   string      `json:"k,omitempty"`
+	Direction  string      `json:"d,omitempty"`
+	Value      interface{} `json:"v,omitempty"`
+	Key        string      `json:"id"`
+}
+
+var processTokenSecret []byte
+var processTokenSecretOnce sync.Once
+
+// tokenSecret is the connection's PageTokenSecret, or a random one for the life of the process
+func tokenSecret(conn *Connection) []byte {
+	if conn != nil && len(conn.PageTokenSecret) > 0 {
+		return conn.PageTokenSecret
+	}
+
+	processTokenSecretOnce.Do(func() {
+		processTokenSecret = make([]byte, 32)
+		if _, err := rand.Read(processTokenSecret); err != nil {
+			panic(err)
+		}
+	})
+
+	return processTokenSecret
+}
+
+func signToken(secret []byte, payload string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func encodePageToken(secret []byte, token *pageToken) (string, error) {
+	data, err := json.Marshal(token)
+	if err != nil {
+		return "", err
+	}
+
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + signToken(secret, payload), nil
+}
+
+func decodePageToken(secret []byte, encoded string) (*pageToken, error) {
+	payload, signature, found := strings.Cut(encoded, ".")
+	if !found || !hmac.Equal([]byte(signature), []byte(signToken(secret, payload))) {
+		return nil, ErrInvalidPageToken
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, ErrInvalidPageToken
+	}
+
+	// numbers are decoded as json.Number, so int64 order values above 2^53 don't get rounded to a float
+	token := &pageToken{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(token); err != nil {
+		return nil, ErrInvalidPageToken
+	}
+
+	if token.Value, err = fromNumbers(token.Value); err != nil {
+		return nil, ErrInvalidPageToken
+	}
+
+	return token, nil
+}
+
+// fromNumbers turns the json.Numbers in a decoded value back into int64 (when they're integers) or float64
+func fromNumbers(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i, nil
+		}
+		return v.Float64()
+	case []interface{}:
+		for i, item := range v {
+			converted, err := fromNumbers(item)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = converted
+		}
+	case map[string]interface{}:
+		for key, item := range v {
+			converted, err := fromNumbers(item)
+			if err != nil {
+				return nil, err
+			}
+			v[key] = converted
+		}
+	}
+
+	return value, nil
+}
+
+// ----------------
+// ItemsOperator keyset support
+// ----------------
+
+// After switches to keyset pagination, starting after the document the token points to.
+// Pass "" for the first page. Use Limit to set the page size.
+func (c *ItemsOperator) After(token string) *ItemsOperator {
+	c.keyset = true
+	c.afterToken = token
+	return c
+}
+
+// PageToken points after the last document read by All/Iterate. It is "" when the page came back
+// shorter than the Limit, a page that happens to end the results still gets one (for an empty page).
+func (c *ItemsOperator) PageToken() string {
+	return c.nextToken
+}
+
+// boundName finds the bind variables in a query
+var boundName = regexp.MustCompile(`@@?\w+`)
+
+// filtersHash identifies the query's filters, with the values of their variables in place of the names
+// so the same filters match however the variables got numbered
+func (c *ItemsOperator) filtersHash() (string, error) {
+	filters := c.collectionFilter.formatExpressions()
+	if filters == "" {
+		return "", nil
+	}
+
+	variables := c.collectionFilter.variableFactory.SymbolTable()
+	var err error
+	canonical := boundName.ReplaceAllStringFunc(filters, func(name string) string {
+		value, ok := variables[strings.TrimPrefix(name, "@")]
+		if !ok {
+			return name
+		}
+		data, marshalErr := json.Marshal(value)
+		if marshalErr != nil {
+			err = marshalErr
+		}
+		return string(data)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256([]byte(canonical))
+	return base64.RawURLEncoding.EncodeToString(sum[:16]), nil
+}
+
+func (c *ItemsOperator) keysetOrder() (*OrderBy, error) {
+	switch order := c.orderBy.(type) {
+	case nil:
+		return nil, nil
+	case *OrderBy:
+		if order.key == "_key" {
+			return nil, nil // _key is already the tiebreaker
+		}
+		return order, nil
+	default:
+		return nil, fmt.Errorf("keyset pagination needs a stable order, not %s", order.OrderFormat())
+	}
+}
+
+func keysetDirection(order *OrderBy) string {
+	if order == nil || order.direction != "DESC" {
+		return "ASC"
+	}
+	return "DESC"
+}
+
+func (c *ItemsOperator) formatKeysetOrder() string {
+	order, _ := c.keysetOrder()
+	direction := keysetDirection(order)
+	if order == nil {
+		return fmt.Sprintf("SORT %s._key %s ", DocumentName, direction)
+	}
+
+	return fmt.Sprintf("SORT %s.%s %s, %s._key %s ", DocumentName, order.key, direction, DocumentName, direction)
+}
+
+// formatKeysetFilter checks the token and makes the filter for everything after it
+func (c *ItemsOperator) formatKeysetFilter() (string, error) {
+	if !c.keyset {
+		return "", nil
+	}
+
+	if c.paging != nil {
+		return "", errors.New("keyset pagination can't be combined with Paging, use Limit for the page size")
+	}
+
+	order, err := c.keysetOrder()
+	if err != nil {
+		return "", err
+	}
+
+	if c.afterToken == "" {
+		return "", nil
+	}
+
+	token, err := decodePageToken(tokenSecret(c.collectionFilter.collection.Connection), c.afterToken)
+	if err != nil {
+		return "", err
+	}
+
+	filters, err := c.filtersHash()
+	if err != nil {
+		return "", err
+	}
+	if token.Collection != c.collectionFilter.source() || token.Filters != filters {
+		return "", ErrInvalidPageToken
+	}
+
+	direction := keysetDirection(order)
+	comparison := ">"
+	if direction == "DESC" {
+		comparison = "<"
+	}
+
+	variables := c.collectionFilter.variableFactory
+	key := &DocumentAttribute{name: "_key"}
+	if order == nil {
+		if token.OrderKey != "" {
+			return "", ErrInvalidPageToken
+		}
+		return fmt.Sprintf("FILTER %s %s %s", key, comparison, variables.MakeVariable(token.Key)), nil
+	}
+
+	if token.OrderKey != order.key || token.Direction != direction {
+		return "", ErrInvalidPageToken
+	}
+
+	attribute := &DocumentAttribute{name: order.key}
+	value := variables.MakeVariable(token.Value)
+	return fmt.Sprintf("FILTER (%s %s %s || (%s == %s && %s %s %s))",
+		attribute, comparison, value, attribute, value, key, comparison, variables.MakeVariable(token.Key)), nil
+}
+
+func (c *ItemsOperator) startPage() {
+	c.lastDoc = nil
+	c.readCount = 0
+	c.nextToken = ""
+}
+
+// rememberLast keeps track of the last raw document read, for making the next page token
+func (c *ItemsOperator) rememberLast(doc map[string]interface{}) {
+	if !c.keyset {
+		return
+	}
+
+	c.lastDoc = map[string]interface{}{"_key": doc["_key"]}
+	if order, _ := c.keysetOrder(); order != nil {
+		c.lastDoc[order.key] = lookupPath(doc, order.key)
+	}
+	c.readCount++
+}
+
+// finishPage makes the next page token once all the documents have been read
+func (c *ItemsOperator) finishPage() error {
+	c.nextToken = ""
+	if !c.keyset || c.lastDoc == nil || c.limit == nil {
+		return nil
+	}
+
+	if limit, ok := c.collectionFilter.variableFactory.SymbolTable()[c.limit.(*QueryVariable).name].(int); ok && c.readCount < limit {
+		return nil // a short page is the last one
+	}
+
+	filters, err := c.filtersHash()
+	if err != nil {
+		return err
+	}
+
+	key, _ := c.lastDoc["_key"].(string)
+	token := &pageToken{Collection: c.collectionFilter.source(), Filters: filters, Key: key}
+	if order, _ := c.keysetOrder(); order != nil {
+		token.OrderKey = order.key
+		token.Direction = keysetDirection(order)
+		token.Value = c.lastDoc[order.key]
+	}
+
+	c.nextToken, err = encodePageToken(tokenSecret(c.collectionFilter.collection.Connection), token)
+	return err
+}
+
+// lookupPath finds a (possibly nested, dotted) attribute in a document
+func lookupPath(doc map[string]interface{}, path string) interface{} {
+	var current interface{} = doc
+	for _, part := range strings.Split(path, ".") {
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		current = m[part]
+	}
+
+	return current
+}
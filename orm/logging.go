@@ -0,0 +1,375 @@
+package orm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/arangodb/go-driver"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// -------------------------------------
+// Logging. Nothing is logged unless the Connection has a Logger:
+//
+//	conn.Logger = orm.NewSlogLogger(slog.Default().Handler())
+//	conn.LogLevels = map[string]orm.Level{orm.OpQuery: orm.LevelInfo}
+//
+// Every database operation logs one line when it ends, with the same fields: collection,
+// operation, duration, rows, and error when it failed. Queries add the query and its bind
+// variables, with secrets and personal data hidden by the Connection's Redaction.
+// -------------------------------------
+
+type Level int
+
+// the levels line up with slog's
+const (
+	LevelDebug Level = -4
+	LevelInfo  Level = 0
+	LevelWarn  Level = 4
+	LevelError Level = 8
+
+	// LevelOff stops an operation from being logged at all
+	LevelOff Level = 1 << 16
+)
+
+type Fields map[string]interface{}
+
+type Logger interface {
+	Enabled(ctx context.Context, level Level) bool
+	Log(ctx context.Context, level Level, msg string, fields Fields)
+}
+
+// the operations, as they appear in the operation field and in Connection.LogLevels
+const (
+	OpQuery       = "query"
+	OpCount       = "count"
+	OpAggregate   = "aggregate"
+	OpTraverse    = "traverse"
+	OpUpsert      = "upsert"
+	OpIncrement   = "increment"
+	OpAtomic      = "atomic_update"
+	OpDeleteAll   = "delete_all"
+	OpUpdateAll   = "update_all"
+	OpGet         = "get"
+	OpCreate      = "create"
+	OpUpdate      = "update"
+	OpDelete      = "delete"
+	OpCreateMany  = "create_many"
+	OpUpdateMany  = "update_many"
+	OpReplaceMany = "replace_many"
+	OpDeleteMany  = "delete_many"
+	OpIndexes     = "indexes"
+	OpView        = "search_view"
+	OpProfile     = "profile"
+	OpExplain     = "explain"
+)
+
+// DefaultLogLevel is the level of operations that aren't in Connection.LogLevels or DefaultLogLevels
+const DefaultLogLevel = LevelDebug
+
+// DefaultLogLevels are the operations logged above DefaultLogLevel unless Connection.LogLevels says otherwise
+var DefaultLogLevels = map[string]Level{
+	OpIndexes: LevelInfo,
+	OpView:    LevelInfo,
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Enabled(ctx context.Context, level Level) bool { return false }
+
+func (nopLogger) Log(ctx context.Context, level Level, msg string, fields Fields) {}
+
+type slogLogger struct {
+	logger *slog.Logger
+}
+
+// NewSlogLogger logs through a log/slog handler
+func NewSlogLogger(handler slog.Handler) Logger {
+	return &slogLogger{logger: slog.New(handler)}
+}
+
+func (c *slogLogger) Enabled(ctx context.Context, level Level) bool {
+	return c.logger.Enabled(ctx, slog.Level(level))
+}
+
+func (c *slogLogger) Log(ctx context.Context, level Level, msg string, fields Fields) {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	attrs := make([]slog.Attr, 0, len(fields))
+	for _, name := range names {
+		attrs = append(attrs, slog.Any(name, fields[name]))
+	}
+
+	c.logger.LogAttrs(ctx, slog.Level(level), msg, attrs...)
+}
+
+func (c *Connection) logger() Logger {
+	if c.Logger == nil {
+		return nopLogger{}
+	}
+	return c.Logger
+}
+
+func (c *Connection) logLevel(operation string) Level {
+	if level, ok := c.LogLevels[operation]; ok {
+		return level
+	}
+	if level, ok := DefaultLogLevels[operation]; ok {
+		return level
+	}
+	return DefaultLogLevel
+}
+
+// log logs when level is on, fields is only called if it is
+func (c *Connection) log(ctx context.Context, level Level, msg string, fields func() Fields) {
+	if level >= LevelOff || !c.logger().Enabled(ctx, level) {
+		return
+	}
+
+	c.logger().Log(ctx, level, msg, fields())
+}
+
+func (c *Connection) warn(msg string, fields Fields) {
+	c.log(context.Background(), LevelWarn, msg, func() Fields { return fields })
+}
+
+// ----------------
+// Operations
+// ----------------
+
+// operation is one call to the database, logged (and traced, see telemetry.go) when it ends
+type operation struct {
+	conn       *Connection
+	ctx        context.Context
+	name       string
+	collection string
+	query      string
+	bindVars   map[string]interface{}
+	stats      driver.QueryStatistics
+	started    time.Time
+	attempts   int // when it was retried
+	span       trace.Span
+}
+
+func (c *Connection) startOperation(ctx context.Context, name, collection string) *operation {
+	op := &operation{conn: c, ctx: ctx, name: name, collection: collection, started: time.Now()}
+	if c.Telemetry != nil {
+		op.ctx, op.span = c.Telemetry.start(ctx, c, name, collection)
+	}
+	return op
+}
+
+func (c *operation) end(rows int, err error) {
+	if c.span != nil {
+		c.conn.Telemetry.end(c, time.Since(c.started), rows, err)
+	}
+
+	c.conn.log(c.ctx, c.conn.logLevel(c.name), "ORM "+c.name, func() Fields {
+		fields := Fields{
+			"collection": c.collection,
+			"operation":  c.name,
+			"duration":   time.Since(c.started),
+			"rows":       rows,
+		}
+		if c.query != "" {
+			fields["query"] = strings.Join(strings.Fields(c.query), " ")
+			fields["bind_vars"] = c.conn.redaction().redact(c.query, c.bindVars)
+		}
+		if c.attempts > 1 {
+			fields["attempts"] = c.attempts
+		}
+		if err != nil {
+			fields["error"] = err.Error()
+		}
+		return fields
+	})
+}
+
+// rowCount is the rows a single document operation touched
+func rowCount(err error) int {
+	if err != nil {
+		return 0
+	}
+	return 1
+}
+
+// batchRows is the rows a batch of document operations touched
+func batchRows(errs driver.ErrorSlice) int {
+	rows := 0
+	for _, err := range errs {
+		if err == nil {
+			rows++
+		}
+	}
+	return rows
+}
+
+// query runs an AQL query, the operation ends when the cursor is closed
+func (c *Connection) query(ctx context.Context, name, collection, query string, bindVars map[string]interface{}) (driver.Cursor, error) {
+	op := c.startOperation(ctx, name, collection)
+	op.query, op.bindVars = query, bindVars
+
+	var cursor driver.Cursor
+	err := op.retry(func() (err error) {
+		cursor, err = c.Database.Query(op.ctx, query, bindVars)
+		return err
+	})
+	if err != nil {
+		op.end(0, err)
+		return nil, err
+	}
+
+	return &operationCursor{Cursor: cursor, op: op}, nil
+}
+
+// operationCursor counts the documents read, for the log line written when it's closed
+type operationCursor struct {
+	driver.Cursor
+	op     *operation
+	rows   int
+	err    error
+	closed bool
+}
+
+func (c *operationCursor) ReadDocument(ctx context.Context, result interface{}) (driver.DocumentMeta, error) {
+	meta, err := c.Cursor.ReadDocument(ctx, result)
+	if err == nil {
+		c.rows++
+	} else if !driver.IsNoMoreDocuments(err) {
+		c.err = err
+	}
+	return meta, err
+}
+
+func (c *operationCursor) Close() error {
+	err := c.Cursor.Close()
+	if !c.closed {
+		c.closed = true
+		if c.op.span != nil {
+			c.op.stats = c.Cursor.Statistics()
+		}
+		c.op.end(c.rows, c.err)
+	}
+	return err
+}
+
+// ----------------
+// Redaction
+// ----------------
+
+const Redacted = "[REDACTED]"
+
+// Redaction hides bind variables before they're logged. A variable is hidden when the field it's
+// compared with or assigned to contains one of Fields (ignoring case), or when its value matches
+// one of Values. Documents and arrays are redacted field by field.
+type Redaction struct {
+	Fields []string
+	Values []*regexp.Regexp
+}
+
+// DefaultRedaction hides passwords, secrets, tokens and emails. It's used when Connection.Redaction is nil.
+var DefaultRedaction = &Redaction{
+	Fields: []string{"password", "secret", "token", "api_key", "apikey", "private_key", "email"},
+	Values: []*regexp.Regexp{regexp.MustCompile(`[^\s@]+@[^\s@]+\.[^\s@]+`)},
+}
+
+func (c *Connection) redaction() *Redaction {
+	if c.Redaction == nil {
+		return DefaultRedaction
+	}
+	return c.Redaction
+}
+
+// variableUse finds the field a bind variable is compared with or assigned to in a query,
+// the field can be quoted the way update and upsert objects quote it
+var variableUse = regexp.MustCompile(`"?([A-Za-z_]\w*)"?\)?\s*(?:==|!=|<=|>=|<|>|=~|!~|\bLIKE\b|\bNOT IN\b|\bIN\b|:|,)\s*@(\w+)\b`)
+
+func (c *Redaction) redact(query string, bindVars map[string]interface{}) map[string]interface{} {
+	// variables are shared by equal values, so one can be used with several fields
+	hidden := make(map[string]bool)
+	for _, match := range variableUse.FindAllStringSubmatch(query, -1) {
+		hidden[match[2]] = hidden[match[2]] || c.hidesField(match[1])
+	}
+
+	redacted := make(map[string]interface{}, len(bindVars))
+	for name, value := range bindVars {
+		if strings.HasPrefix(name, "@") { // collection names
+			redacted[name] = value
+		} else if hidden[name] {
+			redacted[name] = Redacted
+		} else {
+			redacted[name] = c.redactValue(value)
+		}
+	}
+
+	return redacted
+}
+
+func (c *Redaction) hidesField(field string) bool {
+	field = strings.ToLower(field)
+	if field == "" {
+		return false
+	}
+
+	for _, hidden := range c.Fields {
+		if strings.Contains(field, strings.ToLower(hidden)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Redaction) redactValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case nil, bool, int, int32, int64, uint, uint32, uint64, float32, float64:
+		return v
+	case string:
+		for _, pattern := range c.Values {
+			if pattern.MatchString(v) {
+				return Redacted
+			}
+		}
+		return v
+	case fmt.Stringer:
+		return c.redactValue(v.String())
+	case map[string]interface{}:
+		redacted := make(map[string]interface{}, len(v))
+		for field, item := range v {
+			if c.hidesField(field) {
+				redacted[field] = Redacted
+			} else {
+				redacted[field] = c.redactValue(item)
+			}
+		}
+		return redacted
+	case []interface{}:
+		redacted := make([]interface{}, len(v))
+		for i, item := range v {
+			redacted[i] = c.redactValue(item)
+		}
+		return redacted
+	}
+
+	// structs, typed maps and slices are redacted as the json they're sent as
+	kind := reflect.Indirect(reflect.ValueOf(value)).Kind()
+	if kind == reflect.Struct || kind == reflect.Map || kind == reflect.Slice || kind == reflect.Array {
+		var generic interface{}
+		if data, err := json.Marshal(value); err == nil && json.Unmarshal(data, &generic) == nil {
+			return c.redactValue(generic)
+		}
+		return Redacted
+	}
+
+	return c.redactValue(fmt.Sprint(value))
+}
@@ -1,12 +1,28 @@
 package orm
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/arangodb/go-driver"
 	"github.com/houqp/gtest"
+	"log/slog"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/ridelabs/simply_arango/encoding"
 	"github.com/ridelabs/simply_arango/utils"
 	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type MyDoc struct {
@@ -19,6 +35,30 @@ type MyDoc struct {
 	OrganizationId string `json:"organization_id"`
 }
 
+type Address struct {
+	City string `json:"city" validate:"required"`
+	Zip  string `json:"zip" validate:"omitempty,len=5"`
+}
+
+type Member struct {
+	Id      string    `json:"id"`
+	Name    string    `json:"name" validate:"required,max=10"`
+	Email   string    `json:"email" validate:"omitempty,email"`
+	Role    string    `json:"role" validate:"oneof=admin member"`
+	Age     int       `json:"age" validate:"min=18"`
+	Tags    []string  `json:"tags" validate:"max=2"`
+	Address *Address  `json:"address"`
+	Homes   []Address `json:"homes"`
+}
+
+// Validate checks what tags can't
+func (c *Member) Validate() error {
+	if c.Role == "admin" && c.Age < 21 {
+		return FieldError{Field: "age", Message: "must be at least 21 for admins"}
+	}
+	return nil
+}
+
 type OrmTests struct {
 	collection *Collection
 	database   *utils.MockDatabase
@@ -193,8 +233,255 @@ func (s *OrmTests) SubTestIncrement(t *testing.T) {
 	assert.Nil(t, err)
 
 	q := utils.StripExtraWS(s.database.LastQuery)
-	assert.Equal(t, "FOR d IN @@collection FILTER d._key == @key && d.organization_id == @org_id "+
-		"UPDATE d WITH { counter: d.counter + 1 } IN @@collection", q)
+	assert.Equal(t, "FOR doc IN @@collection "+
+		"FILTER (doc._key == @var_0) "+
+		"FILTER (doc.organization_id == @var_1) "+
+		"UPDATE doc WITH { counter: doc.counter + @var_2 } IN @@collection "+
+		"RETURN NEW", q)
+	assert.Equal(t, map[string]interface{}{
+		"@collection": "foo",
+		"var_0":       "1112",
+		"var_1":       "1138",
+		"var_2":       float64(1),
+	}, s.database.LastBindVars)
+
+	// the field name never goes in the query unchecked
+	err = s.collection.Increment(context.TODO(), &MyDoc{OrganizationId: "1138", Id: "1112"}, "counter } IN foo REMOVE doc IN foo //")
+	assert.NotNil(t, err)
+}
+
+func (s *OrmTests) SubTestAtomicUpdates(t *testing.T) {
+	query, variables, err := s.collection.Query().WithinOrg("8675309").Filter("b", "bravo").Atomic().
+		Inc("counter", 2).Dec("credits", 1).Set("stats.last_seen", "1").Push("log", "a", "b").
+		AddToSet("tags", "go").Pull("blocked", "c").Max("stats.high", 10).Min("stats.low", 1).
+		Unset("d").ToAQL()
+	assert.Nil(t, err)
+	assert.Equal(t, "FOR doc IN @@collection "+
+		"FILTER (doc.organization_id == @var_0) "+
+		"FILTER (doc.b == @var_1) "+
+		"UPDATE doc WITH { "+
+		"blocked: REMOVE_VALUES(NOT_NULL(doc.blocked, []), @var_7), "+
+		"counter: doc.counter + @var_2, "+
+		"credits: doc.credits - @var_3, "+
+		"d: null, "+
+		"log: APPEND(NOT_NULL(doc.log, []), @var_5), "+
+		"stats: { high: MAX([doc.stats.high, @var_8]), last_seen: @var_4, low: MIN([doc.stats.low, @var_9]) }, "+
+		"tags: APPEND(NOT_NULL(doc.tags, []), @var_6, true) "+
+		"} IN @@collection OPTIONS { keepNull: false } "+
+		"RETURN NEW", utils.StripExtraWS(query))
+	assert.Equal(t, map[string]interface{}{
+		"@collection": "foo",
+		"var_0":       "8675309",
+		"var_1":       "bravo",
+		"var_2":       float64(2),
+		"var_3":       float64(1),
+		"var_4":       "1", // not shared with the number 1
+		"var_5":       []interface{}{"a", "b"},
+		"var_6":       []interface{}{"go"},
+		"var_7":       []interface{}{"c"},
+		"var_8":       10,
+		"var_9":       1, // not shared with the float 1
+
+	}, variables)
+	assert.Equal(t, "", s.database.LastQuery) // nothing ran
+
+	// lists that print alike still get their own variables
+	_, variables, err = s.collection.Atomic("1").Push("log", 1).Push("other", "1").
+		AddToSet("tags", "a b").Pull("blocked", "a", "b").ToAQL()
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{1}, variables["var_1"])
+	assert.Equal(t, []interface{}{"1"}, variables["var_2"])
+	assert.Equal(t, []interface{}{"a b"}, variables["var_3"])
+	assert.Equal(t, []interface{}{"a", "b"}, variables["var_4"])
+
+	factory := NewVariableFactory()
+	assert.NotEqual(t, factory.MakeVariable([]string{"a b"}).String(), factory.MakeVariable([]string{"a", "b"}).String())
+	assert.NotEqual(t, factory.MakeVariable(map[string]string{"a": "b c"}).String(), factory.MakeVariable(map[string]string{"a": "b c"}).String())
+	assert.Equal(t, factory.MakeVariable("a").String(), factory.MakeVariable("a").String())
+
+	// bad field names and paths
+	_, _, err = s.collection.Atomic("1").Inc("counter + 1, hacked: true", 1).ToAQL()
+	assert.EqualError(t, err, `invalid field name "counter + 1, hacked: true"`)
+	_, _, err = s.collection.Atomic("1").Set("_key", "2").ToAQL()
+	assert.EqualError(t, err, "_key can't be updated")
+	_, _, err = s.collection.Atomic("1").Inc("counter", 1).Dec("counter", 1).ToAQL()
+	assert.EqualError(t, err, "field counter is updated twice")
+	_, _, err = s.collection.Atomic("1").Set("stats", nil).Inc("stats.views", 1).ToAQL()
+	assert.EqualError(t, err, "field stats.views is updated along with a parent")
+	_, _, err = s.collection.Atomic("1").ToAQL()
+	assert.EqualError(t, err, "atomic update of foo has nothing to update")
+
+	// running it returns the new documents
+	s.database.MyCursor = &utils.MockCursor{Items: []string{`{"_key": "1112", "name": "obiwan", "counter": 3}`}}
+	record, err := s.collection.Atomic("1112").Inc("counter", 1).One(context.TODO())
+	assert.Nil(t, err)
+	assert.Equal(t, &MyDoc{Id: "1112", Name: "obiwan", Counter: 3}, record)
+	assert.Equal(t, "FOR doc IN @@collection FILTER (doc._key == @var_0) "+
+		"UPDATE doc WITH { counter: doc.counter + @var_1 } IN @@collection RETURN NEW", utils.StripExtraWS(s.database.LastQuery))
+
+	// or an error when nothing matched
+	s.database.MyCursor = &utils.MockCursor{}
+	_, err = s.collection.Atomic("1112").Inc("counter", 1).One(context.TODO())
+	assert.True(t, driver.IsNotFound(err))
+}
+
+func (s *OrmTests) SubTestUpdateAll(t *testing.T) {
+	ctx := context.TODO()
+	when := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	filter := s.collection.Query().WithinOrg("8675309")
+	op := filter.Operator()
+	s.database.MyCursor = &utils.MockCursor{Items: []string{`"1112"`, `"1113"`}}
+	ids, err := filter.UpdateAll(ctx, map[string]interface{}{
+		"name":       "obiwan",
+		"counter":    op.Add(op.Attribute("counter"), 1),
+		"updated_at": op.Call("DATE_NOW"),
+		"stats":      op.Merge("stats", map[string]interface{}{"seen": true}),
+		"seen_at":    when, // has a String method, but it's still a value
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"1112", "1113"}, ids)
+	assert.Equal(t, "FOR doc IN @@collection "+
+		"FILTER (doc.organization_id == @var_0) "+
+		`UPDATE doc WITH { "counter": (doc.counter + @var_1), "name": @var_3, "seen_at": @var_4, `+
+		`"stats": MERGE(doc.stats, @var_2), "updated_at": DATE_NOW() } IN @@collection `+
+		"RETURN NEW._key", utils.StripExtraWS(s.database.LastQuery))
+	assert.Equal(t, map[string]interface{}{
+		"@collection": "foo",
+		"var_0":       "8675309",
+		"var_1":       1,
+		"var_2":       map[string]interface{}{"seen": true}, // expressions bind their values when they're made
+		"var_3":       "obiwan",
+		"var_4":       when,
+	}, s.database.LastBindVars)
+
+	// options, and the documents before and after
+	s.database.MyCursor = &utils.MockCursor{Items: []string{
+		`{"key": "1112", "old": {"_key": "1112", "name": "ben", "counter": 1}, "new": {"_key": "1112", "name": "obiwan", "counter": 2}}`,
+	}}
+	results, err := s.collection.Query().ById("1112").UpdateAllWith(ctx, map[string]interface{}{"name": "obiwan", "b": nil},
+		UpdateOptions{DropNulls: true, ReplaceObjects: true, IgnoreErrors: true, ReturnOld: true, ReturnNew: true})
+	assert.Nil(t, err)
+	assert.Equal(t, "FOR doc IN @@collection "+
+		"FILTER (doc._key == @var_0) "+
+		`UPDATE doc WITH { "b": @var_1, "name": @var_2 } IN @@collection `+
+		"OPTIONS { keepNull: false, mergeObjects: false, ignoreErrors: true } "+
+		"RETURN { key: NEW._key, old: OLD, new: NEW }", utils.StripExtraWS(s.database.LastQuery))
+	assert.Equal(t, []UpdateResult{{
+		Key: "1112",
+		Old: &MyDoc{Id: "1112", Name: "ben", Counter: 1},
+		New: &MyDoc{Id: "1112", Name: "obiwan", Counter: 2},
+	}}, results)
+
+	// the key can't change, and there must be something to update
+	_, err = s.collection.Query().UpdateAll(ctx, map[string]interface{}{"_key": "1"})
+	assert.EqualError(t, err, "_key can't be updated")
+	_, err = s.collection.Query().UpdateAll(ctx, nil)
+	assert.EqualError(t, err, "update of foo has nothing to update")
+}
+
+func (s *OrmTests) SubTestValidation(t *testing.T) {
+	assert.Nil(t, Validate(&Member{Name: "ann", Role: "member", Age: 30}))
+
+	err := Validate(&Member{Name: "annabelle lee", Email: "ann", Role: "boss", Age: 12, Tags: []string{"a", "b", "c"},
+		Address: &Address{Zip: "123"}, Homes: []Address{{City: "Oslo"}, {}}})
+	assert.True(t, IsValidationError(err))
+	var validationError *ValidationError
+	assert.True(t, errors.As(err, &validationError))
+	assert.Equal(t, []FieldError{
+		{Field: "name", Rule: "max", Param: "10", Message: "must be at most 10 characters"},
+		{Field: "email", Rule: "email", Message: "must be an email address"},
+		{Field: "role", Rule: "oneof", Param: "admin member", Message: "must be one of admin, member"},
+		{Field: "age", Rule: "min", Param: "18", Message: "must be at least 18"},
+		{Field: "tags", Rule: "max", Param: "2", Message: "must be at most 2 items"},
+		{Field: "address.city", Rule: "required", Message: "is required"},
+		{Field: "address.zip", Rule: "len", Param: "5", Message: "must be exactly 5 characters"},
+		{Field: "homes[1].city", Rule: "required", Message: "is required"},
+	}, validationError.Errors)
+
+	// Validator methods run too
+	err = Validate(&Member{Name: "ann", Role: "admin", Age: 19})
+	assert.EqualError(t, err, "validation failed: age must be at least 21 for admins")
+
+	// the collection checks records before writing them, when asked to
+	s.database.MyCollection = utils.NewMockCollection()
+	s.collection.ValidateRecords = true
+	_, err = s.collection.Create(context.TODO(), &Member{Role: "member", Age: 30})
+	assert.EqualError(t, err, "validation failed: name is required")
+	assert.Equal(t, 0, len(s.database.MyCollection.Documents))
+	assert.True(t, IsValidationError(s.collection.Update(context.TODO(), &Member{Id: "1", Name: "ann", Role: "member"})))
+
+	// an upsert that would insert an invalid document doesn't run
+	s.database.LastQuery = ""
+	_, _, err = s.collection.Query().Filter("name", "ann").Upsert(context.TODO(), &Member{Name: "ann", Role: "boss", Age: 30}, nil)
+	assert.EqualError(t, err, "validation failed: role must be one of admin, member")
+	assert.Equal(t, "", s.database.LastQuery)
+
+	// and so does arangodb, with a schema
+	assert.True(t, IsValidationError(driver.ArangoError{HasError: true, Code: 400, ErrorNum: 1620}))
+	assert.False(t, IsValidationError(driver.ArangoError{HasError: true, Code: 409, ErrorNum: 1200}))
+
+	// embedded structs are stored flat, so are their errors
+	type Resident struct {
+		Address
+		Name string `json:"name" validate:"required"`
+	}
+	assert.EqualError(t, Validate(&Resident{Address: Address{Zip: "123"}}),
+		"validation failed: city is required; zip must be exactly 5 characters; name is required")
+
+	type Bad struct {
+		Name string `json:"name" validate:"required,shiny"`
+	}
+	assert.EqualError(t, Validate(&Bad{}), `field Name: unknown validate rule "shiny"`)
+}
+
+func (s *OrmTests) SubTestJSONSchema(t *testing.T) {
+	schema, err := JSONSchema(&Member{})
+	assert.Nil(t, err)
+
+	data, err := json.Marshal(schema)
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{
+		"type": "object",
+		"required": ["name"],
+		"properties": {
+			"name": {"not": {"enum": [""]}, "maxLength": 10},
+			"email": {"anyOf": [{"enum": [""]}, {"pattern": "^[^@\\s]+@[^@\\s]+\\.[^@\\s]+$"}]},
+			"role": {"enum": ["admin", "member"]},
+			"age": {"minimum": 18},
+			"tags": {"maxItems": 2},
+			"address": {"required": ["city"], "properties": {
+				"city": {"not": {"enum": [""]}},
+				"zip": {"anyOf": [{"enum": [""]}, {"minLength": 5, "maxLength": 5}]}
+			}},
+			"homes": {"items": {"required": ["city"], "properties": {
+				"city": {"not": {"enum": [""]}},
+				"zip": {"anyOf": [{"enum": [""]}, {"minLength": 5, "maxLength": 5}]}
+			}}}
+		}
+	}`, string(data))
+
+	type Resident struct {
+		Address
+		Id   string `json:"id"`
+		Name string `json:"name" validate:"required"`
+	}
+	schema, err = JSONSchema(&Resident{})
+	assert.Nil(t, err)
+	data, err = json.Marshal(schema)
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{
+		"type": "object",
+		"required": ["city", "name"],
+		"properties": {
+			"city": {"not": {"enum": [""]}},
+			"zip": {"anyOf": [{"enum": [""]}, {"minLength": 5, "maxLength": 5}]},
+			"name": {"not": {"enum": [""]}}
+		}
+	}`, string(data))
+
+	_, err = JSONSchema("nope")
+	assert.EqualError(t, err, "JSON schemas need a struct, not string")
 }
 
 func (s *OrmTests) SubTestQueryFirst(t *testing.T) {
@@ -338,10 +625,1261 @@ func (s *OrmTests) SubTestQueryInDocArray(t *testing.T) {
 	}, s.database.LastBindVars)
 }
 
+func (s *OrmTests) SubTestTypedQuery(t *testing.T) {
+	typed := Typed[MyDoc](s.collection)
+	records, err := typed.Query().WithinOrg("8675309").Filter("a", "apple").List().OrderBy("name").Asc().
+		Limit(3).All(context.TODO())
+
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(records))
+	assert.Equal(t, "Suzie Q", records[0].Name)
+	assert.Equal(t, "11", records[0].Id)
+	assert.Equal(t, "Hank", records[2].Name)
+
+	q := utils.StripExtraWS(s.database.LastQuery)
+	assert.Equal(t, "FOR doc IN @@collection "+
+		"FILTER (doc.organization_id == @var_0) "+
+		"FILTER (doc.a == @var_1) "+
+		"SORT doc.name ASC "+
+		"LIMIT @var_2 "+
+		"RETURN doc", q)
+
+	// traversals and searches hand out typed records too
+	s.database.MyCursor.Index = 0
+	edges := &EdgeCollection{Collection: &Collection{Connection: s.collection.Connection, TableName: "knows"}}
+	friends, err := typed.Query().ById("11").Traverse(edges).Any().Depth(1, 2).All(context.TODO())
+	assert.Nil(t, err)
+	assert.Equal(t, "Suzie Q", friends[0].Name)
+
+	s.database.MyCursor.Index = 0
+	view := &SearchView{Name: "foo_view", Collection: s.collection}
+	found, err := typed.Query().WithinOrg("8675309").Search(view).Prefix("name", "Su").First(context.TODO())
+	assert.Nil(t, err)
+	assert.Equal(t, "Suzie Q", found.Name)
+	assert.Equal(t, "foo_view", s.database.LastBindVars["@collection"])
+	assert.Contains(t, utils.StripExtraWS(s.database.LastQuery), "SEARCH STARTS_WITH(doc.name, @var_1) FILTER (doc.organization_id == @var_0)")
+}
+
+func (s *OrmTests) SubTestTypedCollectionAllocates(t *testing.T) {
+	typed := NewTypedCollection[MyDoc](s.collection.Connection, "foo")
+	record, err := typed.Query().ById("11").First(context.TODO())
+
+	assert.Nil(t, err)
+	assert.Equal(t, "Suzie Q", record.Name)
+	assert.Equal(t, "11", record.Id)
+
+	// wrapping an existing collection leaves it untouched
+	plain := &Collection{Connection: s.collection.Connection, TableName: "foo"}
+	wrapped := Typed[MyDoc](plain)
+
+	assert.Nil(t, plain.AllocateRecord)
+	assert.IsType(t, &MyDoc{}, wrapped.AllocateRecord())
+	assert.Equal(t, "foo", wrapped.TableName)
+}
+
+func (s *OrmTests) SubTestTransactionCommit(t *testing.T) {
+	conn := s.collection.Connection
+	err := conn.RunInTransaction(context.TODO(), []string{"bar"}, []string{"foo"}, func(ctx context.Context) error {
+		tid, ok := TransactionId(ctx)
+		assert.True(t, ok)
+		assert.Equal(t, "tx1", string(tid))
+
+		_, err := s.collection.Query().WithinOrg("1").List().All(ctx)
+		assert.Nil(t, err)
+
+		// queries in the transaction carry the transaction id
+		queryTid, ok := TransactionId(s.database.LastQueryCtx)
+		assert.True(t, ok)
+		assert.Equal(t, tid, queryTid)
+
+		// nested calls join the outer transaction
+		return conn.RunInTransaction(ctx, nil, []string{"foo"}, func(ctx context.Context) error {
+			_, err := s.collection.Query().WithinOrg("1").UpdateAll(ctx, map[string]interface{}{"a": "b"})
+			return err
+		})
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(s.database.Transactions))
+	assert.Equal(t, []string{"bar"}, s.database.Transactions[0].Read)
+	assert.Equal(t, []string{"foo"}, s.database.Transactions[0].Write)
+	assert.Equal(t, "tx1", string(s.database.Committed[0]))
+	assert.Equal(t, 0, len(s.database.Aborted))
+}
+
+func (s *OrmTests) SubTestTransactionAbort(t *testing.T) {
+	conn := s.collection.Connection
+	err := conn.RunInTransaction(context.TODO(), nil, []string{"foo"}, func(ctx context.Context) error {
+		return errors.New("nope")
+	})
+
+	assert.EqualError(t, err, "nope")
+	assert.Equal(t, 0, len(s.database.Committed))
+	assert.Equal(t, "tx1", string(s.database.Aborted[0]))
+
+	assert.Panics(t, func() {
+		_ = conn.RunInTransaction(context.TODO(), nil, []string{"foo"}, func(ctx context.Context) error {
+			panic("boom")
+		})
+	})
+	assert.Equal(t, 0, len(s.database.Committed))
+	assert.Equal(t, "tx2", string(s.database.Aborted[1]))
+
+	// a nested failure aborts the transaction, even when the outer call ignores it
+	err = conn.RunInTransaction(context.TODO(), nil, []string{"foo"}, func(ctx context.Context) error {
+		_ = conn.RunInTransaction(ctx, nil, []string{"foo"}, func(ctx context.Context) error {
+			return errors.New("inner nope")
+		})
+		return nil
+	})
+	assert.EqualError(t, err, "transaction tx3 is rollback-only after a nested call failed: inner nope")
+	assert.Equal(t, 0, len(s.database.Committed))
+	assert.Equal(t, "tx3", string(s.database.Aborted[2]))
+}
+
+func (s *OrmTests) SubTestTraversal(t *testing.T) {
+	edges := &EdgeCollection{Collection: &Collection{Connection: s.collection.Connection, TableName: "knows"}}
+
+	q := s.collection.Query().WithinOrg("8675309").Filter("name", "Bill")
+	traversal := q.Traverse(edges).Inbound().Depth(1, 3)
+	o := traversal.Operator()
+	objects, err := traversal.Where(o.StartsWith("name", "S")).FilterEdge("kind", "friend").All(context.TODO())
+
+	assert.Nil(t, err)
+	s.assertBasicMockRecords(t, objects)
+
+	query := utils.StripExtraWS(s.database.LastQuery)
+	assert.Equal(t, "FOR doc IN @@collection "+
+		"FILTER (doc.organization_id == @var_0) "+
+		"FILTER (doc.name == @var_1) "+
+		"FOR v, e, p IN 1..3 INBOUND doc @@var_4 "+
+		"FILTER p.vertices[* FILTER IS_SAME_COLLECTION(@var_5, CURRENT)].organization_id ALL == @var_0 "+
+		"FILTER v.name LIKE @var_2 "+
+		"FILTER (e.kind == @var_3) "+
+		"RETURN DISTINCT v", query)
+
+	assert.Equal(t, map[string]interface{}{
+		"@collection": "foo",
+		"var_0":       "8675309",
+		"var_1":       "Bill",
+		"var_2":       "S%",
+		"var_3":       "friend",
+		"@var_4":      "knows",
+		"var_5":       "foo",
+	}, s.database.LastBindVars)
+
+	// vertices of global collections aren't checked, other scoped ones are by their own key
+	s.database.MyCursor.Index = 0
+	countries := &Collection{Connection: s.collection.Connection, TableName: "countries", NoOrganization: true,
+		AllocateRecord: s.collection.AllocateRecord}
+	_, err = s.collection.Query().WithinOrg("8675309").Traverse(edges).Into(countries).All(context.TODO())
+	assert.Nil(t, err)
+	assert.Equal(t, "FOR doc IN @@collection "+
+		"FILTER (doc.organization_id == @var_0) "+
+		"FOR v, e, p IN 1..1 OUTBOUND doc @@var_1 "+
+		"FILTER p.vertices[* FILTER IS_SAME_COLLECTION(@var_2, CURRENT)].organization_id ALL == @var_0 "+
+		"RETURN DISTINCT v", utils.StripExtraWS(s.database.LastQuery))
+	assert.Equal(t, "foo", s.database.LastBindVars["var_2"])
+
+	s.database.MyCursor.Index = 0
+	teams := &Collection{Connection: s.collection.Connection, TableName: "teams", OrganizationIdKey: "tenant",
+		AllocateRecord: s.collection.AllocateRecord}
+	_, err = s.collection.Query().WithinOrg("8675309").Traverse(edges).Into(teams).All(context.TODO())
+	assert.Nil(t, err)
+	assert.Equal(t, "FOR doc IN @@collection "+
+		"FILTER (doc.organization_id == @var_0) "+
+		"FOR v, e, p IN 1..1 OUTBOUND doc @@var_1 "+
+		"FILTER p.vertices[* FILTER IS_SAME_COLLECTION(@var_2, CURRENT)].organization_id ALL == @var_0 "+
+		"FILTER p.vertices[* FILTER IS_SAME_COLLECTION(@var_3, CURRENT)].tenant ALL == @var_0 "+
+		"RETURN DISTINCT v", utils.StripExtraWS(s.database.LastQuery))
+	assert.Equal(t, "teams", s.database.LastBindVars["var_3"])
+}
+
+func (s *OrmTests) SubTestEdgeCreateNeedsHandles(t *testing.T) {
+	edges := &EdgeCollection{Collection: &Collection{Connection: s.collection.Connection, TableName: "knows"}}
+	_, err := edges.Create(context.TODO(), map[string]interface{}{"_from": "foo/1"})
+	assert.EqualError(t, err, "edges in knows must have a _to document handle")
+
+	assert.Equal(t, "foo/11", s.collection.Handle("11"))
+}
+
+func (s *OrmTests) SubTestIndexPlan(t *testing.T) {
+	removed := make([]string, 0)
+	makeIndex := func(spec IndexSpec) existingIndex {
+		return existingIndex{spec: spec, remove: func(ctx context.Context) error {
+			removed = append(removed, spec.Name)
+			return nil
+		}}
+	}
+
+	s.collection.Indexes = []IndexSpec{
+		{Kind: IndexPersistent, Fields: []string{"email"}, Unique: true},
+		{Name: "sessions_ttl", Kind: IndexTTL, Fields: []string{"expires_at"}, ExpireAfter: 60},
+		{Kind: IndexGeo, Fields: []string{"location"}, GeoJSON: true},
+	}
+	existing := []existingIndex{
+		makeIndex(IndexSpec{Name: OrganizationIndexName, Kind: "persistent", Fields: []string{"organization_id"}}),
+		makeIndex(IndexSpec{Name: "idx_persistent_email", Kind: "persistent", Fields: []string{"email"}}),
+		makeIndex(IndexSpec{Name: "sessions_ttl", Kind: "ttl", Fields: []string{"expires_at"}, ExpireAfter: 60}),
+		makeIndex(IndexSpec{Name: "handmade", Kind: "persistent", Fields: []string{"name"}}),
+	}
+
+	// without pruning we only create and report
+	diff, toCreate, toDrop := planIndexes(existing, s.collection.managedIndexes(), false)
+	assert.Equal(t, []string{"idx_geo_location"}, diff.Created)
+	assert.Equal(t, []string{OrganizationIndexName, "sessions_ttl"}, diff.Unchanged)
+	assert.Equal(t, []string{"idx_persistent_email"}, diff.Changed)
+	assert.Equal(t, []string{"handmade"}, diff.Unmanaged)
+	assert.Nil(t, diff.Dropped)
+	assert.Equal(t, 1, len(toCreate))
+	assert.Equal(t, 0, len(toDrop))
+	assert.False(t, diff.InSync())
+
+	// pruning drops unmanaged indexes and redefines changed ones
+	diff, toCreate, toDrop = planIndexes(existing, s.collection.managedIndexes(), true)
+	assert.Equal(t, []string{"handmade"}, diff.Dropped)
+	assert.Equal(t, []string{"idx_persistent_email", "idx_geo_location"}, []string{toCreate[0].IndexName(), toCreate[1].IndexName()})
+	for _, index := range toDrop {
+		assert.Nil(t, index.remove(context.TODO()))
+	}
+	assert.Equal(t, []string{"idx_persistent_email", "handmade"}, removed)
+
+	// indexes with a generated name are matched by their definition, old geo kinds are geo indexes
+	removed = removed[:0]
+	existing = []existingIndex{
+		makeIndex(IndexSpec{Name: "idx_1781423756372017152", Kind: "persistent", Fields: []string{"organization_id"}}),
+		makeIndex(IndexSpec{Name: "idx_1781423756372017153", Kind: "persistent", Fields: []string{"email"}, Unique: true}),
+		makeIndex(IndexSpec{Name: "sessions_ttl", Kind: "ttl", Fields: []string{"expires_at"}, ExpireAfter: 60}),
+		makeIndex(IndexSpec{Name: "idx_geo_location", Kind: "geo1", Fields: []string{"location"}, GeoJSON: true}),
+	}
+	diff, toCreate, toDrop = planIndexes(existing, s.collection.managedIndexes(), true)
+	assert.Equal(t, []string{OrganizationIndexName, "idx_persistent_email", "sessions_ttl", "idx_geo_location"}, diff.Unchanged)
+	assert.True(t, diff.InSync())
+	assert.Equal(t, 0, len(toCreate))
+	assert.Equal(t, 0, len(toDrop))
+
+	// but an index named by another spec isn't taken
+	existing = []existingIndex{
+		makeIndex(IndexSpec{Name: "idx_persistent_email", Kind: "persistent", Fields: []string{"organization_id"}}),
+	}
+	diff, _, _ = planIndexes(existing, s.collection.managedIndexes(), false)
+	assert.Equal(t, []string{OrganizationIndexName, "sessions_ttl", "idx_geo_location"}, diff.Created)
+	assert.Equal(t, []string{"idx_persistent_email"}, diff.Changed)
+
+	// no organization index when asked not to
+	s.collection.NoOrganizationIndex = true
+	assert.Equal(t, 3, len(s.collection.managedIndexes()))
+}
+
+type HookedDoc struct {
+	Id    string   `json:"id"`
+	Email string   `json:"email"`
+	Calls []string `json:"-"`
+	Fail  string   `json:"-"`
+}
+
+func (c *HookedDoc) hook(name string) error {
+	c.Calls = append(c.Calls, name)
+	if c.Fail == name {
+		return errors.New(name + " failed")
+	}
+	return nil
+}
+
+func (c *HookedDoc) BeforeCreate(ctx context.Context) error {
+	c.Email = strings.ToLower(c.Email)
+	return c.hook("BeforeCreate")
+}
+
+func (c *HookedDoc) AfterCreate(ctx context.Context) error  { return c.hook("AfterCreate") }
+func (c *HookedDoc) BeforeUpdate(ctx context.Context) error { return c.hook("BeforeUpdate") }
+func (c *HookedDoc) BeforeDelete(ctx context.Context) error { return c.hook("BeforeDelete") }
+func (c *HookedDoc) AfterRead(ctx context.Context) error    { return c.hook("AfterRead") }
+
+func (s *OrmTests) SubTestHooks(t *testing.T) {
+	ctx := context.TODO()
+	mock := utils.NewMockCollection()
+	s.database.MyCollection = mock
+	s.collection.AllocateRecord = func() interface{} { return &HookedDoc{} }
+
+	// collection hooks run after the record's own
+	stamped := make([]string, 0)
+	stamp := func(name string) Hook {
+		return func(ctx context.Context, record interface{}) error {
+			stamped = append(stamped, name+" "+record.(*HookedDoc).Email)
+			return nil
+		}
+	}
+	s.collection.Hooks = Hooks{
+		BeforeCreate: []Hook{stamp("BeforeCreate")},
+		AfterCreate:  []Hook{stamp("AfterCreate")},
+		BeforeUpdate: []Hook{stamp("BeforeUpdate")},
+		BeforeDelete: []Hook{stamp("BeforeDelete")},
+		AfterRead:    []Hook{stamp("AfterRead")},
+	}
+
+	doc := &HookedDoc{Email: "Ann@Example.COM"}
+	id, err := s.collection.Create(ctx, doc)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"BeforeCreate", "AfterCreate"}, doc.Calls)
+	assert.Equal(t, "ann@example.com", mock.Documents[id]["email"])
+
+	record, err := s.collection.Get(ctx, id)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"AfterRead"}, record.(*HookedDoc).Calls)
+
+	doc = record.(*HookedDoc)
+	doc.Calls = nil
+	assert.Nil(t, s.collection.Update(ctx, doc))
+	assert.Nil(t, s.collection.Delete(ctx, doc))
+	assert.Equal(t, []string{"BeforeUpdate", "BeforeDelete"}, doc.Calls)
+	assert.Equal(t, []string{"BeforeCreate ann@example.com", "AfterCreate ann@example.com", "AfterRead ann@example.com",
+		"BeforeUpdate ann@example.com", "BeforeDelete ann@example.com"}, stamped)
+
+	// errors from before hooks stop the write
+	_, err = s.collection.Create(ctx, &HookedDoc{Email: "bob@example.com", Fail: "BeforeCreate"})
+	assert.EqualError(t, err, "BeforeCreate failed")
+	assert.Equal(t, 0, len(mock.Documents))
+
+	results, err := s.collection.CreateMany(ctx, []interface{}{&HookedDoc{Email: "cat"}, &HookedDoc{Email: "dan", Fail: "BeforeCreate"}})
+	assert.Nil(t, err)
+	assert.Nil(t, results[0].Err)
+	assert.EqualError(t, results[1].Err, "BeforeCreate failed")
+	assert.Equal(t, 1, len(mock.Documents))
+
+	// errors after the create come back with the key
+	id, err = s.collection.Create(ctx, &HookedDoc{Email: "eve", Fail: "AfterCreate"})
+	assert.EqualError(t, err, "AfterCreate failed")
+	assert.NotEqual(t, "", id)
+
+	// upserts normalize the document they'd insert, and run AfterCreate when they did
+	s.database.MyCursor = &utils.MockCursor{Items: []string{`{"key": "abc", "inserted": true}`}}
+	stamped = stamped[:0]
+	upserted := &HookedDoc{Email: "Fay@Example.COM"}
+	_, inserted, err := s.collection.Query().Filter("email", "fay@example.com").Upsert(ctx, upserted, nil)
+	assert.Nil(t, err)
+	assert.True(t, inserted)
+	assert.Equal(t, []string{"BeforeCreate", "AfterCreate"}, upserted.Calls)
+	assert.Equal(t, "fay@example.com", s.database.LastBindVars["var_1"].(map[string]interface{})["email"])
+	assert.Equal(t, []string{"BeforeCreate fay@example.com", "AfterCreate fay@example.com"}, stamped)
+
+	_, _, err = s.collection.Query().Filter("email", "gus").Upsert(ctx, &HookedDoc{Fail: "BeforeCreate"}, nil)
+	assert.EqualError(t, err, "BeforeCreate failed")
+
+	// queries run AfterRead, unless the records aren't the collection's type
+	s.database.MyCursor = &utils.MockCursor{Items: []string{`{"_key": "11", "email": "ann@example.com"}`}}
+	records, err := s.collection.Query().List().All(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"AfterRead"}, records[0].(*HookedDoc).Calls)
+
+	stamped = stamped[:0]
+	s.database.MyCursor.Index = 0
+	_, err = s.collection.Query().List().As(func() interface{} { return &MyDoc{} }).All(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(stamped))
+
+	s.collection.Hooks.AfterRead = []Hook{func(ctx context.Context, record interface{}) error {
+		return errors.New("can't read")
+	}}
+	_, err = s.collection.Get(ctx, id)
+	assert.EqualError(t, err, "can't read")
+}
+
+type RevDoc struct {
+	Meta
+	Name           string `json:"name"`
+	OrganizationId string `json:"organization_id"`
+}
+
+func (s *OrmTests) SubTestOptimisticConcurrency(t *testing.T) {
+	ctx := context.TODO()
+	s.database.MyCollection = utils.NewMockCollection()
+	revs := Typed[RevDoc](&Collection{Connection: s.collection.Connection, TableName: "revs"})
+
+	id, err := revs.Create(ctx, &RevDoc{Name: "first", OrganizationId: "1"})
+	assert.Nil(t, err)
+
+	mine, err := revs.Get(ctx, id)
+	assert.Nil(t, err)
+	assert.Equal(t, id, mine.Id)
+	assert.Equal(t, "rev1", mine.Rev)
+
+	theirs, err := revs.Get(ctx, id)
+	assert.Nil(t, err)
+
+	// the first update wins and the record picks up the new revision
+	mine.Name = "mine"
+	assert.Nil(t, revs.Update(ctx, mine))
+	assert.Equal(t, "rev2", mine.Rev)
+
+	// the second one is working from a stale revision
+	theirs.Name = "theirs"
+	err = revs.Update(ctx, theirs)
+	assert.True(t, IsConflict(err))
+	assert.ErrorIs(t, err, ErrConflict)
+	var conflict *ConflictError
+	assert.ErrorAs(t, err, &conflict)
+	assert.Equal(t, "rev1", conflict.Rev)
+
+	err = revs.Delete(ctx, theirs)
+	assert.True(t, IsConflict(err))
+
+	stored, err := revs.Get(ctx, id)
+	assert.Nil(t, err)
+	assert.Equal(t, "mine", stored.Name)
+
+	// records without a revision just overwrite
+	assert.Nil(t, revs.Update(ctx, &RevDoc{Meta: Meta{Id: id}, Name: "blind"}))
+	assert.Nil(t, revs.Delete(ctx, &RevDoc{Meta: Meta{Id: id}}))
+}
+
+func (s *OrmTests) SubTestBulkOperations(t *testing.T) {
+	ctx := context.TODO()
+	mockCollection := utils.NewMockCollection()
+	s.database.MyCollection = mockCollection
+	revs := Typed[RevDoc](&Collection{Connection: s.collection.Connection, TableName: "revs", BatchSize: 2})
+
+	// 5 documents go out in batches of 2
+	records := []*RevDoc{{Name: "a"}, {Name: "b"}, {Name: "c"}, {Name: "d"}, {Name: "e"}}
+	results, err := revs.CreateMany(ctx, records)
+	assert.Nil(t, err)
+	assert.Nil(t, results.Err())
+	assert.Equal(t, 5, len(results))
+	assert.Equal(t, 5, len(mockCollection.Documents))
+	for _, key := range results.Keys() {
+		assert.NotEmpty(t, key)
+	}
+
+	// read them back, then let someone else change "b"
+	stored := make([]*RevDoc, 0)
+	for _, key := range results.Keys() {
+		record, err := revs.Get(ctx, key)
+		assert.Nil(t, err)
+		stored = append(stored, record)
+	}
+	assert.Nil(t, revs.Update(ctx, &RevDoc{Meta: Meta{Id: stored[1].Id}, Name: "someone else"}))
+
+	// only the stale one and the one that doesn't exist fail
+	for _, record := range stored {
+		record.Name = record.Name + "!"
+	}
+	missing := &RevDoc{Meta: Meta{Id: "nope"}, Name: "missing"}
+	results, err = revs.UpdateMany(ctx, append(stored, missing))
+	assert.Nil(t, err)
+	errs := results.Errors()
+	assert.Nil(t, errs[0])
+	assert.True(t, IsConflict(errs[1]))
+	assert.Nil(t, errs[2])
+	assert.True(t, IsNotFound(errs[5]))
+	assert.Equal(t, results[0].Rev, stored[0].Rev)
+
+	record, err := revs.Get(ctx, stored[0].Id)
+	assert.Nil(t, err)
+	assert.Equal(t, "a!", record.Name)
+
+	// delete a mix of records with fresh, stale and no revisions
+	results, err = revs.DeleteMany(ctx, []*RevDoc{stored[0], stored[1], {Meta: Meta{Id: stored[2].Id}}})
+	assert.Nil(t, err)
+	errs = results.Errors()
+	assert.Nil(t, errs[0])
+	assert.True(t, IsConflict(errs[1]))
+	assert.Nil(t, errs[2])
+	assert.Equal(t, 3, len(mockCollection.Documents))
+
+	// records need ids
+	results, err = revs.DeleteMany(ctx, []*RevDoc{{Name: "no id"}})
+	assert.Nil(t, err)
+	assert.EqualError(t, results.Err(), "document must have an actual id")
+}
+
+func (s *OrmTests) SubTestBulkRequestFailure(t *testing.T) {
+	ctx := context.TODO()
+	mockCollection := utils.NewMockCollection()
+	s.database.MyCollection = mockCollection
+	revs := Typed[RevDoc](&Collection{Connection: s.collection.Connection, TableName: "revs", BatchSize: 2})
+	unavailable := driver.ArangoError{HasError: true, Code: 503, ErrorNum: driver.ErrClusterNotLeader}
+
+	// the second batch fails, so it and the third one were never written
+	mockCollection.RequestErrors = []error{nil, unavailable}
+	records := []*RevDoc{{Name: "a"}, {Name: "b"}, {Name: "c"}, {Name: "d"}, {Name: "e"}}
+	results, err := revs.CreateMany(ctx, records)
+	assert.Equal(t, unavailable, err)
+	assert.Equal(t, 2, len(mockCollection.Documents))
+	errs := results.Errors()
+	assert.Nil(t, errs[0])
+	assert.Nil(t, errs[1])
+	for _, err := range errs[2:] {
+		assert.Equal(t, unavailable, err)
+	}
+	assert.NotEmpty(t, results[1].Key)
+	assert.Empty(t, results[2].Key)
+
+	stored := make([]*RevDoc, 0)
+	for _, key := range results.Keys()[:2] {
+		record, err := revs.Get(ctx, key)
+		assert.Nil(t, err)
+		stored = append(stored, record)
+	}
+	batch := []*RevDoc{stored[0], {Meta: Meta{Id: "x"}}, stored[1], {Meta: Meta{Id: "y"}}}
+
+	mockCollection.RequestErrors = []error{nil, unavailable}
+	results, err = revs.UpdateMany(ctx, batch)
+	assert.Equal(t, unavailable, err)
+	errs = results.Errors()
+	assert.Nil(t, errs[0])
+	assert.True(t, IsNotFound(errs[1]))
+	assert.Equal(t, unavailable, errs[2])
+	assert.Equal(t, unavailable, errs[3])
+
+	// documents without a revision go first, so the ones with one are never sent either
+	mockCollection.RequestErrors = []error{unavailable}
+	results, err = revs.DeleteMany(ctx, []*RevDoc{stored[0], {Meta: Meta{Id: stored[1].Id}}, stored[1]})
+	assert.Equal(t, unavailable, err)
+	assert.Equal(t, driver.ErrorSlice{unavailable, unavailable, unavailable}, results.Errors())
+	assert.Equal(t, 2, len(mockCollection.Documents))
+}
+
+func (s *OrmTests) SubTestUpsert(t *testing.T) {
+	s.database.MyCursor = &utils.MockCursor{Items: []string{`{"key": "abc", "inserted": true}`}}
+
+	key, inserted, err := s.collection.Query().WithinOrg("8675309").Filter("email", "bob@abc.com").
+		Upsert(context.TODO(), &MyDoc{Name: "Bob"}, map[string]interface{}{"name": "Robert"})
+	assert.Nil(t, err)
+	assert.Equal(t, "abc", key)
+	assert.True(t, inserted)
+
+	q := utils.StripExtraWS(s.database.LastQuery)
+	assert.Equal(t, `UPSERT { "organization_id": @var_0, "email": @var_1 } `+
+		`INSERT @var_2 UPDATE @var_3 IN @@collection `+
+		`RETURN { key: NEW._key, inserted: OLD == null }`, q)
+
+	// the filter values are put into the inserted document
+	insert := s.database.LastBindVars["var_2"].(map[string]interface{})
+	assert.Equal(t, "Bob", insert["name"])
+	assert.Equal(t, "8675309", insert["organization_id"])
+	assert.Equal(t, "bob@abc.com", insert["email"])
+	assert.NotEmpty(t, insert["_key"])
+	assert.Equal(t, map[string]interface{}{"name": "Robert"}, s.database.LastBindVars["var_3"])
+
+	// only equality filters can be upserted
+	q2 := s.collection.Query()
+	o := q2.Operator()
+	_, _, err = q2.Where(o.StartsWith("name", "B")).Upsert(context.TODO(), &MyDoc{}, nil)
+	assert.EqualError(t, err, "upsert only supports equality filters, not doc.name LIKE @var_0 ")
+
+	_, _, err = s.collection.Query().Upsert(context.TODO(), &MyDoc{}, nil)
+	assert.EqualError(t, err, "upsert on foo needs at least one filter")
+}
+
+func (s *OrmTests) SubTestOrgWrites(t *testing.T) {
+	org := s.collection.ForOrg("8675309")
+
+	// the ownership check and the write are one query
+	s.database.MyCursor = &utils.MockCursor{Items: []string{`"_rev2"`}}
+	err := org.Update(context.TODO(), &MyDoc{Id: "11", Name: "bob"})
+	assert.Nil(t, err)
+	assert.Equal(t, `FOR doc IN @@collection FILTER (doc.organization_id == @var_0) FILTER (doc._key == @var_1) `+
+		`UPDATE doc WITH @var_2 IN @@collection RETURN NEW._rev`, utils.StripExtraWS(s.database.LastQuery))
+	assert.Equal(t, "8675309", s.database.LastBindVars["var_2"].(map[string]interface{})["organization_id"])
+
+	s.database.MyCursor = &utils.MockCursor{Items: []string{`"_rev2"`}}
+	assert.Nil(t, org.Delete(context.TODO(), &MyDoc{Id: "11"}))
+	assert.Equal(t, `FOR doc IN @@collection FILTER (doc.organization_id == @var_0) FILTER (doc._key == @var_1) `+
+		`REMOVE doc IN @@collection RETURN OLD._rev`, utils.StripExtraWS(s.database.LastQuery))
+
+	// no row means the organization doesn't have the document
+	s.database.MyCursor = &utils.MockCursor{}
+	assert.True(t, IsNotFound(org.Delete(context.TODO(), &MyDoc{Id: "22"})))
+}
+
+func (s *OrmTests) SubTestOrgUninitialized(t *testing.T) {
+	ctx := context.TODO()
+	mockCollection := utils.NewMockCollection()
+	s.database.MyCollection = mockCollection
+
+	// Initialize never ran, so OrganizationIdKey is still empty
+	docs := Typed[MyDoc](&Collection{Connection: s.collection.Connection, TableName: "docs"}).ForOrg("8675309")
+	id, err := docs.Create(ctx, &MyDoc{Name: "bob"})
+	assert.Nil(t, err)
+	assert.Equal(t, "8675309", mockCollection.Documents[id]["organization_id"])
+
+	note := map[string]interface{}{"text": "kept"}
+	_, err = docs.OrgCollection.Create(ctx, note)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"text": "kept", "organization_id": "8675309"}, note)
+
+	s.database.MyCursor = &utils.MockCursor{}
+	_, err = docs.Query().List().All(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, "FOR doc IN @@collection FILTER (doc.organization_id == @var_0) RETURN doc", utils.StripExtraWS(s.database.LastQuery))
+
+	// global collections can't be scoped
+	countries := &Collection{Connection: s.collection.Connection, TableName: "countries", NoOrganization: true}
+	_, err = countries.ForOrg("8675309").Create(ctx, &MyDoc{Name: "France"})
+	assert.EqualError(t, err, "countries: records of NoOrganization collections don't belong to an organization")
+}
+
+func (s *OrmTests) SubTestIterate(t *testing.T) {
+	it, err := s.collection.Query().WithinOrg("8675309").List().BatchSize(2).TTL(time.Minute).Iterate(context.TODO())
+	assert.Nil(t, err)
+	assert.Equal(t, 2, s.database.LastQueryCtx.Value("arangodb-query-batchSize"))
+	assert.Equal(t, time.Minute, s.database.LastQueryCtx.Value("arangodb-query-ttl"))
+
+	objects := make([]interface{}, 0)
+	for it.Next() {
+		objects = append(objects, it.Item())
+	}
+	assert.Nil(t, it.Err())
+	s.assertBasicMockRecords(t, objects)
+	assert.True(t, s.database.MyCursor.Closed)
+}
+
+func (s *OrmTests) SubTestIterateStopEarly(t *testing.T) {
+	it, err := Typed[MyDoc](s.collection).Query().List().Iterate(context.TODO())
+	assert.Nil(t, err)
+
+	assert.True(t, it.Next())
+	assert.Equal(t, "Suzie Q", it.Item().Name)
+	assert.False(t, s.database.MyCursor.Closed)
+
+	assert.Nil(t, it.Close())
+	assert.True(t, s.database.MyCursor.Closed)
+	assert.False(t, it.Next())
+	assert.Nil(t, it.Err())
+}
+
+func (s *OrmTests) SubTestKeysetPagination(t *testing.T) {
+	s.collection.Connection.PageTokenSecret = []byte("sssh")
+
+	items := s.collection.Query().WithinOrg("8675309").List().OrderBy("name").Desc().Limit(3).After("")
+	objects, err := items.All(context.TODO())
+	assert.Nil(t, err)
+	s.assertBasicMockRecords(t, objects)
+
+	q := utils.StripExtraWS(s.database.LastQuery)
+	assert.Equal(t, "FOR doc IN @@collection "+
+		"FILTER (doc.organization_id == @var_0) "+
+		"SORT doc.name DESC, doc._key DESC "+
+		"LIMIT @var_1 "+
+		"RETURN doc", q)
+
+	token := items.PageToken()
+	assert.NotEqual(t, "", token)
+
+	// the next page picks up after Hank, the last one read
+	s.database.MyCursor.Index = 0
+	_, err = s.collection.Query().WithinOrg("8675309").List().OrderBy("name").Desc().Limit(3).After(token).All(context.TODO())
+	assert.Nil(t, err)
+
+	q = utils.StripExtraWS(s.database.LastQuery)
+	assert.Equal(t, "FOR doc IN @@collection "+
+		"FILTER (doc.organization_id == @var_0) "+
+		"FILTER (doc.name < @var_2 || (doc.name == @var_2 && doc._key < @var_3)) "+
+		"SORT doc.name DESC, doc._key DESC "+
+		"LIMIT @var_1 "+
+		"RETURN doc", q)
+	assert.Equal(t, "Hank", s.database.LastBindVars["var_2"])
+	assert.Equal(t, "33", s.database.LastBindVars["var_3"])
+
+	// the token was made for a different order
+	_, err = s.collection.Query().List().Limit(10).After(token).All(context.TODO())
+	assert.ErrorIs(t, err, ErrInvalidPageToken)
+
+	// or for other filters, or another collection
+	_, err = s.collection.Query().WithinOrg("5551212").List().OrderBy("name").Desc().Limit(3).After(token).All(context.TODO())
+	assert.ErrorIs(t, err, ErrInvalidPageToken)
+	_, err = s.collection.Query().List().OrderBy("name").Desc().Limit(3).After(token).All(context.TODO())
+	assert.ErrorIs(t, err, ErrInvalidPageToken)
+	other := &Collection{Connection: s.collection.Connection, TableName: "bar", OrganizationIdKey: "organization_id", AllocateRecord: s.collection.AllocateRecord}
+	_, err = other.Query().WithinOrg("8675309").List().OrderBy("name").Desc().Limit(3).After(token).All(context.TODO())
+	assert.ErrorIs(t, err, ErrInvalidPageToken)
+
+	// the same filters match however their variables are numbered
+	s.database.MyCursor.Index = 0
+	renumbered := s.collection.Query()
+	renumbered.variableFactory.MakeVariable("unused")
+	_, err = renumbered.WithinOrg("8675309").List().OrderBy("name").Desc().Limit(3).After(token).All(context.TODO())
+	assert.Nil(t, err)
+	assert.Contains(t, s.database.LastQuery, "doc.organization_id == @var_1")
+
+	// a short page is the last one
+	s.database.MyCursor.Index = 0
+	items = s.collection.Query().List().Limit(10).After("")
+	_, err = items.All(context.TODO())
+	assert.Nil(t, err)
+	assert.Equal(t, "", items.PageToken())
+
+	// tampered tokens and unstable orders are refused
+	_, err = s.collection.Query().List().OrderBy("name").Desc().After(token + "x").All(context.TODO())
+	assert.ErrorIs(t, err, ErrInvalidPageToken)
+
+	s.collection.Connection.PageTokenSecret = []byte("something else")
+	_, err = s.collection.Query().List().OrderBy("name").Desc().After(token).All(context.TODO())
+	assert.ErrorIs(t, err, ErrInvalidPageToken)
+
+	_, err = s.collection.Query().List().RandomOrder().After("").All(context.TODO())
+	assert.NotNil(t, err)
+
+	// integer order values come back exactly, even past what a float64 holds
+	s.database.MyCursor.Index = 0
+	big := int64(1)<<53 + 1
+	token, err = encodePageToken(tokenSecret(s.collection.Connection),
+		&pageToken{Collection: "foo", OrderKey: "seq", Direction: "ASC", Value: big, Key: "33"})
+	assert.Nil(t, err)
+	_, err = s.collection.Query().List().OrderBy("seq").Asc().Limit(3).After(token).All(context.TODO())
+	assert.Nil(t, err)
+	assert.Equal(t, big, s.database.LastBindVars["var_1"])
+	assert.Equal(t, "33", s.database.LastBindVars["var_2"])
+}
+
+func (s *OrmTests) SubTestAggregate(t *testing.T) {
+	s.database.MyCursor = &utils.MockCursor{
+		Items: []string{
+			`{"organization_id": "1138", "total": 12.5, "docs": 3, "names": ["a", "b", "c"]}`,
+			`{"organization_id": "8675309", "total": 4, "docs": 1, "names": ["d"]}`,
+		},
+	}
+
+	var rows []struct {
+		OrganizationId string   `json:"organization_id"`
+		Total          float64  `json:"total"`
+		Docs           int      `json:"docs"`
+		Names          []string `json:"names"`
+	}
+	err := s.collection.Query().Filter("b", "bravo").Aggregate().GroupBy("organization_id").
+		Sum("total", "amount").Count("docs").Push("names", "name").All(context.TODO(), &rows)
+	assert.Nil(t, err)
+
+	q := utils.StripExtraWS(s.database.LastQuery)
+	assert.Equal(t, "FOR doc IN @@collection "+
+		"FILTER (doc.b == @var_0) "+
+		"COLLECT g0 = doc.organization_id AGGREGATE a0 = SUM(doc.amount), a1 = COUNT(1) INTO pushed = { p0: doc.name } "+
+		`RETURN { "organization_id": g0, "total": a0, "docs": a1, "names": pushed[*].p0 }`, q)
+
+	assert.Equal(t, 2, len(rows))
+	assert.Equal(t, "1138", rows[0].OrganizationId)
+	assert.Equal(t, 12.5, rows[0].Total)
+	assert.Equal(t, 3, rows[0].Docs)
+	assert.Equal(t, []string{"a", "b", "c"}, rows[0].Names)
+	assert.Equal(t, 1, rows[1].Docs)
+
+	// rows as maps, no grouping
+	s.database.MyCursor = &utils.MockCursor{Items: []string{`{"avg": 2.5, "low": 1, "high": 4, "kinds": 2}`}}
+	var maps []map[string]interface{}
+	err = s.collection.Query().Aggregate().Avg("avg", "counter").Min("low", "counter").Max("high", "counter").
+		CountDistinct("kinds", "d").All(context.TODO(), &maps)
+	assert.Nil(t, err)
+
+	q = utils.StripExtraWS(s.database.LastQuery)
+	assert.Equal(t, "FOR doc IN @@collection "+
+		"COLLECT AGGREGATE a0 = AVERAGE(doc.counter), a1 = MIN(doc.counter), a2 = MAX(doc.counter), a3 = COUNT_DISTINCT(doc.d) "+
+		`RETURN { "avg": a0, "low": a1, "high": a2, "kinds": a3 }`, q)
+	assert.Equal(t, []map[string]interface{}{{"avg": 2.5, "low": float64(1), "high": float64(4), "kinds": float64(2)}}, maps)
+
+	// mistakes
+	assert.NotNil(t, s.collection.Query().Aggregate().Sum("x", "a").Max("x", "b").All(context.TODO(), &maps))
+	assert.NotNil(t, s.collection.Query().Aggregate().All(context.TODO(), &maps))
+	assert.NotNil(t, s.collection.Query().Aggregate().Count("n").All(context.TODO(), maps))
+}
+
+func (s *OrmTests) SubTestProjections(t *testing.T) {
+	objects, err := s.collection.Query().Filter("b", "bravo").List().Select("name", "b").All(context.TODO())
+	assert.Nil(t, err)
+	s.assertBasicMockRecords(t, objects) // the mock cursor doesn't project
+
+	q := utils.StripExtraWS(s.database.LastQuery)
+	assert.Equal(t, "FOR doc IN @@collection "+
+		"FILTER (doc.b == @var_0) "+
+		"RETURN KEEP(doc, @var_1)", q)
+	assert.Equal(t, []string{"_key", "name", "b"}, s.database.LastBindVars["var_1"])
+
+	// _key always stays, so records keep their id
+	s.database.MyCursor.Index = 0
+	_, err = s.collection.Query().List().Omit("_key", "c", "d").All(context.TODO())
+	assert.Nil(t, err)
+
+	q = utils.StripExtraWS(s.database.LastQuery)
+	assert.Equal(t, "FOR doc IN @@collection RETURN UNSET(doc, @var_0)", q)
+	assert.Equal(t, []string{"c", "d"}, s.database.LastBindVars["var_0"])
+
+	// into a slimmer struct
+	type Slim struct {
+		Id   string `json:"id"`
+		Name string `json:"name"`
+	}
+	s.database.MyCursor.Index = 0
+	slims, err := ProjectAs[Slim](Typed[MyDoc](s.collection).Query().List().Select("name")).All(context.TODO())
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(slims))
+	assert.Equal(t, Slim{Id: "11", Name: "Suzie Q"}, *slims[0])
+
+	// page tokens need the order key, so it's kept
+	s.database.MyCursor.Index = 0
+	_, err = s.collection.Query().List().OrderBy("name").Asc().After("").Select("b").All(context.TODO())
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"_key", "b", "name"}, s.database.LastBindVars["var_0"])
+}
+
+func (s *OrmTests) SubTestInclude(t *testing.T) {
+	type Org struct {
+		Id   string `json:"id"`
+		Name string `json:"name"`
+	}
+	type Widget struct {
+		Id             string `json:"id"`
+		OrganizationId string `json:"organization_id"`
+		Organization   *Org   `json:"organization"`
+		Tags           []*Org `json:"tags"`
+	}
+	s.database.MyCursor = &utils.MockCursor{
+		Items: []string{
+			`{"_key": "w1", "organization_id": "o1", "organization": {"id": "o1", "name": "Acme"}, "tags": [{"id": "t1", "name": "red"}, {"id": "t2", "name": "blue"}]}`,
+		},
+	}
+	orgs := &Collection{Connection: s.collection.Connection, TableName: "orgs"}
+	tags := &Collection{Connection: s.collection.Connection, TableName: "tags"}
+
+	widgets, err := ProjectAs[Widget](Typed[MyDoc](s.collection).Query().Filter("b", "bravo").List().Limit(5)).
+		Include("organization_id", orgs, "organization").Include("tag_ids", tags, "tags").All(context.TODO())
+	assert.Nil(t, err)
+
+	q := utils.StripExtraWS(s.database.LastQuery)
+	assert.Equal(t, "FOR doc IN @@collection "+
+		"FILTER (doc.b == @var_0) "+
+		"LIMIT @var_1 "+
+		"LET include_0 = (LET include_0_keys = IS_ARRAY(doc.organization_id) ? doc.organization_id : [doc.organization_id] "+
+		"FOR include_0_doc IN @@var_2 FILTER include_0_doc._key IN include_0_keys SORT POSITION(include_0_keys, include_0_doc._key, true) "+
+		`RETURN MERGE(UNSET(include_0_doc, "_key", "_id"), { id: include_0_doc._key })) `+
+		"LET include_1 = (LET include_1_keys = IS_ARRAY(doc.tag_ids) ? doc.tag_ids : [doc.tag_ids] "+
+		"FOR include_1_doc IN @@var_3 FILTER include_1_doc._key IN include_1_keys SORT POSITION(include_1_keys, include_1_doc._key, true) "+
+		`RETURN MERGE(UNSET(include_1_doc, "_key", "_id"), { id: include_1_doc._key })) `+
+		`RETURN MERGE(doc, { "organization": IS_ARRAY(doc.organization_id) ? include_0 : FIRST(include_0), `+
+		`"tags": IS_ARRAY(doc.tag_ids) ? include_1 : FIRST(include_1) })`, q)
+	assert.Equal(t, "orgs", s.database.LastBindVars["@var_2"])
+	assert.Equal(t, "tags", s.database.LastBindVars["@var_3"])
+
+	assert.Equal(t, 1, len(widgets))
+	assert.Equal(t, "w1", widgets[0].Id)
+	assert.Equal(t, &Org{Id: "o1", Name: "Acme"}, widgets[0].Organization)
+	assert.Equal(t, []*Org{{Id: "t1", Name: "red"}, {Id: "t2", Name: "blue"}}, widgets[0].Tags)
+
+	// scoped queries only include the organization's tags, but still the organization itself
+	s.database.MyCursor.Index = 0
+	orgs.NoOrganization = true
+	_, err = s.collection.Query().WithinOrg("o1").List().
+		Include("organization_id", orgs, "organization").Include("tag_ids", tags, "tags").All(context.TODO())
+	assert.Nil(t, err)
+	q = utils.StripExtraWS(s.database.LastQuery)
+	assert.Contains(t, q, "FOR include_0_doc IN @@var_1 FILTER include_0_doc._key IN include_0_keys SORT")
+	assert.Contains(t, q, "FOR include_1_doc IN @@var_2 FILTER include_1_doc._key IN include_1_keys FILTER include_1_doc.organization_id == @var_0 SORT")
+	assert.Equal(t, "o1", s.database.LastBindVars["var_0"])
+}
+
+func (s *OrmTests) SubTestSearchView(t *testing.T) {
+	view := &SearchView{
+		Name:       "foo_view",
+		Collection: s.collection,
+		Fields:     map[string][]string{"name": {"text_en"}, "b": nil},
+		Analyzers:  []driver.ArangoSearchAnalyzerDefinition{{Name: "text_en", Type: driver.ArangoSearchAnalyzerTypeText}},
+	}
+
+	assert.Nil(t, view.Initialize(context.TODO()))
+	assert.Equal(t, "text_en", s.database.EnsuredAnalyzers[0].Name)
+	created := s.database.MyViews["foo_view"]
+	assert.True(t, created.Created)
+	assert.Equal(t, []string{"text_en"}, created.Props.Links["foo"].Fields["name"].Analyzers)
+
+	// a second Initialize brings the existing view in line
+	created.Created = false
+	view.Fields = map[string][]string{"c": {"identity"}}
+	assert.Nil(t, view.Initialize(context.TODO()))
+	assert.False(t, s.database.MyViews["foo_view"].Created)
+	assert.Equal(t, []string{"c"}, mapKeys(s.database.MyViews["foo_view"].Props.Links["foo"].Fields))
+
+	objects, err := view.Search().Phrase("name", "suzie q", "text_en").Boost(2).
+		Tokens("b", "bank blank", "text_en").WithinOrg("8675309").ByRelevance().Limit(10).All(context.TODO())
+	assert.Nil(t, err)
+	s.assertBasicMockRecords(t, objects)
+
+	q := utils.StripExtraWS(s.database.LastQuery)
+	assert.Equal(t, "FOR doc IN @@collection "+
+		"SEARCH BOOST(ANALYZER(PHRASE(doc.name, @var_0), @var_1), @var_2) AND ANALYZER(doc.b IN TOKENS(@var_3, @var_1), @var_1) "+
+		"FILTER (doc.organization_id == @var_4) "+
+		"SORT BM25(doc) DESC "+
+		"LIMIT @var_5 "+
+		"RETURN doc", q)
+	assert.Equal(t, map[string]interface{}{
+		"@collection": "foo_view",
+		"var_0":       "suzie q",
+		"var_1":       "text_en",
+		"var_2":       float64(2),
+		"var_3":       "bank blank",
+		"var_4":       "8675309",
+		"var_5":       10,
+	}, s.database.LastBindVars)
+
+	// relevance isn't a stable order for page tokens
+	_, err = view.Search().Prefix("name", "Su").ByRelevance().After("").All(context.TODO())
+	assert.NotNil(t, err)
+}
+
+func mapKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func (s *OrmTests) SubTestGeo(t *testing.T) {
+	q := s.collection.Query().WithinOrg("8675309")
+	o := q.Operator()
+	area := encoding.NewGeoPolygon(encoding.NewGeoPoint(37, -123), encoding.NewGeoPoint(38, -123), encoding.NewGeoPoint(38, -122))
+	objects, err := q.Where(o.WithinRadius("location", 37.77, -122.41, 5000)).Where(o.InPolygon("location", area)).
+		List().SortByDistance("location", 37.77, -122.41).Limit(3).All(context.TODO())
+	assert.Nil(t, err)
+	s.assertBasicMockRecords(t, objects)
+
+	assert.Equal(t, "FOR doc IN @@collection "+
+		"FILTER (doc.organization_id == @var_0) "+
+		"FILTER (GEO_DISTANCE(doc.location, GEO_POINT(@var_1, @var_2)) <= @var_3) "+
+		"FILTER GEO_CONTAINS(@var_4, doc.location) "+
+		"SORT GEO_DISTANCE(doc.location, GEO_POINT(@var_1, @var_2)) ASC "+
+		"LIMIT @var_5 "+
+		"RETURN doc", utils.StripExtraWS(s.database.LastQuery))
+	assert.Equal(t, -122.41, s.database.LastBindVars["var_1"])
+	assert.Equal(t, 37.77, s.database.LastBindVars["var_2"])
+	assert.Equal(t, float64(5000), s.database.LastBindVars["var_3"])
+	assert.Equal(t, area, s.database.LastBindVars["var_4"])
+}
+
 // ------------------------------
 // Entry point for test suite
 // ------------------------------
 
+func (s *OrmTests) SubTestQueryInspection(t *testing.T) {
+	filter := s.collection.Query().WithinOrg("8675309").Filter("b", "bravo")
+	query, variables, err := filter.List().OrderBy("name").Asc().ToAQL()
+	assert.Nil(t, err)
+	assert.Equal(t, "FOR doc IN @@collection "+
+		"FILTER (doc.organization_id == @var_0) "+
+		"FILTER (doc.b == @var_1) "+
+		"SORT doc.name ASC "+
+		"RETURN doc", utils.StripExtraWS(query))
+	assert.Equal(t, map[string]interface{}{"@collection": "foo", "var_0": "8675309", "var_1": "bravo"}, variables)
+	assert.Equal(t, "", s.database.LastQuery) // nothing ran
+
+	// explaining talks to the api directly
+	_, err = filter.Explain(context.TODO())
+	assert.EqualError(t, err, "explaining queries needs a Connection with a Client")
+
+	// plans as arangodb describes them
+	var raw planJSON
+	assert.Nil(t, json.Unmarshal([]byte(`{
+		"nodes": [
+			{"type": "SingletonNode", "id": 1, "dependencies": [], "estimatedCost": 1, "estimatedNrItems": 1},
+			{"type": "IndexNode", "id": 2, "dependencies": [1], "estimatedCost": 4, "estimatedNrItems": 2, "collection": "foo",
+				"indexes": [{"id": "101", "name": "idx_org", "type": "persistent", "fields": ["organization_id"], "unique": false, "sparse": false}]},
+			{"type": "EnumerateCollectionNode", "id": 3, "dependencies": [2], "estimatedCost": 40, "estimatedNrItems": 20, "collection": "bar"},
+			{"type": "IndexNode", "id": 4, "dependencies": [3], "estimatedCost": 44, "estimatedNrItems": 20, "collection": "foo",
+				"indexes": [{"id": "101", "name": "idx_org", "type": "persistent", "fields": ["organization_id"], "unique": false, "sparse": false}]}
+		],
+		"rules": ["use-indexes"],
+		"collections": [{"name": "foo", "type": "read"}, {"name": "bar", "type": "read"}],
+		"estimatedCost": 44,
+		"estimatedNrItems": 20,
+		"isModificationQuery": false
+	}`), &raw))
+	plan := raw.plan()
+	assert.Equal(t, 4, len(plan.Nodes))
+	assert.Equal(t, []int{1}, plan.Nodes[1].Dependencies)
+	assert.Equal(t, []PlanIndex{{Id: "101", Name: "idx_org", Type: "persistent", Fields: []string{"organization_id"}}}, plan.IndexesUsed())
+	assert.True(t, plan.UsesIndex("idx_org"))
+	assert.False(t, plan.UsesIndex("idx_email"))
+	assert.Equal(t, []string{"bar"}, plan.FullCollectionScans())
+	assert.Equal(t, []string{"use-indexes"}, plan.Rules)
+	assert.Equal(t, float64(44), plan.EstimatedCost)
+}
+
+type logLine struct {
+	level  Level
+	msg    string
+	fields Fields
+}
+
+type recordingLogger struct {
+	lines []logLine
+}
+
+func (c *recordingLogger) Enabled(ctx context.Context, level Level) bool {
+	return true
+}
+
+func (c *recordingLogger) Log(ctx context.Context, level Level, msg string, fields Fields) {
+	c.lines = append(c.lines, logLine{level: level, msg: msg, fields: fields})
+}
+
+func (s *OrmTests) SubTestLogging(t *testing.T) {
+	// nothing is logged without a logger
+	_, err := s.collection.Query().Filter("name", "bob").List().All(context.TODO())
+	assert.Nil(t, err)
+
+	logger := &recordingLogger{}
+	s.collection.Connection.Logger = logger
+	s.database.MyCursor.Index = 0
+
+	_, err = s.collection.Query().WithinOrg("8675309").Filter("email", "bob@example.com").
+		Filter("password", "hunter2").Filter("name", "bob").Filter("note", "mail me at bob@example.com").
+		List().All(context.TODO())
+	assert.Nil(t, err)
+
+	assert.Equal(t, 1, len(logger.lines))
+	line := logger.lines[0]
+	assert.Equal(t, LevelDebug, line.level)
+	assert.Equal(t, "ORM query", line.msg)
+	assert.Equal(t, "foo", line.fields["collection"])
+	assert.Equal(t, OpQuery, line.fields["operation"])
+	assert.Equal(t, 3, line.fields["rows"])
+	assert.IsType(t, time.Duration(0), line.fields["duration"])
+	assert.Equal(t, "FOR doc IN @@collection FILTER (doc.organization_id == @var_0) FILTER (doc.email == @var_1) "+
+		"FILTER (doc.password == @var_2) FILTER (doc.name == @var_3) FILTER (doc.note == @var_4) RETURN doc", line.fields["query"])
+	assert.Equal(t, map[string]interface{}{
+		"@collection": "foo",
+		"var_0":       "8675309",
+		"var_1":       Redacted,
+		"var_2":       Redacted,
+		"var_3":       "bob",
+		"var_4":       Redacted,
+	}, line.fields["bind_vars"])
+
+	// the queries the ORM builds quote the fields of their objects
+	docs := s.database.MyCursor
+
+	logger.lines = nil
+	s.database.MyCursor = &utils.MockCursor{Items: []string{`{"key": "11", "inserted": true}`}}
+	_, _, err = s.collection.Query().Filter("reset_token", "abc123").Upsert(context.TODO(), &struct {
+		Name     string   `json:"name"`
+		Token    string   `json:"api_token"`
+		Contacts []string `json:"contacts"`
+	}{Name: "bob", Token: "abc", Contacts: []string{"555-1234", "bob@example.com"}}, map[string]interface{}{"password": "hunter2"})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(logger.lines))
+	bindVars := logger.lines[0].fields["bind_vars"].(map[string]interface{})
+	assert.Equal(t, Redacted, bindVars["var_0"])
+	// documents are redacted field by field
+	insert := bindVars["var_1"].(map[string]interface{})
+	assert.Equal(t, "bob", insert["name"])
+	assert.Equal(t, Redacted, insert["api_token"])
+	assert.Equal(t, Redacted, insert["reset_token"])
+	assert.Equal(t, []interface{}{"555-1234", Redacted}, insert["contacts"])
+	assert.Equal(t, map[string]interface{}{"password": Redacted}, bindVars["var_2"])
+
+	logger.lines = nil
+	s.database.MyCursor = &utils.MockCursor{Items: []string{`"11"`}}
+	_, err = s.collection.Query().Filter("name", "bob").UpdateAll(context.TODO(), map[string]interface{}{"password": "hunter2"})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(logger.lines))
+	assert.Equal(t, map[string]interface{}{
+		"@collection": "foo",
+		"var_0":       "bob",
+		"var_1":       Redacted,
+	}, logger.lines[0].fields["bind_vars"])
+
+	logger.lines = nil
+	s.database.MyCursor = &utils.MockCursor{Items: []string{`{"_key": "11", "name": "bob"}`}}
+	_, err = s.collection.Atomic("11").Set("auth.session_token", "xyz789").Set("name", "robert").One(context.TODO())
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(logger.lines))
+	assert.Equal(t, map[string]interface{}{
+		"@collection": "foo",
+		"var_0":       "11",
+		"var_1":       Redacted,
+		"var_2":       "robert",
+	}, logger.lines[0].fields["bind_vars"])
+
+	s.database.MyCursor = docs
+
+	// levels by operation, and operations can be silenced
+	logger.lines = nil
+	s.collection.Connection.LogLevels = map[string]Level{OpQuery: LevelOff, OpUpdate: LevelInfo}
+	s.database.MyCursor.Index = 0
+	_, err = s.collection.Query().List().All(context.TODO())
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(logger.lines))
+
+	// builder warnings
+	s.collection.Query().List().RandomOrder().OrderBy("name")
+	assert.Equal(t, []logLine{{level: LevelWarn, msg: "OrderBy: dropping old order for these items", fields: Fields{"collection": "foo"}}}, logger.lines)
+
+	// through slog
+	var buffer bytes.Buffer
+	s.collection.Connection.Logger = NewSlogLogger(slog.NewTextHandler(&buffer, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	s.collection.Connection.LogLevels = nil
+	s.database.MyCursor.Index = 0
+	_, err = s.collection.Query().List().All(context.TODO())
+	assert.Nil(t, err)
+	assert.Equal(t, "", buffer.String()) // queries log at debug
+
+	s.collection.Connection.LogLevels = map[string]Level{OpQuery: LevelInfo}
+	s.database.MyCursor.Index = 0
+	_, err = s.collection.Query().Filter("name", "bob").List().All(context.TODO())
+	assert.Nil(t, err)
+	assert.Contains(t, buffer.String(), `level=INFO msg="ORM query" bind_vars="map[@collection:foo var_0:bob]" collection=foo duration=`)
+	assert.Contains(t, buffer.String(), `operation=query query="FOR doc IN @@collection FILTER (doc.name == @var_0) RETURN doc" rows=3`)
+}
+
+func (s *OrmTests) SubTestTelemetry(t *testing.T) {
+	spans := tracetest.NewInMemoryExporter()
+	tracerProvider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(spans))
+	reader := sdkmetric.NewManualReader()
+	meterProvider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+
+	telemetry, err := NewTelemetry(tracerProvider, meterProvider)
+	assert.Nil(t, err)
+	s.collection.Connection.Telemetry = telemetry
+	s.database.MyCursor.Stats = &utils.MockStatistics{FullScans: 30, IndexScans: 3, FilteredOut: 27, Time: time.Millisecond}
+
+	_, err = s.collection.Query().Filter("email", "bob@example.com").List().All(context.TODO())
+	assert.Nil(t, err)
+	queryCtx := s.database.LastQueryCtx
+
+	s.database.QueryErrors = []error{errors.New("the database is down")}
+	_, err = s.collection.Query().Count(context.TODO())
+	assert.EqualError(t, err, "the database is down")
+
+	ended := spans.GetSpans()
+	assert.Equal(t, 2, len(ended))
+
+	span := ended[0]
+	assert.Equal(t, "query foo", span.Name)
+	// the driver gets the span's context, so its own spans are children of it
+	assert.Equal(t, span.SpanContext.SpanID(), trace.SpanContextFromContext(queryCtx).SpanID())
+	assert.Equal(t, trace.SpanKindClient, span.SpanKind)
+	assert.Equal(t, codes.Unset, span.Status.Code)
+	attributes := make(map[attribute.Key]attribute.Value)
+	for _, kv := range span.Attributes {
+		attributes[kv.Key] = kv.Value
+	}
+	assert.Equal(t, "arangodb", attributes["db.system"].AsString())
+	assert.Equal(t, "MockItyo", attributes["db.name"].AsString())
+	assert.Equal(t, "query", attributes["db.operation"].AsString())
+	assert.Equal(t, "foo", attributes[CollectionKey].AsString())
+	assert.Equal(t, "FOR doc IN @@collection FILTER (doc.email == @var_0) RETURN doc",
+		utils.StripExtraWS(attributes["db.statement"].AsString())) // placeholders, not values
+	assert.Equal(t, int64(3), attributes[RowsKey].AsInt64())
+	assert.Equal(t, int64(30), attributes[ScannedFullKey].AsInt64())
+	assert.Equal(t, int64(3), attributes[ScannedIndexKey].AsInt64())
+	assert.Equal(t, int64(27), attributes[FilteredKey].AsInt64())
+	assert.Equal(t, 0.001, attributes[ExecutionTimeKey].AsFloat64())
+
+	failed := ended[1]
+	assert.Equal(t, "count foo", failed.Name)
+	assert.Equal(t, codes.Error, failed.Status.Code)
+	assert.Equal(t, "the database is down", failed.Status.Description)
+	assert.Equal(t, 1, len(failed.Events)) // the recorded error
+
+	var metrics metricdata.ResourceMetrics
+	assert.Nil(t, reader.Collect(context.TODO(), &metrics))
+	assert.Equal(t, 1, len(metrics.ScopeMetrics))
+	byName := make(map[string]metricdata.Aggregation)
+	for _, m := range metrics.ScopeMetrics[0].Metrics {
+		byName[m.Name] = m.Data
+	}
+
+	durations := byName["db.client.operation.duration"].(metricdata.Histogram[float64]).DataPoints
+	assert.Equal(t, 2, len(durations))
+	for _, point := range durations {
+		assert.Equal(t, uint64(1), point.Count)
+		collection, _ := point.Attributes.Value(CollectionKey)
+		assert.Equal(t, "foo", collection.AsString())
+	}
+
+	errorCounts := byName["db.client.operation.errors"].(metricdata.Sum[int64]).DataPoints
+	assert.Equal(t, 1, len(errorCounts))
+	assert.Equal(t, int64(1), errorCounts[0].Value)
+	operation, _ := errorCounts[0].Attributes.Value("db.operation")
+	assert.Equal(t, "count", operation.AsString())
+}
+
+func (s *OrmTests) SubTestRetries(t *testing.T) {
+	waits := make([]time.Duration, 0)
+	sleep = func(ctx context.Context, d time.Duration) error {
+		waits = append(waits, d)
+		return nil
+	}
+	defer func() { sleep = defaultSleep }()
+
+	unavailable := driver.ArangoError{HasError: true, Code: 503, ErrorNum: driver.ErrClusterNotLeader}
+	writeConflict := driver.ArangoError{HasError: true, Code: 409, ErrorNum: driver.ErrArangoConflict}
+	revisionMismatch := driver.ArangoError{HasError: true, Code: 412, ErrorNum: driver.ErrArangoConflict}
+
+	assert.True(t, IsTransient(unavailable))
+	assert.True(t, IsTransient(writeConflict))
+	assert.True(t, IsTransient(fmt.Errorf("wrapped: %w", writeConflict)))
+	assert.False(t, IsTransient(revisionMismatch))
+	assert.False(t, IsTransient(driver.ArangoError{HasError: true, Code: 400, ErrorNum: 1501}))
+	assert.False(t, IsTransient(errors.New("nope")))
+
+	// nothing is retried without a policy
+	s.database.QueryErrors = []error{unavailable}
+	_, err := s.collection.Query().List().All(context.TODO())
+	assert.Equal(t, unavailable, err)
+
+	policy := &RetryPolicy{MaxAttempts: 3, InitialBackoff: 10 * time.Millisecond, MaxBackoff: 15 * time.Millisecond}
+	s.collection.Connection.RetryPolicy = policy
+
+	// reads are retried with growing backoffs
+	s.database.QueryErrors = []error{unavailable, writeConflict}
+	objects, err := s.collection.Query().List().All(context.TODO())
+	assert.Nil(t, err)
+	s.assertBasicMockRecords(t, objects)
+	assert.Equal(t, []time.Duration{10 * time.Millisecond, 15 * time.Millisecond}, waits)
+
+	// until they run out of attempts
+	s.database.QueryErrors = []error{unavailable, unavailable, unavailable, unavailable}
+	_, err = s.collection.Query().Count(context.TODO())
+	assert.Equal(t, unavailable, err)
+	assert.Equal(t, 1, len(s.database.QueryErrors))
+
+	// errors that would happen again aren't retried
+	s.database.QueryErrors = []error{revisionMismatch}
+	_, err = s.collection.Query().Count(context.TODO())
+	assert.Equal(t, revisionMismatch, err)
+
+	// writes only when asked to
+	s.database.QueryErrors = []error{writeConflict}
+	_, err = s.collection.Query().DeleteAll(context.TODO())
+	assert.Equal(t, writeConflict, err)
+
+	s.database.MyCursor.Index = s.database.MyCursor.Count() // nothing to delete
+	s.database.QueryErrors = []error{writeConflict}
+	_, err = s.collection.Query().DeleteAll(WithRetriedWrites(context.TODO()))
+	assert.Nil(t, err)
+
+	// not one by one inside transactions, the whole transaction is retried instead
+	s.database.MyCursor.Index = 0
+	attempts := 0
+	err = s.collection.Connection.RunInTransactionWithRetry(context.TODO(), nil, []string{"foo"}, func(ctx context.Context) error {
+		attempts++
+		if attempts == 1 {
+			s.database.QueryErrors = []error{writeConflict}
+		}
+		_, err := s.collection.Query().List().All(ctx)
+		return err
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, attempts)
+	assert.Equal(t, []driver.TransactionID{"tx1"}, s.database.Aborted)
+	assert.Equal(t, []driver.TransactionID{"tx2"}, s.database.Committed)
+
+	// jitter takes up to that fraction off each backoff
+	policy = &RetryPolicy{InitialBackoff: 100 * time.Millisecond, Multiplier: 3, Jitter: 0.5}
+	for i := 0; i < 20; i++ {
+		backoff := policy.backoff(2)
+		assert.True(t, backoff > 150*time.Millisecond && backoff <= 300*time.Millisecond, backoff)
+	}
+}
+
 func TestOrmByMocks(t *testing.T) {
 	gtest.RunSubTests(t, &OrmTests{})
 }
@@ -1,9 +1,18 @@
 package orm
 
-import "fmt"
+import (
+	"fmt"
+
+	"github.com/ridelabs/simply_arango/encoding"
+)
 
 type Operator struct {
 	variableFactory *VariableFactory
+	document        string // which variable the attributes refer to, defaults to DocumentName
+}
+
+func (c *Operator) attribute(name string) interface{} {
+	return &DocumentAttribute{name: name, document: c.document}
 }
 
 func (c *Operator) MakeVariableIfNative(input interface{}) Expression {
@@ -39,23 +48,23 @@ func (c *Operator) Not(expression interface{}) Expression {
 // ----------------------
 
 func (c *Operator) Equal(attribute string, right interface{}) Expression {
-	return &EqualityExpression{left: NewAttribute(attribute), operator: EqualityExpressionEqual, right: c.MakeVariableIfNative(right)}
+	return &EqualityExpression{left: c.attribute(attribute), operator: EqualityExpressionEqual, right: c.MakeVariableIfNative(right)}
 }
 
 func (c *Operator) LessThan(attribute string, right Expression) Expression {
-	return &EqualityExpression{left: NewAttribute(attribute), operator: EqualityExpressionLessThan, right: c.MakeVariableIfNative(right)}
+	return &EqualityExpression{left: c.attribute(attribute), operator: EqualityExpressionLessThan, right: c.MakeVariableIfNative(right)}
 }
 
 func (c *Operator) LessThanOrEqual(attribute string, right Expression) Expression {
-	return &EqualityExpression{left: NewAttribute(attribute), operator: EqualityExpressionLessThanOrEqualTo, right: c.MakeVariableIfNative(right)}
+	return &EqualityExpression{left: c.attribute(attribute), operator: EqualityExpressionLessThanOrEqualTo, right: c.MakeVariableIfNative(right)}
 }
 
 func (c *Operator) GreaterThan(attribute string, right Expression) Expression {
-	return &EqualityExpression{left: NewAttribute(attribute), operator: EqualityExpressionGreaterThan, right: c.MakeVariableIfNative(right)}
+	return &EqualityExpression{left: c.attribute(attribute), operator: EqualityExpressionGreaterThan, right: c.MakeVariableIfNative(right)}
 }
 
 func (c *Operator) GreaterThanOrEqual(attribute string, right Expression) Expression {
-	return &EqualityExpression{left: NewAttribute(attribute), operator: EqualityExpressionGreaterThanOrEqualTo, right: c.MakeVariableIfNative(right)}
+	return &EqualityExpression{left: c.attribute(attribute), operator: EqualityExpressionGreaterThanOrEqualTo, right: c.MakeVariableIfNative(right)}
 }
 
 // ----------------------
@@ -83,7 +92,7 @@ func (c *EpsilonExpression) String() string {
 
 func (c *Operator) IsNull(attribute string) Expression {
 	return &EpsilonExpression{
-		left:     NewAttribute(attribute),
+		left:     c.attribute(attribute),
 		operator: EpsilonEqual,
 		isNull:   true,
 	}
@@ -91,7 +100,7 @@ func (c *Operator) IsNull(attribute string) Expression {
 
 func (c *Operator) IsEmpty(attribute string) Expression {
 	return &EpsilonExpression{
-		left:     NewAttribute(attribute),
+		left:     c.attribute(attribute),
 		operator: EpsilonEqual,
 		isNull:   false,
 	}
@@ -99,7 +108,7 @@ func (c *Operator) IsEmpty(attribute string) Expression {
 
 func (c *Operator) IsNotNull(attribute string) Expression {
 	return &EpsilonExpression{
-		left:     NewAttribute(attribute),
+		left:     c.attribute(attribute),
 		operator: EpsilonNotEqual,
 		isNull:   true,
 	}
@@ -107,7 +116,7 @@ func (c *Operator) IsNotNull(attribute string) Expression {
 
 func (c *Operator) IsNotEmpty(attribute string) Expression {
 	return &EpsilonExpression{
-		left:     NewAttribute(attribute),
+		left:     c.attribute(attribute),
 		operator: EpsilonNotEqual,
 		isNull:   false,
 	}
@@ -119,21 +128,98 @@ func (c *Operator) IsNotEmpty(attribute string) Expression {
 
 func (c *Operator) EndsWith(attribute string, pattern string) Expression {
 	return &LikeExpression{
-		left:  NewAttribute(attribute),
+		left:  c.attribute(attribute),
 		right: c.variableFactory.MakeVariable(fmt.Sprintf("%%%s", pattern)),
 	}
 }
 
 func (c *Operator) StartsWith(attribute string, pattern string) Expression {
 	return &LikeExpression{
-		left:  NewAttribute(attribute),
+		left:  c.attribute(attribute),
 		right: c.variableFactory.MakeVariable(fmt.Sprintf("%s%%", pattern)),
 	}
 }
 
 func (c *Operator) Contains(attribute string, pattern string) Expression {
 	return &LikeExpression{
-		left:  NewAttribute(attribute),
+		left:  c.attribute(attribute),
 		right: c.variableFactory.MakeVariable(fmt.Sprintf("%%%s%%", pattern)),
 	}
 }
+
+// ----------------------
+// Geo operators, the attributes hold GeoJSON (see encoding.GeoPoint) or [longitude, latitude] pairs
+// ----------------------
+
+// Near is the distance in meters between the attribute and a point
+func (c *Operator) Near(attribute string, latitude, longitude float64) Expression {
+	return &GeoDistanceExpression{
+		left:      c.attribute(attribute),
+		longitude: c.variableFactory.MakeVariable(longitude),
+		latitude:  c.variableFactory.MakeVariable(latitude),
+	}
+}
+
+// WithinRadius matches attributes at most meters away from the point, it can use a geo index
+func (c *Operator) WithinRadius(attribute string, latitude, longitude, meters float64) Expression {
+	return &EqualityExpression{
+		left:     c.Near(attribute, latitude, longitude),
+		operator: EqualityExpressionLessThanOrEqualTo,
+		right:    c.variableFactory.MakeVariable(meters),
+	}
+}
+
+// InPolygon matches attributes that lie inside the polygon, it can use a geo index
+func (c *Operator) InPolygon(attribute string, polygon encoding.GeoPolygon) Expression {
+	return &GeoContainsExpression{
+		shape: c.variableFactory.MakeVariable(polygon),
+		right: c.attribute(attribute),
+	}
+}
+
+// ----------------------
+// Update expressions, values computed by the database (see UpdateAll)
+// ----------------------
+
+// Attribute is the value of an attribute of the document
+func (c *Operator) Attribute(name string) Expression {
+	return &DocumentAttribute{name: name, document: c.document}
+}
+
+// value binds plain values, ORM expressions are kept as they are
+func (c *Operator) value(input interface{}) Expression {
+	if isExpression(input) {
+		return input.(Expression)
+	}
+	return c.variableFactory.MakeVariable(input)
+}
+
+func (c *Operator) Add(left, right interface{}) Expression {
+	return &ArithmeticExpression{left: c.value(left), operator: "+", right: c.value(right)}
+}
+
+func (c *Operator) Subtract(left, right interface{}) Expression {
+	return &ArithmeticExpression{left: c.value(left), operator: "-", right: c.value(right)}
+}
+
+func (c *Operator) Multiply(left, right interface{}) Expression {
+	return &ArithmeticExpression{left: c.value(left), operator: "*", right: c.value(right)}
+}
+
+func (c *Operator) Divide(left, right interface{}) Expression {
+	return &ArithmeticExpression{left: c.value(left), operator: "/", right: c.value(right)}
+}
+
+// Call calls an AQL function, like Call("DATE_NOW"). The name goes in the query as is, never pass user input.
+func (c *Operator) Call(function string, arguments ...interface{}) Expression {
+	values := make([]Expression, len(arguments))
+	for i, argument := range arguments {
+		values[i] = c.value(argument)
+	}
+	return &FunctionExpression{name: function, arguments: values}
+}
+
+// Merge is the object attribute with the fields of value merged in
+func (c *Operator) Merge(attribute string, value interface{}) Expression {
+	return c.Call("MERGE", c.Attribute(attribute), value)
+}
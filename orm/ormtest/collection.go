@@ -0,0 +1,674 @@
+package ormtest
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/arangodb/go-driver"
+)
+
+// -------------------------------------
+// Collections keep their documents as normalized json values. Stored documents are
+// never changed in place, every write stores a new map, so handing them to queries
+// and snapshots without copying is safe.
+// -------------------------------------
+
+type Collection struct {
+	db        *Database
+	name      string
+	kind      driver.CollectionType
+	documents map[string]map[string]interface{}
+	keys      []string // in the order the documents were created
+	key       int64    // the last generated key
+	indexes   []*Index
+	index     int // the last index id
+	schema    *driver.CollectionSchemaOptions
+}
+
+func newCollection(db *Database, name string, kind driver.CollectionType) *Collection {
+	collection := &Collection{
+		db:        db,
+		name:      name,
+		kind:      kind,
+		documents: make(map[string]map[string]interface{}),
+	}
+
+	collection.indexes = append(collection.indexes, &Index{collection: collection, id: "0", name: "primary",
+		kind: driver.PrimaryIndex, fields: []string{"_key"}, unique: true})
+	if kind == driver.CollectionTypeEdge {
+		collection.indexes = append(collection.indexes, &Index{collection: collection, id: "1", name: "edge",
+			kind: driver.EdgeIndex, fields: []string{"_from", "_to"}})
+		collection.index = 1
+	}
+
+	return collection
+}
+
+// copy is a snapshot of the collection's contents
+func (c *Collection) copy() *Collection {
+	copied := *c
+	copied.documents = make(map[string]map[string]interface{}, len(c.documents))
+	for key, document := range c.documents {
+		copied.documents[key] = document
+	}
+	copied.keys = append([]string{}, c.keys...)
+	copied.indexes = append([]*Index{}, c.indexes...)
+	return &copied
+}
+
+func (c *Collection) ordered() []interface{} {
+	documents := make([]interface{}, len(c.keys))
+	for i, key := range c.keys {
+		documents[i] = c.documents[key]
+	}
+	return documents
+}
+
+func (c *Collection) find(search map[string]interface{}) map[string]interface{} {
+	for _, key := range c.keys {
+		if document := c.documents[key]; matches(document, search) {
+			return document
+		}
+	}
+	return nil
+}
+
+func documentNotFound() error {
+	return arangoError(404, 1202, "document not found")
+}
+
+func revisionConflict() error {
+	return arangoError(412, 1200, "conflict, _rev values do not match")
+}
+
+// checkRevision fails when the stored document isn't at revision rev, an empty rev matches anything
+func (c *Collection) checkRevision(key, rev string) error {
+	document, ok := c.documents[key]
+	if !ok {
+		return documentNotFound()
+	}
+	if rev != "" && document["_rev"] != rev {
+		return revisionConflict()
+	}
+	return nil
+}
+
+// ----------------
+// writes, the caller holds the database lock. tx is the stream transaction they belong
+// to (nil outside one), which remembers them so aborting it can undo them.
+// ----------------
+
+func (c *Collection) insert(tx *transaction, document map[string]interface{}) (map[string]interface{}, error) {
+	stored := clone(document).(map[string]interface{})
+
+	key, hasKey := stored["_key"]
+	if !hasKey || key == nil {
+		c.key++
+		key = strconv.FormatInt(c.key, 10)
+	}
+	keyString, ok := key.(string)
+	if !ok || keyString == "" || strings.Contains(keyString, "/") {
+		return nil, arangoError(400, 1221, "illegal document key")
+	}
+	if _, exists := c.documents[keyString]; exists {
+		return nil, arangoError(409, 1210, "unique constraint violated - in index primary of type primary over '_key'")
+	}
+
+	stored["_key"] = keyString
+	stored["_id"] = c.name + "/" + keyString
+	stored["_rev"] = c.db.nextRevision()
+	if err := c.validate(stored); err != nil {
+		return nil, err
+	}
+
+	tx.record(c, keyString, nil, -1)
+	c.documents[keyString] = stored
+	c.keys = append(c.keys, keyString)
+	return stored, nil
+}
+
+// update merges patch into the document, keepNull=false removes attributes patched to null,
+// mergeObjects=false replaces nested objects instead of merging them
+func (c *Collection) update(tx *transaction, key string, patch map[string]interface{}, keepNull, mergeObjects bool) (map[string]interface{}, map[string]interface{}, error) {
+	old, ok := c.documents[key]
+	if !ok {
+		return nil, nil, documentNotFound()
+	}
+
+	updated := patchObject(old, patch, keepNull, mergeObjects)
+	for _, system := range []string{"_key", "_id"} {
+		updated[system] = old[system]
+	}
+	updated["_rev"] = c.db.nextRevision()
+
+	if err := c.validate(updated); err != nil {
+		return nil, nil, err
+	}
+
+	tx.record(c, key, old, -1)
+	c.documents[key] = updated
+	return old, updated, nil
+}
+
+func patchObject(old, patch map[string]interface{}, keepNull, mergeObjects bool) map[string]interface{} {
+	updated := make(map[string]interface{}, len(old)+len(patch))
+	for name, value := range old {
+		updated[name] = value
+	}
+
+	for name, value := range patch {
+		if name == "_key" || name == "_id" || name == "_rev" {
+			continue
+		}
+		if value == nil && !keepNull {
+			delete(updated, name)
+			continue
+		}
+
+		existing, existingIsObject := updated[name].(map[string]interface{})
+		incoming, incomingIsObject := value.(map[string]interface{})
+		if mergeObjects && existingIsObject && incomingIsObject {
+			updated[name] = patchObject(existing, incoming, keepNull, mergeObjects)
+			continue
+		}
+		updated[name] = clone(value)
+	}
+
+	return updated
+}
+
+func (c *Collection) replace(tx *transaction, key string, document map[string]interface{}) (map[string]interface{}, map[string]interface{}, error) {
+	old, ok := c.documents[key]
+	if !ok {
+		return nil, nil, documentNotFound()
+	}
+
+	replaced := clone(document).(map[string]interface{})
+	replaced["_key"] = old["_key"]
+	replaced["_id"] = old["_id"]
+	replaced["_rev"] = c.db.nextRevision()
+
+	if err := c.validate(replaced); err != nil {
+		return nil, nil, err
+	}
+
+	tx.record(c, key, old, -1)
+	c.documents[key] = replaced
+	return old, replaced, nil
+}
+
+func (c *Collection) remove(tx *transaction, key string) (map[string]interface{}, error) {
+	old, ok := c.documents[key]
+	if !ok {
+		return nil, documentNotFound()
+	}
+
+	delete(c.documents, key)
+	keys := make([]string, 0, len(c.keys))
+	position := 0
+	for i, existing := range c.keys {
+		if existing != key {
+			keys = append(keys, existing)
+		} else {
+			position = i
+		}
+	}
+	c.keys = keys
+	tx.record(c, key, old, position)
+	return old, nil
+}
+
+// validate checks the schema, that edges have their handles and that unique indexes aren't violated
+func (c *Collection) validate(document map[string]interface{}) error {
+	if err := c.checkSchema(document); err != nil {
+		return err
+	}
+
+	if c.kind == driver.CollectionTypeEdge {
+		for _, name := range []string{"_from", "_to"} {
+			handle, ok := document[name].(string)
+			if !ok || !strings.Contains(handle, "/") {
+				return arangoError(400, 1233, "edge attribute missing or invalid")
+			}
+		}
+	}
+
+	for _, index := range c.indexes {
+		if !index.unique || index.kind == driver.PrimaryIndex {
+			continue
+		}
+		values, indexed := index.values(document)
+		if !indexed {
+			continue
+		}
+		for _, key := range c.keys {
+			other := c.documents[key]
+			if other["_key"] == document["_key"] {
+				continue
+			}
+			if otherValues, ok := index.values(other); ok && equalValues(values, otherValues) {
+				return arangoError(409, 1210, "unique constraint violated - in index %s of type %s over '%s'",
+					index.name, index.kind, strings.Join(index.fields, ", "))
+			}
+		}
+	}
+
+	return nil
+}
+
+// ----------------
+// driver.Collection
+// ----------------
+
+func (c *Collection) Name() string {
+	return c.name
+}
+
+func (c *Collection) Database() driver.Database {
+	return c.db
+}
+
+func (c *Collection) Count(ctx context.Context) (int64, error) {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+
+	return int64(len(c.keys)), nil
+}
+
+func (c *Collection) Remove(ctx context.Context) error {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+
+	if c.db.collections[c.name] != c {
+		return collectionNotFound(c.name)
+	}
+	delete(c.db.collections, c.name)
+	return nil
+}
+
+func (c *Collection) Truncate(ctx context.Context) error {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+
+	tx, err := c.db.transactionOf(ctx)
+	if err != nil {
+		return err
+	}
+	for i := len(c.keys) - 1; i >= 0; i-- {
+		tx.record(c, c.keys[i], c.documents[c.keys[i]], i)
+	}
+
+	c.documents = make(map[string]map[string]interface{})
+	c.keys = nil
+	return nil
+}
+
+func (c *Collection) Status(ctx context.Context) (driver.CollectionStatus, error) {
+	return driver.CollectionStatusLoaded, nil
+}
+
+func (c *Collection) Load(ctx context.Context) error {
+	return nil
+}
+
+func (c *Collection) Unload(ctx context.Context) error {
+	return nil
+}
+
+func (c *Collection) Revision(ctx context.Context) (string, error) {
+	return "", notSupported("Revision")
+}
+
+func (c *Collection) Statistics(ctx context.Context) (driver.CollectionStatistics, error) {
+	return driver.CollectionStatistics{}, notSupported("Statistics")
+}
+
+func (c *Collection) Shards(ctx context.Context, details bool) (driver.CollectionShards, error) {
+	return driver.CollectionShards{}, notSupported("Shards")
+}
+
+func (c *Collection) ImportDocuments(ctx context.Context, documents interface{}, options *driver.ImportDocumentOptions) (driver.ImportDocumentStatistics, error) {
+	return driver.ImportDocumentStatistics{}, notSupported("ImportDocuments")
+}
+
+// ----------------
+// documents
+// ----------------
+
+// requestOptions are the document api options the driver passes in the context
+type requestOptions struct {
+	tx         *transaction
+	revision   string
+	revisions  []string
+	ignoreRevs bool
+	keepNull   bool
+	merge      bool
+	returnNew  interface{}
+	returnOld  interface{}
+}
+
+func optionsOf(ctx context.Context) requestOptions {
+	options := requestOptions{ignoreRevs: true, keepNull: true, merge: true}
+	if ctx == nil {
+		return options
+	}
+
+	options.revision, _ = ctx.Value(driver.ContextKey("arangodb-revision")).(string)
+	options.revisions, _ = ctx.Value(driver.ContextKey("arangodb-revisions")).([]string)
+	if ignore, ok := ctx.Value(driver.ContextKey("arangodb-ignoreRevs")).(bool); ok {
+		options.ignoreRevs = ignore
+	}
+	if keepNull, ok := ctx.Value(driver.ContextKey("arangodb-keepNull")).(bool); ok {
+		options.keepNull = keepNull
+	}
+	if merge, ok := ctx.Value(driver.ContextKey("arangodb-mergeObjects")).(bool); ok {
+		options.merge = merge
+	}
+	options.returnNew = ctx.Value(driver.ContextKey("arangodb-returnNew"))
+	options.returnOld = ctx.Value(driver.ContextKey("arangodb-returnOld"))
+	return options
+}
+
+// requestOptions are the options of a request, with the stream transaction it's part of.
+// The caller holds the database lock.
+func (c *Collection) requestOptions(ctx context.Context) (requestOptions, error) {
+	options := optionsOf(ctx)
+	tx, err := c.db.transactionOf(ctx)
+	options.tx = tx
+	return options, err
+}
+
+// revisionFor is the revision the i-th document must have: If-Match for single documents,
+// the revisions list, or the _rev in the document itself when revisions aren't ignored
+func (c requestOptions) revisionFor(i int, document map[string]interface{}) string {
+	if c.revisions != nil {
+		if i < len(c.revisions) {
+			return c.revisions[i]
+		}
+		return ""
+	}
+	if c.revision != "" {
+		return c.revision
+	}
+	if !c.ignoreRevs && document != nil {
+		rev, _ := document["_rev"].(string)
+		return rev
+	}
+	return ""
+}
+
+func convert(in interface{}, out interface{}) error {
+	if out == nil {
+		return nil
+	}
+	data, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
+
+func metaOf(document map[string]interface{}) driver.DocumentMeta {
+	meta := driver.DocumentMeta{}
+	meta.Key, _ = document["_key"].(string)
+	id, _ := document["_id"].(string)
+	meta.ID = driver.DocumentID(id)
+	meta.Rev, _ = document["_rev"].(string)
+	return meta
+}
+
+// object normalizes a document given to the api
+func object(document interface{}) (map[string]interface{}, error) {
+	normalized, err := normalize(document)
+	if err != nil {
+		return nil, err
+	}
+	object, ok := normalized.(map[string]interface{})
+	if !ok {
+		return nil, arangoError(400, 1227, "invalid document type")
+	}
+	return object, nil
+}
+
+// elements are the items of a slice given to the plural document functions
+func elements(slice interface{}) ([]interface{}, error) {
+	value := reflect.ValueOf(slice)
+	if value.Kind() != reflect.Slice && value.Kind() != reflect.Array {
+		return nil, driver.InvalidArgumentError{Message: "documents must be a slice"}
+	}
+
+	items := make([]interface{}, value.Len())
+	for i := range items {
+		items[i] = value.Index(i).Interface()
+	}
+	return items, nil
+}
+
+func (c *Collection) DocumentExists(ctx context.Context, key string) (bool, error) {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+
+	_, exists := c.documents[key]
+	return exists, nil
+}
+
+func (c *Collection) readDocument(ctx context.Context, key string, result interface{}) (driver.DocumentMeta, error) {
+	document, ok := c.documents[key]
+	if !ok {
+		return driver.DocumentMeta{}, documentNotFound()
+	}
+	if rev := optionsOf(ctx).revision; rev != "" && document["_rev"] != rev {
+		return driver.DocumentMeta{}, revisionConflict()
+	}
+	return metaOf(document), convert(document, result)
+}
+
+func (c *Collection) ReadDocument(ctx context.Context, key string, result interface{}) (driver.DocumentMeta, error) {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+
+	return c.readDocument(ctx, key, result)
+}
+
+func (c *Collection) ReadDocuments(ctx context.Context, keys []string, results interface{}) (driver.DocumentMetaSlice, driver.ErrorSlice, error) {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+
+	value := reflect.ValueOf(results)
+	if value.Kind() != reflect.Slice || value.Len() != len(keys) {
+		return nil, nil, driver.InvalidArgumentError{Message: "results must be a slice with an element per key"}
+	}
+
+	metas := make(driver.DocumentMetaSlice, len(keys))
+	errs := make(driver.ErrorSlice, len(keys))
+	for i, key := range keys {
+		metas[i], errs[i] = c.readDocument(ctx, key, value.Index(i).Addr().Interface())
+	}
+	return metas, errs, nil
+}
+
+func (c *Collection) createDocument(options requestOptions, document interface{}) (driver.DocumentMeta, error) {
+	doc, err := object(document)
+	if err != nil {
+		return driver.DocumentMeta{}, err
+	}
+	delete(doc, "_rev")
+
+	stored, err := c.insert(options.tx, doc)
+	if err != nil {
+		return driver.DocumentMeta{}, err
+	}
+	return metaOf(stored), nil
+}
+
+func (c *Collection) CreateDocument(ctx context.Context, document interface{}) (driver.DocumentMeta, error) {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+
+	options, err := c.requestOptions(ctx)
+	if err != nil {
+		return driver.DocumentMeta{}, err
+	}
+	meta, err := c.createDocument(options, document)
+	if err != nil {
+		return meta, err
+	}
+	return meta, convert(c.documents[meta.Key], options.returnNew)
+}
+
+func (c *Collection) CreateDocuments(ctx context.Context, documents interface{}) (driver.DocumentMetaSlice, driver.ErrorSlice, error) {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+
+	items, err := elements(documents)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	options, err := c.requestOptions(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	metas := make(driver.DocumentMetaSlice, len(items))
+	errs := make(driver.ErrorSlice, len(items))
+	for i, item := range items {
+		metas[i], errs[i] = c.createDocument(options, item)
+	}
+	return metas, errs, nil
+}
+
+// write is what UpdateDocument and ReplaceDocument have in common
+func (c *Collection) write(options requestOptions, i int, key string, document interface{}, replace bool) (driver.DocumentMeta, map[string]interface{}, error) {
+	doc, err := object(document)
+	if err != nil {
+		return driver.DocumentMeta{}, nil, err
+	}
+	if key == "" {
+		key, _ = doc["_key"].(string)
+	}
+	if err := c.checkRevision(key, options.revisionFor(i, doc)); err != nil {
+		return driver.DocumentMeta{}, nil, err
+	}
+
+	var old, updated map[string]interface{}
+	if replace {
+		old, updated, err = c.replace(options.tx, key, doc)
+	} else {
+		old, updated, err = c.update(options.tx, key, doc, options.keepNull, options.merge)
+	}
+	if err != nil {
+		return driver.DocumentMeta{}, nil, err
+	}
+
+	meta := metaOf(updated)
+	meta.OldRev, _ = old["_rev"].(string)
+	return meta, old, nil
+}
+
+func (c *Collection) writeDocument(ctx context.Context, key string, document interface{}, replace bool) (driver.DocumentMeta, error) {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+
+	options, err := c.requestOptions(ctx)
+	if err != nil {
+		return driver.DocumentMeta{}, err
+	}
+	meta, old, err := c.write(options, 0, key, document, replace)
+	if err != nil {
+		return meta, err
+	}
+	if err := convert(old, options.returnOld); err != nil {
+		return meta, err
+	}
+	return meta, convert(c.documents[meta.Key], options.returnNew)
+}
+
+func (c *Collection) writeDocuments(ctx context.Context, keys []string, documents interface{}, replace bool) (driver.DocumentMetaSlice, driver.ErrorSlice, error) {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+
+	items, err := elements(documents)
+	if err != nil {
+		return nil, nil, err
+	}
+	if keys != nil && len(keys) != len(items) {
+		return nil, nil, driver.InvalidArgumentError{Message: "there must be a key per document"}
+	}
+
+	options, err := c.requestOptions(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	metas := make(driver.DocumentMetaSlice, len(items))
+	errs := make(driver.ErrorSlice, len(items))
+	for i, item := range items {
+		key := ""
+		if keys != nil {
+			key = keys[i]
+		}
+		metas[i], _, errs[i] = c.write(options, i, key, item, replace)
+	}
+	return metas, errs, nil
+}
+
+func (c *Collection) UpdateDocument(ctx context.Context, key string, update interface{}) (driver.DocumentMeta, error) {
+	return c.writeDocument(ctx, key, update, false)
+}
+
+func (c *Collection) UpdateDocuments(ctx context.Context, keys []string, updates interface{}) (driver.DocumentMetaSlice, driver.ErrorSlice, error) {
+	return c.writeDocuments(ctx, keys, updates, false)
+}
+
+func (c *Collection) ReplaceDocument(ctx context.Context, key string, document interface{}) (driver.DocumentMeta, error) {
+	return c.writeDocument(ctx, key, document, true)
+}
+
+func (c *Collection) ReplaceDocuments(ctx context.Context, keys []string, documents interface{}) (driver.DocumentMetaSlice, driver.ErrorSlice, error) {
+	return c.writeDocuments(ctx, keys, documents, true)
+}
+
+func (c *Collection) removeDocument(options requestOptions, i int, key string) (driver.DocumentMeta, map[string]interface{}, error) {
+	if err := c.checkRevision(key, options.revisionFor(i, nil)); err != nil {
+		return driver.DocumentMeta{}, nil, err
+	}
+
+	old, err := c.remove(options.tx, key)
+	if err != nil {
+		return driver.DocumentMeta{}, nil, err
+	}
+	return metaOf(old), old, nil
+}
+
+func (c *Collection) RemoveDocument(ctx context.Context, key string) (driver.DocumentMeta, error) {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+
+	options, err := c.requestOptions(ctx)
+	if err != nil {
+		return driver.DocumentMeta{}, err
+	}
+	meta, old, err := c.removeDocument(options, 0, key)
+	if err != nil {
+		return meta, err
+	}
+	return meta, convert(old, options.returnOld)
+}
+
+func (c *Collection) RemoveDocuments(ctx context.Context, keys []string) (driver.DocumentMetaSlice, driver.ErrorSlice, error) {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+
+	options, err := c.requestOptions(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	metas := make(driver.DocumentMetaSlice, len(keys))
+	errs := make(driver.ErrorSlice, len(keys))
+	for i, key := range keys {
+		metas[i], _, errs[i] = c.removeDocument(options, i, key)
+	}
+	return metas, errs, nil
+}
@@ -0,0 +1,50 @@
+package ormtest
+
+import (
+	"context"
+
+	"github.com/arangodb/go-driver"
+)
+
+// Cursor hands out the results of a query, which are all computed up front
+type Cursor struct {
+	results []interface{}
+	index   int
+	closed  bool
+}
+
+func (c *Cursor) HasMore() bool {
+	return !c.closed && c.index < len(c.results)
+}
+
+func (c *Cursor) ReadDocument(ctx context.Context, result interface{}) (driver.DocumentMeta, error) {
+	if !c.HasMore() {
+		return driver.DocumentMeta{}, driver.NoMoreDocumentsError{}
+	}
+
+	item := c.results[c.index]
+	c.index++
+
+	meta := driver.DocumentMeta{}
+	if document, ok := item.(map[string]interface{}); ok {
+		meta = metaOf(document)
+	}
+	return meta, convert(item, result)
+}
+
+func (c *Cursor) Count() int64 {
+	return int64(len(c.results))
+}
+
+func (c *Cursor) Close() error {
+	c.closed = true
+	return nil
+}
+
+func (c *Cursor) Statistics() driver.QueryStatistics {
+	return nil
+}
+
+func (c *Cursor) Extra() driver.QueryExtra {
+	return nil
+}
@@ -0,0 +1,464 @@
+package ormtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/arangodb/go-driver"
+	"github.com/ridelabs/simply_arango/orm"
+)
+
+// -------------------------------------
+// An in-memory driver.Database for unit tests. It keeps documents per collection and
+// interprets the subset of AQL the ORM generates (FOR, FILTER, SORT, LIMIT, LET,
+// COLLECT, INSERT, UPDATE, REPLACE, REMOVE, UPSERT and RETURN, plus graph traversals),
+// so code built on the ORM can be tested against behavior instead of query strings:
+//
+//	conn := ormtest.NewConnection()
+//	users := &orm.Collection{Connection: conn, TableName: "users", AllocateRecord: newUser}
+//	users.Initialize(ctx)
+//	users.Create(ctx, &User{Name: "bob", OrganizationId: "8675309"})
+//	found, err := users.Query().WithinOrg("8675309").Filter("name", "bob").List().All(ctx)
+//
+// Methods it doesn't fake (views, graphs, analyzers, ...) fail with ErrNotSupported.
+// Stream transactions aren't isolated, every change is visible right away, but aborting
+// one undoes the writes made with its transaction ID in the context.
+// -------------------------------------
+
+// ErrNotSupported is what the driver methods ormtest doesn't fake fail with
+var ErrNotSupported = errors.New("not supported by ormtest")
+
+var (
+	_ driver.Database   = (*Database)(nil)
+	_ driver.Collection = (*Collection)(nil)
+	_ driver.Cursor     = (*Cursor)(nil)
+)
+
+type Database struct {
+	name string
+
+	mu           sync.Mutex
+	collections  map[string]*Collection
+	revision     int64
+	transactions map[driver.TransactionID]*transaction
+	transaction  int
+}
+
+type transaction struct {
+	status  driver.TransactionStatus
+	changes []change // the writes made in the transaction, to undo them on abort
+}
+
+// change is one write to a document: old is the document before it (nil if it was inserted),
+// position is where a removed document was in the collection's keys
+type change struct {
+	collection *Collection
+	key        string
+	old        map[string]interface{}
+	position   int
+}
+
+// record remembers a write the transaction made, nothing to do outside a transaction
+func (c *transaction) record(collection *Collection, key string, old map[string]interface{}, position int) {
+	if c == nil {
+		return
+	}
+	c.changes = append(c.changes, change{collection: collection, key: key, old: old, position: position})
+}
+
+// undo takes back the writes made in the transaction since it had n changes, newest first
+func (c *transaction) undo(n int) {
+	for i := len(c.changes) - 1; i >= n; i-- {
+		change := c.changes[i]
+		collection := change.collection
+		_, exists := collection.documents[change.key]
+		switch {
+		case change.old == nil:
+			delete(collection.documents, change.key)
+			collection.keys = without(collection.keys, change.key)
+		case !exists:
+			collection.documents[change.key] = change.old
+			position := change.position
+			if position > len(collection.keys) {
+				position = len(collection.keys)
+			}
+			collection.keys = append(collection.keys[:position], append([]string{change.key}, collection.keys[position:]...)...)
+		default:
+			collection.documents[change.key] = change.old
+		}
+	}
+	c.changes = c.changes[:n]
+}
+
+func without(keys []string, key string) []string {
+	kept := make([]string, 0, len(keys))
+	for _, existing := range keys {
+		if existing != key {
+			kept = append(kept, existing)
+		}
+	}
+	return kept
+}
+
+func NewDatabase(name string) *Database {
+	return &Database{
+		name:         name,
+		collections:  make(map[string]*Collection),
+		transactions: make(map[driver.TransactionID]*transaction),
+	}
+}
+
+// NewConnection is an orm.Connection to a new, empty, in-memory database
+func NewConnection() *orm.Connection {
+	return &orm.Connection{Database: NewDatabase("ormtest")}
+}
+
+// arangoError makes errors that look like the server's, so driver.IsNotFound etc. work on them
+func arangoError(code, errorNum int, format string, args ...interface{}) error {
+	return driver.ArangoError{HasError: true, Code: code, ErrorNum: errorNum, ErrorMessage: fmt.Sprintf(format, args...)}
+}
+
+func queryError(errorNum int, format string, args ...interface{}) error {
+	return arangoError(400, errorNum, format, args...)
+}
+
+func collectionNotFound(name string) error {
+	return arangoError(404, 1203, "collection or view not found: %s", name)
+}
+
+func (c *Database) nextRevision() string {
+	c.revision++
+	return "_" + strconv.FormatInt(c.revision, 36)
+}
+
+// collection looks a collection up, the caller holds the lock
+func (c *Database) collection(name string) (*Collection, error) {
+	collection, ok := c.collections[name]
+	if !ok {
+		return nil, collectionNotFound(name)
+	}
+	return collection, nil
+}
+
+// documents are the documents of a collection in the order they were created
+func (c *Database) documents(name string) ([]interface{}, error) {
+	collection, err := c.collection(name)
+	if err != nil {
+		return nil, err
+	}
+	return collection.ordered(), nil
+}
+
+// vertex finds a document by its id (collection/key), nil if there's no such document
+func (c *Database) vertex(id string) map[string]interface{} {
+	name, key, found := strings.Cut(id, "/")
+	if !found {
+		return nil
+	}
+	collection, ok := c.collections[name]
+	if !ok {
+		return nil
+	}
+	return collection.documents[key]
+}
+
+// ----------------
+// driver.Database
+// ----------------
+
+func (c *Database) Name() string {
+	return c.name
+}
+
+func (c *Database) Collection(ctx context.Context, name string) (driver.Collection, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.collection(name)
+}
+
+func (c *Database) CollectionExists(ctx context.Context, name string) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	_, exists := c.collections[name]
+	return exists, nil
+}
+
+func (c *Database) Collections(ctx context.Context) ([]driver.Collection, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	names := make([]string, 0, len(c.collections))
+	for name := range c.collections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	collections := make([]driver.Collection, len(names))
+	for i, name := range names {
+		collections[i] = c.collections[name]
+	}
+	return collections, nil
+}
+
+func (c *Database) CreateCollection(ctx context.Context, name string, options *driver.CreateCollectionOptions) (driver.Collection, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, exists := c.collections[name]; exists {
+		return nil, arangoError(409, 1207, "duplicate name: %s", name)
+	}
+
+	kind := driver.CollectionTypeDocument
+	if options != nil && options.Type == driver.CollectionTypeEdge {
+		kind = driver.CollectionTypeEdge
+	}
+
+	collection := newCollection(c, name, kind)
+	if options != nil && options.Schema != nil {
+		schema, err := normalizeSchema(options.Schema)
+		if err != nil {
+			return nil, err
+		}
+		collection.schema = schema
+	}
+	c.collections[name] = collection
+	return collection, nil
+}
+
+func (c *Database) Query(ctx context.Context, query string, bindVars map[string]interface{}) (driver.Cursor, error) {
+	parsed, err := parse(query)
+	if err != nil {
+		return nil, queryError(1501, "syntax error, %s", err)
+	}
+
+	normalized := make(map[string]interface{}, len(bindVars))
+	for name, value := range bindVars {
+		if normalized[name], err = normalize(value); err != nil {
+			return nil, queryError(1552, "bind parameter '%s': %s", name, err)
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	tx, err := c.transactionOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// queries are atomic, a failing one doesn't leave half its changes behind
+	var snapshot map[string]*Collection
+	var changes int
+	if parsed.modifies {
+		snapshot = c.snapshot()
+		if tx != nil {
+			changes = len(tx.changes)
+		}
+	}
+
+	results, err := parsed.run(&execution{ctx: ctx, db: c, tx: tx, bindVars: normalized}, nil)
+	if err != nil {
+		if snapshot != nil {
+			c.restore(snapshot)
+			if tx != nil {
+				tx.changes = tx.changes[:changes]
+			}
+		}
+		return nil, err
+	}
+
+	return &Cursor{results: clone(results).([]interface{})}, nil
+}
+
+func (c *Database) ValidateQuery(ctx context.Context, query string) error {
+	if _, err := parse(query); err != nil {
+		return queryError(1501, "syntax error, %s", err)
+	}
+	return nil
+}
+
+// ----------------
+// stream transactions
+// ----------------
+
+func (c *Database) snapshot() map[string]*Collection {
+	snapshot := make(map[string]*Collection, len(c.collections))
+	for name, collection := range c.collections {
+		snapshot[name] = collection.copy()
+	}
+	return snapshot
+}
+
+// restore puts the collections back the way they were, keeping the Collection handles people hold valid
+func (c *Database) restore(snapshot map[string]*Collection) {
+	for name, collection := range c.collections {
+		if saved, ok := snapshot[name]; ok {
+			collection.documents, collection.keys, collection.indexes = saved.documents, saved.keys, saved.indexes
+			collection.key = saved.key
+		} else {
+			delete(c.collections, name)
+		}
+	}
+	for name, saved := range snapshot {
+		if _, ok := c.collections[name]; !ok {
+			c.collections[name] = saved
+		}
+	}
+}
+
+func (c *Database) BeginTransaction(ctx context.Context, cols driver.TransactionCollections, opts *driver.BeginTransactionOptions) (driver.TransactionID, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, name := range append(append(append([]string{}, cols.Read...), cols.Write...), cols.Exclusive...) {
+		if _, exists := c.collections[name]; !exists {
+			return "", collectionNotFound(name)
+		}
+	}
+
+	c.transaction++
+	tid := driver.TransactionID(strconv.Itoa(c.transaction))
+	c.transactions[tid] = &transaction{status: driver.TransactionRunning}
+	return tid, nil
+}
+
+// transactionOf is the running transaction whose ID is in the context, nil if there isn't one.
+// The caller holds the lock.
+func (c *Database) transactionOf(ctx context.Context) (*transaction, error) {
+	if ctx == nil {
+		return nil, nil
+	}
+	tid, ok := ctx.Value(driver.ContextKey("arangodb-transactionID")).(driver.TransactionID)
+	if !ok || tid == "" {
+		return nil, nil
+	}
+	return c.runningTransaction(tid)
+}
+
+func (c *Database) runningTransaction(tid driver.TransactionID) (*transaction, error) {
+	tx, ok := c.transactions[tid]
+	if !ok {
+		return nil, arangoError(404, 1655, "transaction '%s' not found", tid)
+	}
+	if tx.status != driver.TransactionRunning {
+		return nil, arangoError(400, 1653, "transaction '%s' is %s", tid, tx.status)
+	}
+	return tx, nil
+}
+
+func (c *Database) CommitTransaction(ctx context.Context, tid driver.TransactionID, opts *driver.CommitTransactionOptions) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	tx, err := c.runningTransaction(tid)
+	if err != nil {
+		return err
+	}
+	tx.status, tx.changes = driver.TransactionCommitted, nil
+	return nil
+}
+
+func (c *Database) AbortTransaction(ctx context.Context, tid driver.TransactionID, opts *driver.AbortTransactionOptions) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	tx, err := c.runningTransaction(tid)
+	if err != nil {
+		return err
+	}
+	tx.undo(0)
+	tx.status = driver.TransactionAborted
+	return nil
+}
+
+func (c *Database) TransactionStatus(ctx context.Context, tid driver.TransactionID) (driver.TransactionStatusRecord, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	tx, ok := c.transactions[tid]
+	if !ok {
+		return driver.TransactionStatusRecord{}, arangoError(404, 1655, "transaction '%s' not found", tid)
+	}
+	return driver.TransactionStatusRecord{Status: tx.status}, nil
+}
+
+// ----------------
+// what ormtest doesn't fake: there are no views, graphs or analyzers, creating them
+// and the rest fail with ErrNotSupported
+// ----------------
+
+func notSupported(method string) error {
+	return fmt.Errorf("%s: %w", method, ErrNotSupported)
+}
+
+func (c *Database) Info(ctx context.Context) (driver.DatabaseInfo, error) {
+	return driver.DatabaseInfo{ID: c.name, Name: c.name}, nil
+}
+
+func (c *Database) EngineInfo(ctx context.Context) (driver.EngineInfo, error) {
+	return driver.EngineInfo{Type: driver.EngineTypeRocksDB}, nil
+}
+
+func (c *Database) Remove(ctx context.Context) error {
+	return notSupported("Remove")
+}
+
+func (c *Database) Transaction(ctx context.Context, action string, options *driver.TransactionOptions) (interface{}, error) {
+	return nil, notSupported("Transaction")
+}
+
+// ViewExists is false for every view, ormtest doesn't do ArangoSearch
+func (c *Database) ViewExists(ctx context.Context, name string) (bool, error) {
+	return false, nil
+}
+
+func (c *Database) View(ctx context.Context, name string) (driver.View, error) {
+	return nil, arangoError(404, 1203, "collection or view not found: %s", name)
+}
+
+func (c *Database) Views(ctx context.Context) ([]driver.View, error) {
+	return nil, nil
+}
+
+func (c *Database) CreateArangoSearchView(ctx context.Context, name string, options *driver.ArangoSearchViewProperties) (driver.ArangoSearchView, error) {
+	return nil, notSupported("CreateArangoSearchView")
+}
+
+func (c *Database) GraphExists(ctx context.Context, name string) (bool, error) {
+	return false, nil
+}
+
+func (c *Database) Graph(ctx context.Context, name string) (driver.Graph, error) {
+	return nil, arangoError(404, 1924, "graph '%s' not found", name)
+}
+
+func (c *Database) Graphs(ctx context.Context) ([]driver.Graph, error) {
+	return nil, nil
+}
+
+func (c *Database) CreateGraph(ctx context.Context, name string, options *driver.CreateGraphOptions) (driver.Graph, error) {
+	return nil, notSupported("CreateGraph")
+}
+
+func (c *Database) CreateGraphV2(ctx context.Context, name string, options *driver.CreateGraphOptions) (driver.Graph, error) {
+	return nil, notSupported("CreateGraphV2")
+}
+
+func (c *Database) Analyzer(ctx context.Context, name string) (driver.ArangoSearchAnalyzer, error) {
+	return nil, arangoError(404, 1202, "analyzer '%s' not found", name)
+}
+
+func (c *Database) Analyzers(ctx context.Context) ([]driver.ArangoSearchAnalyzer, error) {
+	return nil, nil
+}
+
+func (c *Database) EnsureAnalyzer(ctx context.Context, analyzer driver.ArangoSearchAnalyzerDefinition) (bool, driver.ArangoSearchAnalyzer, error) {
+	return false, nil, notSupported("EnsureAnalyzer")
+}
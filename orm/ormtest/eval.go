@@ -0,0 +1,521 @@
+package ormtest
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// -------------------------------------
+// Expression nodes and their evaluation
+// -------------------------------------
+
+type scope map[string]interface{}
+
+func (c scope) with(name string, value interface{}) scope {
+	copied := make(scope, len(c)+1)
+	for key, item := range c {
+		copied[key] = item
+	}
+	copied[name] = value
+	return copied
+}
+
+// execution is the state of one query run
+type execution struct {
+	ctx      context.Context
+	db       *Database
+	tx       *transaction // the stream transaction the query runs in, nil outside one
+	bindVars map[string]interface{}
+}
+
+type node interface {
+	eval(ex *execution, vars scope) (interface{}, error)
+}
+
+type literal struct {
+	value interface{}
+}
+
+func (c *literal) eval(ex *execution, vars scope) (interface{}, error) {
+	return c.value, nil
+}
+
+type bindVar struct {
+	name string
+}
+
+func (c *bindVar) eval(ex *execution, vars scope) (interface{}, error) {
+	value, ok := ex.bindVars[c.name]
+	if !ok {
+		return nil, queryError(1552, "bind parameter '%s' was not declared in the query", c.name)
+	}
+	return value, nil
+}
+
+// bindCollection is @@name, it evaluates to the collection's documents
+type bindCollection struct {
+	name string
+}
+
+func (c *bindCollection) collectionName(ex *execution) (string, error) {
+	value, ok := ex.bindVars["@"+c.name]
+	if !ok {
+		return "", queryError(1552, "bind parameter '@%s' was not declared in the query", c.name)
+	}
+	name, ok := value.(string)
+	if !ok {
+		return "", queryError(1553, "collection bind parameter '@%s' must be a string", c.name)
+	}
+	return name, nil
+}
+
+func (c *bindCollection) eval(ex *execution, vars scope) (interface{}, error) {
+	name, err := c.collectionName(ex)
+	if err != nil {
+		return nil, err
+	}
+	return ex.db.documents(name)
+}
+
+// collectionName works out which collection a node names: @@bind, a bare name or a string
+func collectionName(ex *execution, n node) (string, error) {
+	switch v := n.(type) {
+	case *bindCollection:
+		return v.collectionName(ex)
+	case *literal:
+		if name, ok := v.value.(string); ok {
+			return name, nil
+		}
+	}
+	return "", queryError(1203, "expected a collection")
+}
+
+type variable struct {
+	name string
+}
+
+func (c *variable) eval(ex *execution, vars scope) (interface{}, error) {
+	if value, ok := vars[c.name]; ok {
+		return value, nil
+	}
+
+	// a bare collection name
+	if _, exists := ex.db.collections[c.name]; exists {
+		return ex.db.documents(c.name)
+	}
+
+	return nil, queryError(1512, "variable '%s' is unknown", c.name)
+}
+
+type attribute struct {
+	object node
+	name   string
+}
+
+func (c *attribute) eval(ex *execution, vars scope) (interface{}, error) {
+	object, err := c.object.eval(ex, vars)
+	if err != nil {
+		return nil, err
+	}
+	if m, ok := object.(map[string]interface{}); ok {
+		return m[c.name], nil
+	}
+	return nil, nil
+}
+
+type indexAccess struct {
+	object node
+	index  node
+}
+
+func (c *indexAccess) eval(ex *execution, vars scope) (interface{}, error) {
+	object, err := c.object.eval(ex, vars)
+	if err != nil {
+		return nil, err
+	}
+	index, err := c.index.eval(ex, vars)
+	if err != nil {
+		return nil, err
+	}
+
+	switch o := object.(type) {
+	case []interface{}:
+		i := int(toNumber(index))
+		if i < 0 {
+			i += len(o)
+		}
+		if i < 0 || i >= len(o) {
+			return nil, nil
+		}
+		return o[i], nil
+	case map[string]interface{}:
+		return o[toString(index)], nil
+	}
+	return nil, nil
+}
+
+// expansion is array[*].projection, the projection is evaluated with CURRENT set to each element.
+// With array[* FILTER condition] only the elements meeting the condition are projected.
+type expansion struct {
+	array      node
+	filter     node
+	projection node
+}
+
+func (c *expansion) eval(ex *execution, vars scope) (interface{}, error) {
+	array, err := c.array.eval(ex, vars)
+	if err != nil {
+		return nil, err
+	}
+
+	items, ok := array.([]interface{})
+	if !ok {
+		return []interface{}{}, nil
+	}
+
+	results := make([]interface{}, 0, len(items))
+	for _, item := range items {
+		current := vars.with("CURRENT", item)
+		if c.filter != nil {
+			keep, err := c.filter.eval(ex, current)
+			if err != nil {
+				return nil, err
+			}
+			if !truthy(keep) {
+				continue
+			}
+		}
+		value, err := c.projection.eval(ex, current)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, value)
+	}
+	return results, nil
+}
+
+type unary struct {
+	operator string
+	operand  node
+}
+
+func (c *unary) eval(ex *execution, vars scope) (interface{}, error) {
+	value, err := c.operand.eval(ex, vars)
+	if err != nil {
+		return nil, err
+	}
+
+	switch c.operator {
+	case "!":
+		return !truthy(value), nil
+	case "-":
+		return number(-toNumber(value)), nil
+	default:
+		return number(toNumber(value)), nil
+	}
+}
+
+type binary struct {
+	operator string
+	left     node
+	right    node
+}
+
+func (c *binary) eval(ex *execution, vars scope) (interface{}, error) {
+	left, err := c.left.eval(ex, vars)
+	if err != nil {
+		return nil, err
+	}
+
+	// the logical operators short circuit and return one of their operands
+	switch c.operator {
+	case "&&":
+		if !truthy(left) {
+			return left, nil
+		}
+		return c.right.eval(ex, vars)
+	case "||":
+		if truthy(left) {
+			return left, nil
+		}
+		return c.right.eval(ex, vars)
+	}
+
+	right, err := c.right.eval(ex, vars)
+	if err != nil {
+		return nil, err
+	}
+
+	switch c.operator {
+	case "==":
+		return equalValues(left, right), nil
+	case "!=":
+		return !equalValues(left, right), nil
+	case "<":
+		return compareValues(left, right) < 0, nil
+	case "<=":
+		return compareValues(left, right) <= 0, nil
+	case ">":
+		return compareValues(left, right) > 0, nil
+	case ">=":
+		return compareValues(left, right) >= 0, nil
+	case "IN":
+		return contains(right, left), nil
+	case "NOT IN":
+		return !contains(right, left), nil
+	case "LIKE", "NOT LIKE":
+		matched, err := like(toString(left), toString(right), false)
+		if err != nil {
+			return nil, err
+		}
+		return matched == (c.operator == "LIKE"), nil
+	case "=~", "!~":
+		pattern, err := regexp.Compile(toString(right))
+		if err != nil {
+			return nil, queryError(1575, "invalid regular expression %s", toString(right))
+		}
+		return pattern.MatchString(toString(left)) == (c.operator == "=~"), nil
+	case "+":
+		return number(toNumber(left) + toNumber(right)), nil
+	case "-":
+		return number(toNumber(left) - toNumber(right)), nil
+	case "*":
+		return number(toNumber(left) * toNumber(right)), nil
+	case "/":
+		if toNumber(right) == 0 {
+			return nil, nil
+		}
+		return number(toNumber(left) / toNumber(right)), nil
+	case "%":
+		if toNumber(right) == 0 {
+			return nil, nil
+		}
+		return number(math.Mod(toNumber(left), toNumber(right))), nil
+	}
+
+	return nil, fmt.Errorf("unknown operator %s", c.operator)
+}
+
+// quantified is an array comparison, each item of the left array is compared with the right value
+type quantified struct {
+	quantifier string
+	operator   string
+	left       node
+	right      node
+}
+
+func (c *quantified) eval(ex *execution, vars scope) (interface{}, error) {
+	left, err := c.left.eval(ex, vars)
+	if err != nil {
+		return nil, err
+	}
+	right, err := c.right.eval(ex, vars)
+	if err != nil {
+		return nil, err
+	}
+
+	items, ok := left.([]interface{})
+	if !ok {
+		return false, nil
+	}
+
+	matches := 0
+	for _, item := range items {
+		if equalValues(item, right) == (c.operator == "==") {
+			matches++
+		}
+	}
+
+	switch c.quantifier {
+	case "ALL":
+		return matches == len(items), nil
+	case "ANY":
+		return matches > 0, nil
+	}
+	return matches == 0, nil
+}
+
+func contains(array, value interface{}) bool {
+	items, ok := array.([]interface{})
+	if !ok {
+		return false
+	}
+	for _, item := range items {
+		if equalValues(item, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// like matches AQL LIKE patterns, % is any run of characters, _ is any one character
+func like(text, pattern string, caseInsensitive bool) (bool, error) {
+	var builder strings.Builder
+	builder.WriteString("(?s)^")
+	if caseInsensitive {
+		builder.WriteString("(?i)")
+	}
+
+	runes := []rune(pattern)
+	for i := 0; i < len(runes); i++ {
+		switch runes[i] {
+		case '%':
+			builder.WriteString(".*")
+		case '_':
+			builder.WriteString(".")
+		case '\\':
+			if i+1 < len(runes) {
+				i++
+			}
+			builder.WriteString(regexp.QuoteMeta(string(runes[i])))
+		default:
+			builder.WriteString(regexp.QuoteMeta(string(runes[i])))
+		}
+	}
+	builder.WriteString("$")
+
+	matcher, err := regexp.Compile(builder.String())
+	if err != nil {
+		return false, err
+	}
+	return matcher.MatchString(text), nil
+}
+
+type ternary struct {
+	condition node
+	then      node
+	otherwise node
+}
+
+func (c *ternary) eval(ex *execution, vars scope) (interface{}, error) {
+	condition, err := c.condition.eval(ex, vars)
+	if err != nil {
+		return nil, err
+	}
+	if truthy(condition) {
+		return c.then.eval(ex, vars)
+	}
+	return c.otherwise.eval(ex, vars)
+}
+
+type rangeNode struct {
+	from node
+	to   node
+}
+
+func (c *rangeNode) eval(ex *execution, vars scope) (interface{}, error) {
+	from, err := c.from.eval(ex, vars)
+	if err != nil {
+		return nil, err
+	}
+	to, err := c.to.eval(ex, vars)
+	if err != nil {
+		return nil, err
+	}
+
+	start, end := int(toNumber(from)), int(toNumber(to))
+	values := make([]interface{}, 0)
+	step := 1
+	if end < start {
+		step = -1
+	}
+	for i := start; ; i += step {
+		values = append(values, float64(i))
+		if i == end {
+			break
+		}
+	}
+	return values, nil
+}
+
+type call struct {
+	name      string
+	arguments []node
+}
+
+func (c *call) eval(ex *execution, vars scope) (interface{}, error) {
+	function, ok := functions[c.name]
+	if !ok {
+		return nil, queryError(1540, "usage of unknown function '%s()'", c.name)
+	}
+
+	arguments := make([]interface{}, len(c.arguments))
+	for i, argument := range c.arguments {
+		value, err := argument.eval(ex, vars)
+		if err != nil {
+			return nil, err
+		}
+		arguments[i] = value
+	}
+
+	return function(ex, arguments)
+}
+
+type arrayLiteral struct {
+	items []node
+}
+
+func (c *arrayLiteral) eval(ex *execution, vars scope) (interface{}, error) {
+	values := make([]interface{}, len(c.items))
+	for i, item := range c.items {
+		value, err := item.eval(ex, vars)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+type objectEntry struct {
+	name  string // a literal attribute name
+	key   node   // or a computed one
+	value node
+}
+
+type objectLiteral struct {
+	entries []objectEntry
+}
+
+func (c *objectLiteral) eval(ex *execution, vars scope) (interface{}, error) {
+	object := make(map[string]interface{}, len(c.entries))
+	for _, entry := range c.entries {
+		name := entry.name
+		if entry.key != nil {
+			key, err := entry.key.eval(ex, vars)
+			if err != nil {
+				return nil, err
+			}
+			name = toString(key)
+		}
+
+		value, err := entry.value.eval(ex, vars)
+		if err != nil {
+			return nil, err
+		}
+		object[name] = value
+	}
+	return object, nil
+}
+
+type subquery struct {
+	query *query
+}
+
+func (c *subquery) eval(ex *execution, vars scope) (interface{}, error) {
+	return c.query.run(ex, vars)
+}
+
+// ----------------
+// sorting helpers shared by SORT and functions
+// ----------------
+
+func sortValues(values []interface{}) {
+	sort.SliceStable(values, func(i, j int) bool {
+		return compareValues(values[i], values[j]) < 0
+	})
+}
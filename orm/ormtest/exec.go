@@ -0,0 +1,703 @@
+package ormtest
+
+import (
+	"sort"
+)
+
+// -------------------------------------
+// Statements. A query runs its statements one after the other over a list of rows
+// (variable scopes), every FOR multiplies the rows and every FILTER drops some, the
+// way AQL's execution plan works when nothing is optimized.
+// -------------------------------------
+
+type statement interface {
+	exec(ex *execution, outer scope, rows []scope) ([]scope, error)
+}
+
+type query struct {
+	statements []statement
+	ret        *returnStatement
+	modifies   bool
+}
+
+// run executes the query with the variables of an enclosing query (if it's a subquery) in outer
+func (c *query) run(ex *execution, outer scope) ([]interface{}, error) {
+	if outer == nil {
+		outer = scope{}
+	}
+
+	rows := []scope{outer}
+	for _, statement := range c.statements {
+		var err error
+		if rows, err = statement.exec(ex, outer, rows); err != nil {
+			return nil, err
+		}
+	}
+
+	results := make([]interface{}, 0)
+	if c.ret == nil {
+		return results, nil
+	}
+
+	seen := make(map[string]bool)
+	for _, row := range rows {
+		value, err := c.ret.expression.eval(ex, row)
+		if err != nil {
+			return nil, err
+		}
+		if c.ret.distinct {
+			id := identity(value)
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+		}
+		results = append(results, value)
+	}
+
+	return results, nil
+}
+
+type returnStatement struct {
+	distinct   bool
+	expression node
+}
+
+// ----------------
+// FOR
+// ----------------
+
+type forStatement struct {
+	variables []string
+	source    node
+	traversal *traversal
+}
+
+func (c *forStatement) exec(ex *execution, outer scope, rows []scope) ([]scope, error) {
+	results := make([]scope, 0)
+	for _, row := range rows {
+		if c.traversal != nil {
+			walked, err := c.traversal.walk(ex, row, c.variables)
+			if err != nil {
+				return nil, err
+			}
+			results = append(results, walked...)
+			continue
+		}
+
+		source, err := c.source.eval(ex, row)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range toArray(source) {
+			results = append(results, row.with(c.variables[0], item))
+		}
+	}
+
+	return results, nil
+}
+
+type traversal struct {
+	direction string
+	min       node
+	max       node
+	start     node
+	edges     node
+}
+
+// walk follows the edges depth first from the start vertex, edges are unique per path
+func (c *traversal) walk(ex *execution, row scope, variables []string) ([]scope, error) {
+	minValue, err := c.min.eval(ex, row)
+	if err != nil {
+		return nil, err
+	}
+	maxValue, err := c.max.eval(ex, row)
+	if err != nil {
+		return nil, err
+	}
+	minDepth, maxDepth := int(toNumber(minValue)), int(toNumber(maxValue))
+
+	startValue, err := c.start.eval(ex, row)
+	if err != nil {
+		return nil, err
+	}
+	startId := toString(startValue)
+	if object, ok := startValue.(map[string]interface{}); ok {
+		startId = toString(object["_id"])
+	}
+
+	edgeCollection, err := collectionName(ex, c.edges)
+	if err != nil {
+		return nil, err
+	}
+	edges, err := ex.db.documents(edgeCollection)
+	if err != nil {
+		return nil, err
+	}
+
+	start := ex.db.vertex(startId)
+	if start == nil {
+		return []scope{}, nil
+	}
+
+	results := make([]scope, 0)
+	emit := func(pathEdges, pathVertices []interface{}) {
+		var edge interface{}
+		if len(pathEdges) > 0 {
+			edge = pathEdges[len(pathEdges)-1]
+		}
+		path := map[string]interface{}{
+			"edges":    append([]interface{}{}, pathEdges...),
+			"vertices": append([]interface{}{}, pathVertices...),
+		}
+
+		values := []interface{}{pathVertices[len(pathVertices)-1], edge, path}
+		next := row
+		for i, name := range variables {
+			next = next.with(name, values[i])
+		}
+		results = append(results, next)
+	}
+
+	var visit func(id string, pathEdges, pathVertices []interface{})
+	visit = func(id string, pathEdges, pathVertices []interface{}) {
+		depth := len(pathEdges)
+		if depth >= minDepth {
+			emit(pathEdges, pathVertices)
+		}
+		if depth >= maxDepth {
+			return
+		}
+
+		for _, item := range edges {
+			edge := item.(map[string]interface{})
+			if onPath(pathEdges, edge) {
+				continue
+			}
+
+			from, to := toString(edge["_from"]), toString(edge["_to"])
+			var next string
+			switch {
+			case (c.direction == "OUTBOUND" || c.direction == "ANY") && from == id:
+				next = to
+			case (c.direction == "INBOUND" || c.direction == "ANY") && to == id:
+				next = from
+			default:
+				continue
+			}
+
+			vertex := ex.db.vertex(next)
+			if vertex == nil {
+				continue
+			}
+			visit(next, append(pathEdges[:depth:depth], edge), append(pathVertices[:depth+1:depth+1], vertex))
+		}
+	}
+	visit(startId, []interface{}{}, []interface{}{start})
+
+	return results, nil
+}
+
+func onPath(pathEdges []interface{}, edge map[string]interface{}) bool {
+	for _, item := range pathEdges {
+		if item.(map[string]interface{})["_id"] == edge["_id"] {
+			return true
+		}
+	}
+	return false
+}
+
+// ----------------
+// FILTER, SORT, LIMIT and LET
+// ----------------
+
+type filterStatement struct {
+	condition node
+}
+
+func (c *filterStatement) exec(ex *execution, outer scope, rows []scope) ([]scope, error) {
+	results := make([]scope, 0, len(rows))
+	for _, row := range rows {
+		value, err := c.condition.eval(ex, row)
+		if err != nil {
+			return nil, err
+		}
+		if truthy(value) {
+			results = append(results, row)
+		}
+	}
+	return results, nil
+}
+
+type sortKey struct {
+	expression node
+	descending bool
+}
+
+type sortStatement struct {
+	keys []sortKey
+}
+
+func (c *sortStatement) exec(ex *execution, outer scope, rows []scope) ([]scope, error) {
+	type sortable struct {
+		row    scope
+		values []interface{}
+	}
+
+	items := make([]sortable, len(rows))
+	for i, row := range rows {
+		items[i].row = row
+		for _, key := range c.keys {
+			value, err := key.expression.eval(ex, row)
+			if err != nil {
+				return nil, err
+			}
+			items[i].values = append(items[i].values, value)
+		}
+	}
+
+	sort.SliceStable(items, func(i, j int) bool {
+		for k, key := range c.keys {
+			compared := compareValues(items[i].values[k], items[j].values[k])
+			if compared == 0 {
+				continue
+			}
+			if key.descending {
+				return compared > 0
+			}
+			return compared < 0
+		}
+		return false
+	})
+
+	results := make([]scope, len(items))
+	for i, item := range items {
+		results[i] = item.row
+	}
+	return results, nil
+}
+
+type limitStatement struct {
+	offset node
+	count  node
+}
+
+func (c *limitStatement) exec(ex *execution, outer scope, rows []scope) ([]scope, error) {
+	offsetValue, err := c.offset.eval(ex, outer)
+	if err != nil {
+		return nil, err
+	}
+	countValue, err := c.count.eval(ex, outer)
+	if err != nil {
+		return nil, err
+	}
+
+	offset, count := int(toNumber(offsetValue)), int(toNumber(countValue))
+	if offset < 0 || count < 0 {
+		return nil, queryError(1579, "LIMIT value is not a valid number")
+	}
+	if offset >= len(rows) {
+		return []scope{}, nil
+	}
+	end := offset + count
+	if end > len(rows) {
+		end = len(rows)
+	}
+	return rows[offset:end], nil
+}
+
+type letStatement struct {
+	name       string
+	expression node
+}
+
+func (c *letStatement) exec(ex *execution, outer scope, rows []scope) ([]scope, error) {
+	results := make([]scope, len(rows))
+	for i, row := range rows {
+		value, err := c.expression.eval(ex, row)
+		if err != nil {
+			return nil, err
+		}
+		results[i] = row.with(c.name, value)
+	}
+	return results, nil
+}
+
+// ----------------
+// COLLECT
+// ----------------
+
+type assignment struct {
+	name       string
+	expression node
+}
+
+type collectStatement struct {
+	groups         []assignment
+	aggregates     []assignment
+	into           string
+	intoExpression node
+	countInto      string
+}
+
+type group struct {
+	values []interface{}
+	rows   []scope
+}
+
+func (c *collectStatement) exec(ex *execution, outer scope, rows []scope) ([]scope, error) {
+	groups := make([]*group, 0)
+	byIdentity := make(map[string]*group)
+
+	if len(c.groups) == 0 {
+		// everything is one group, even when there's nothing to count
+		groups = append(groups, &group{rows: rows})
+	} else {
+		for _, row := range rows {
+			values := make([]interface{}, len(c.groups))
+			for i, assignment := range c.groups {
+				value, err := assignment.expression.eval(ex, row)
+				if err != nil {
+					return nil, err
+				}
+				values[i] = value
+			}
+
+			id := identity(values)
+			g, exists := byIdentity[id]
+			if !exists {
+				g = &group{values: values}
+				byIdentity[id] = g
+				groups = append(groups, g)
+			}
+			g.rows = append(g.rows, row)
+		}
+
+		// COLLECT sorts by the groups unless told otherwise
+		sort.SliceStable(groups, func(i, j int) bool {
+			return compareValues(groups[i].values, groups[j].values) < 0
+		})
+	}
+
+	results := make([]scope, 0, len(groups))
+	for _, g := range groups {
+		row := outer
+		for i, assignment := range c.groups {
+			row = row.with(assignment.name, g.values[i])
+		}
+
+		for _, assignment := range c.aggregates {
+			value, err := aggregate(ex, assignment.expression.(*call), g.rows)
+			if err != nil {
+				return nil, err
+			}
+			row = row.with(assignment.name, value)
+		}
+
+		if c.into != "" {
+			into := make([]interface{}, 0, len(g.rows))
+			for _, member := range g.rows {
+				if c.intoExpression == nil {
+					into = append(into, localVariables(outer, member))
+					continue
+				}
+				value, err := c.intoExpression.eval(ex, member)
+				if err != nil {
+					return nil, err
+				}
+				into = append(into, value)
+			}
+			row = row.with(c.into, into)
+		}
+
+		if c.countInto != "" {
+			row = row.with(c.countInto, float64(len(g.rows)))
+		}
+
+		results = append(results, row)
+	}
+
+	return results, nil
+}
+
+// aggregate applies an AGGREGATE function to the values its argument takes over the rows of a group
+func aggregate(ex *execution, function *call, rows []scope) (interface{}, error) {
+	values := make([]interface{}, 0, len(rows))
+	for _, row := range rows {
+		var value interface{}
+		if len(function.arguments) > 0 {
+			var err error
+			if value, err = function.arguments[0].eval(ex, row); err != nil {
+				return nil, err
+			}
+		}
+		values = append(values, value)
+	}
+
+	switch function.name {
+	case "PUSH":
+		return values, nil
+	case "LENGTH", "COUNT":
+		return float64(len(values)), nil
+	}
+
+	apply, ok := functions[function.name]
+	if !ok {
+		return nil, queryError(1540, "usage of unknown function '%s()'", function.name)
+	}
+	return apply(ex, []interface{}{values})
+}
+
+// localVariables is what INTO collects without an expression: the variables of this query level
+func localVariables(outer, row scope) map[string]interface{} {
+	variables := make(map[string]interface{})
+	for name, value := range row {
+		if _, isOuter := outer[name]; !isOuter {
+			variables[name] = value
+		}
+	}
+	return variables
+}
+
+// ----------------
+// INSERT, UPDATE, REPLACE, REMOVE and UPSERT
+// ----------------
+
+type modification struct {
+	kind       string
+	key        node // UPDATE key WITH document
+	document   node
+	collection node
+	options    node
+}
+
+type writeOptions struct {
+	keepNull     bool
+	mergeObjects bool
+	ignoreErrors bool
+	ignoreRevs   bool
+}
+
+func evalOptions(ex *execution, options node, row scope) (writeOptions, error) {
+	parsed := writeOptions{keepNull: true, mergeObjects: true, ignoreRevs: true}
+	if options == nil {
+		return parsed, nil
+	}
+
+	value, err := options.eval(ex, row)
+	if err != nil {
+		return parsed, err
+	}
+	object, _ := value.(map[string]interface{})
+	flag := func(name string, current bool) bool {
+		if set, ok := object[name].(bool); ok {
+			return set
+		}
+		return current
+	}
+
+	parsed.keepNull = flag("keepNull", parsed.keepNull)
+	parsed.mergeObjects = flag("mergeObjects", parsed.mergeObjects)
+	parsed.ignoreErrors = flag("ignoreErrors", parsed.ignoreErrors)
+	parsed.ignoreRevs = flag("ignoreRevs", parsed.ignoreRevs)
+	return parsed, nil
+}
+
+// documentKey is the key a modification refers to, given a key string or a document
+func documentKey(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case map[string]interface{}:
+		if key, ok := v["_key"].(string); ok {
+			return key, nil
+		}
+	}
+	return "", queryError(1227, "invalid document key")
+}
+
+func (c *modification) exec(ex *execution, outer scope, rows []scope) ([]scope, error) {
+	name, err := collectionName(ex, c.collection)
+	if err != nil {
+		return nil, err
+	}
+	collection, err := ex.db.collection(name)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]scope, 0, len(rows))
+	for _, row := range rows {
+		options, err := evalOptions(ex, c.options, row)
+		if err != nil {
+			return nil, err
+		}
+
+		document, err := c.document.eval(ex, row)
+		if err != nil {
+			return nil, err
+		}
+
+		keyValue := document
+		if c.key != nil {
+			if keyValue, err = c.key.eval(ex, row); err != nil {
+				return nil, err
+			}
+		}
+
+		var old, updated map[string]interface{}
+		switch c.kind {
+		case "INSERT":
+			object, ok := document.(map[string]interface{})
+			if !ok {
+				err = queryError(1227, "INSERT needs a document")
+				break
+			}
+			updated, err = collection.insert(ex.tx, object)
+
+		case "REMOVE":
+			var key string
+			if key, err = documentKey(keyValue); err != nil {
+				break
+			}
+			if err = collection.checkRevision(key, revisionOf(keyValue, options)); err != nil {
+				break
+			}
+			old, err = collection.remove(ex.tx, key)
+
+		default: // UPDATE and REPLACE
+			var key string
+			if key, err = documentKey(keyValue); err != nil {
+				break
+			}
+			object, ok := document.(map[string]interface{})
+			if !ok {
+				err = queryError(1227, "%s needs a document", c.kind)
+				break
+			}
+			if err = collection.checkRevision(key, revisionOf(keyValue, options)); err != nil {
+				break
+			}
+			if c.kind == "UPDATE" {
+				old, updated, err = collection.update(ex.tx, key, object, options.keepNull, options.mergeObjects)
+			} else {
+				old, updated, err = collection.replace(ex.tx, key, object)
+			}
+		}
+
+		if err != nil {
+			if options.ignoreErrors {
+				continue
+			}
+			return nil, err
+		}
+
+		results = append(results, row.with("OLD", mapOrNil(old)).with("NEW", mapOrNil(updated)))
+	}
+
+	return results, nil
+}
+
+// revisionOf is the revision a modification must match, when it checks revisions at all
+func revisionOf(keyValue interface{}, options writeOptions) string {
+	if options.ignoreRevs {
+		return ""
+	}
+	if object, ok := keyValue.(map[string]interface{}); ok {
+		rev, _ := object["_rev"].(string)
+		return rev
+	}
+	return ""
+}
+
+// mapOrNil keeps a missing document null rather than a typed nil map
+func mapOrNil(document map[string]interface{}) interface{} {
+	if document == nil {
+		return nil
+	}
+	return document
+}
+
+type upsert struct {
+	search     node
+	insert     node
+	update     node
+	replace    bool
+	collection node
+	options    node
+}
+
+func (c *upsert) exec(ex *execution, outer scope, rows []scope) ([]scope, error) {
+	name, err := collectionName(ex, c.collection)
+	if err != nil {
+		return nil, err
+	}
+	collection, err := ex.db.collection(name)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]scope, 0, len(rows))
+	for _, row := range rows {
+		options, err := evalOptions(ex, c.options, row)
+		if err != nil {
+			return nil, err
+		}
+
+		searchValue, err := c.search.eval(ex, row)
+		if err != nil {
+			return nil, err
+		}
+		search, ok := searchValue.(map[string]interface{})
+		if !ok {
+			return nil, queryError(1227, "UPSERT needs a search document")
+		}
+
+		var old, updated map[string]interface{}
+		if match := collection.find(search); match == nil {
+			document, err := c.insert.eval(ex, row.with("OLD", nil))
+			if err != nil {
+				return nil, err
+			}
+			object, ok := document.(map[string]interface{})
+			if !ok {
+				return nil, queryError(1227, "UPSERT needs an INSERT document")
+			}
+			if updated, err = collection.insert(ex.tx, object); err != nil {
+				return nil, err
+			}
+		} else {
+			document, err := c.update.eval(ex, row.with("OLD", match))
+			if err != nil {
+				return nil, err
+			}
+			object, ok := document.(map[string]interface{})
+			if !ok {
+				return nil, queryError(1227, "UPSERT needs an UPDATE document")
+			}
+			key := match["_key"].(string)
+			if c.replace {
+				old, updated, err = collection.replace(ex.tx, key, object)
+			} else {
+				old, updated, err = collection.update(ex.tx, key, object, options.keepNull, options.mergeObjects)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		results = append(results, row.with("OLD", mapOrNil(old)).with("NEW", mapOrNil(updated)))
+	}
+
+	return results, nil
+}
+
+// matches checks that document has every attribute of search, with the same value
+func matches(document, search map[string]interface{}) bool {
+	for name, value := range search {
+		if !equalValues(document[name], value) {
+			return false
+		}
+	}
+	return true
+}
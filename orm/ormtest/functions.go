@@ -0,0 +1,715 @@
+package ormtest
+
+import (
+	"math"
+	"math/rand"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// -------------------------------------
+// AQL functions. Only the ones the ORM generates and the ones tests tend to reach for
+// are here, calling any other function fails the query like an unknown function would.
+// -------------------------------------
+
+type function func(ex *execution, arguments []interface{}) (interface{}, error)
+
+var functions map[string]function
+
+func init() {
+	functions = map[string]function{
+		// arrays
+		"LENGTH":             fnLength,
+		"COUNT":              fnLength,
+		"SUM":                fnSum,
+		"AVERAGE":            fnAverage,
+		"AVG":                fnAverage,
+		"MIN":                fnMin,
+		"MAX":                fnMax,
+		"COUNT_DISTINCT":     fnCountDistinct,
+		"COUNT_UNIQUE":       fnCountDistinct,
+		"UNIQUE":             fnUnique,
+		"SORTED_UNIQUE":      fnSortedUnique,
+		"SORTED":             fnSorted,
+		"FIRST":              fnFirst,
+		"LAST":               fnLast,
+		"NTH":                fnNth,
+		"POSITION":           fnPosition,
+		"PUSH":               fnPush,
+		"APPEND":             fnAppend,
+		"REMOVE_VALUE":       fnRemoveValue,
+		"REMOVE_VALUES":      fnRemoveValues,
+		"UNION":              fnUnion,
+		"FLATTEN":            fnFlatten,
+		"SLICE":              fnSlice,
+		"REVERSE":            fnReverse,
+		"NOT_NULL":           fnNotNull,
+		"IS_ARRAY":           typeCheck(func(v interface{}) bool { _, ok := v.([]interface{}); return ok }),
+		"IS_OBJECT":          typeCheck(func(v interface{}) bool { _, ok := v.(map[string]interface{}); return ok }),
+		"IS_STRING":          typeCheck(func(v interface{}) bool { _, ok := v.(string); return ok }),
+		"IS_NUMBER":          typeCheck(func(v interface{}) bool { _, ok := v.(float64); return ok }),
+		"IS_BOOL":            typeCheck(func(v interface{}) bool { _, ok := v.(bool); return ok }),
+		"IS_NULL":            typeCheck(func(v interface{}) bool { return v == nil }),
+		"TO_STRING":          func(ex *execution, a []interface{}) (interface{}, error) { return toString(arg(a, 0)), nil },
+		"TO_NUMBER":          func(ex *execution, a []interface{}) (interface{}, error) { return toNumber(arg(a, 0)), nil },
+		"TO_BOOL":            func(ex *execution, a []interface{}) (interface{}, error) { return truthy(arg(a, 0)), nil },
+		"TO_ARRAY":           func(ex *execution, a []interface{}) (interface{}, error) { return toArray(arg(a, 0)), nil },
+		"HAS":                fnHas,
+		"ATTRIBUTES":         fnAttributes,
+		"VALUES":             fnValues,
+		"MERGE":              fnMerge,
+		"MERGE_RECURSIVE":    fnMergeRecursive,
+		"UNSET":              fnUnset,
+		"KEEP":               fnKeep,
+		"DOCUMENT":           fnDocument,
+		"IS_SAME_COLLECTION": fnIsSameCollection,
+
+		// strings
+		"CONCAT":           fnConcat,
+		"CONCAT_SEPARATOR": fnConcatSeparator,
+		"LOWER":            stringFunction(strings.ToLower),
+		"UPPER":            stringFunction(strings.ToUpper),
+		"TRIM":             stringFunction(strings.TrimSpace),
+		"SUBSTRING":        fnSubstring,
+		"CONTAINS":         fnContains,
+		"STARTS_WITH":      fnStartsWith,
+		"LIKE":             fnLike,
+		"SPLIT":            fnSplit,
+		"REGEX_TEST":       fnRegexTest,
+
+		// numbers
+		"ABS":   numberFunction(math.Abs),
+		"FLOOR": numberFunction(math.Floor),
+		"CEIL":  numberFunction(math.Ceil),
+		"ROUND": numberFunction(func(v float64) float64 { return math.Floor(v + 0.5) }),
+		"SQRT":  numberFunction(math.Sqrt),
+		"RAND":  func(ex *execution, a []interface{}) (interface{}, error) { return rand.Float64(), nil },
+
+		// dates
+		"DATE_NOW": func(ex *execution, a []interface{}) (interface{}, error) {
+			return float64(time.Now().UnixMilli()), nil
+		},
+
+		// geo
+		"GEO_POINT":    fnGeoPoint,
+		"GEO_DISTANCE": fnGeoDistance,
+		"GEO_CONTAINS": fnGeoContains,
+	}
+}
+
+// arg is the i-th argument, or null when it wasn't given
+func arg(arguments []interface{}, i int) interface{} {
+	if i < len(arguments) {
+		return arguments[i]
+	}
+	return nil
+}
+
+func typeCheck(check func(value interface{}) bool) function {
+	return func(ex *execution, arguments []interface{}) (interface{}, error) {
+		return check(arg(arguments, 0)), nil
+	}
+}
+
+func stringFunction(convert func(string) string) function {
+	return func(ex *execution, arguments []interface{}) (interface{}, error) {
+		return convert(toString(arg(arguments, 0))), nil
+	}
+}
+
+func numberFunction(convert func(float64) float64) function {
+	return func(ex *execution, arguments []interface{}) (interface{}, error) {
+		return number(convert(toNumber(arg(arguments, 0)))), nil
+	}
+}
+
+// ----------------
+// arrays
+// ----------------
+
+func fnLength(ex *execution, arguments []interface{}) (interface{}, error) {
+	switch v := arg(arguments, 0).(type) {
+	case nil:
+		return 0.0, nil
+	case []interface{}:
+		return float64(len(v)), nil
+	case map[string]interface{}:
+		return float64(len(v)), nil
+	case string:
+		return float64(len([]rune(v))), nil
+	case bool:
+		if v {
+			return 1.0, nil
+		}
+		return 0.0, nil
+	default:
+		return float64(len(toString(v))), nil
+	}
+}
+
+// notNull are the values of an array that aren't null, which is what the aggregate functions look at
+func notNull(value interface{}) []interface{} {
+	values := make([]interface{}, 0)
+	for _, item := range toArray(value) {
+		if item != nil {
+			values = append(values, item)
+		}
+	}
+	return values
+}
+
+func fnSum(ex *execution, arguments []interface{}) (interface{}, error) {
+	sum := 0.0
+	for _, item := range notNull(arg(arguments, 0)) {
+		sum += toNumber(item)
+	}
+	return number(sum), nil
+}
+
+func fnAverage(ex *execution, arguments []interface{}) (interface{}, error) {
+	values := notNull(arg(arguments, 0))
+	if len(values) == 0 {
+		return nil, nil
+	}
+	sum := 0.0
+	for _, item := range values {
+		sum += toNumber(item)
+	}
+	return number(sum / float64(len(values))), nil
+}
+
+func fnMin(ex *execution, arguments []interface{}) (interface{}, error) {
+	var found interface{}
+	for _, item := range notNull(arg(arguments, 0)) {
+		if found == nil || compareValues(item, found) < 0 {
+			found = item
+		}
+	}
+	return found, nil
+}
+
+func fnMax(ex *execution, arguments []interface{}) (interface{}, error) {
+	var found interface{}
+	for _, item := range notNull(arg(arguments, 0)) {
+		if found == nil || compareValues(item, found) > 0 {
+			found = item
+		}
+	}
+	return found, nil
+}
+
+func unique(values []interface{}) []interface{} {
+	seen := make(map[string]bool)
+	results := make([]interface{}, 0, len(values))
+	for _, item := range values {
+		id := identity(item)
+		if !seen[id] {
+			seen[id] = true
+			results = append(results, item)
+		}
+	}
+	return results
+}
+
+func fnCountDistinct(ex *execution, arguments []interface{}) (interface{}, error) {
+	return float64(len(unique(notNull(arg(arguments, 0))))), nil
+}
+
+func fnUnique(ex *execution, arguments []interface{}) (interface{}, error) {
+	return unique(toArray(arg(arguments, 0))), nil
+}
+
+func fnSortedUnique(ex *execution, arguments []interface{}) (interface{}, error) {
+	values := unique(toArray(arg(arguments, 0)))
+	sortValues(values)
+	return values, nil
+}
+
+func fnSorted(ex *execution, arguments []interface{}) (interface{}, error) {
+	values := append([]interface{}{}, toArray(arg(arguments, 0))...)
+	sortValues(values)
+	return values, nil
+}
+
+func fnFirst(ex *execution, arguments []interface{}) (interface{}, error) {
+	values := toArray(arg(arguments, 0))
+	if len(values) == 0 {
+		return nil, nil
+	}
+	return values[0], nil
+}
+
+func fnLast(ex *execution, arguments []interface{}) (interface{}, error) {
+	values := toArray(arg(arguments, 0))
+	if len(values) == 0 {
+		return nil, nil
+	}
+	return values[len(values)-1], nil
+}
+
+func fnNth(ex *execution, arguments []interface{}) (interface{}, error) {
+	values := toArray(arg(arguments, 0))
+	i := int(toNumber(arg(arguments, 1)))
+	if i < 0 || i >= len(values) {
+		return nil, nil
+	}
+	return values[i], nil
+}
+
+// fnPosition returns whether the value is in the array, or where when the third argument is true
+func fnPosition(ex *execution, arguments []interface{}) (interface{}, error) {
+	values := toArray(arg(arguments, 0))
+	position := -1
+	for i, item := range values {
+		if equalValues(item, arg(arguments, 1)) {
+			position = i
+			break
+		}
+	}
+
+	if truthy(arg(arguments, 2)) {
+		return float64(position), nil
+	}
+	return position >= 0, nil
+}
+
+func fnPush(ex *execution, arguments []interface{}) (interface{}, error) {
+	values := append([]interface{}{}, toArray(arg(arguments, 0))...)
+	if truthy(arg(arguments, 2)) && contains(values, arg(arguments, 1)) {
+		return values, nil
+	}
+	return append(values, arg(arguments, 1)), nil
+}
+
+func fnAppend(ex *execution, arguments []interface{}) (interface{}, error) {
+	values := append([]interface{}{}, toArray(arg(arguments, 0))...)
+	for _, item := range toArray(arg(arguments, 1)) {
+		if truthy(arg(arguments, 2)) && contains(values, item) {
+			continue
+		}
+		values = append(values, item)
+	}
+	return values, nil
+}
+
+func fnRemoveValue(ex *execution, arguments []interface{}) (interface{}, error) {
+	values := make([]interface{}, 0)
+	limit := -1
+	if len(arguments) > 2 && arguments[2] != nil {
+		limit = int(toNumber(arguments[2]))
+	}
+	for _, item := range toArray(arg(arguments, 0)) {
+		if limit != 0 && equalValues(item, arg(arguments, 1)) {
+			limit--
+			continue
+		}
+		values = append(values, item)
+	}
+	return values, nil
+}
+
+func fnRemoveValues(ex *execution, arguments []interface{}) (interface{}, error) {
+	values := make([]interface{}, 0)
+	for _, item := range toArray(arg(arguments, 0)) {
+		if !contains(arg(arguments, 1), item) {
+			values = append(values, item)
+		}
+	}
+	return values, nil
+}
+
+func fnUnion(ex *execution, arguments []interface{}) (interface{}, error) {
+	values := make([]interface{}, 0)
+	for _, argument := range arguments {
+		values = append(values, toArray(argument)...)
+	}
+	return values, nil
+}
+
+func flatten(values []interface{}, depth int) []interface{} {
+	results := make([]interface{}, 0, len(values))
+	for _, item := range values {
+		if nested, ok := item.([]interface{}); ok && depth > 0 {
+			results = append(results, flatten(nested, depth-1)...)
+			continue
+		}
+		results = append(results, item)
+	}
+	return results
+}
+
+func fnFlatten(ex *execution, arguments []interface{}) (interface{}, error) {
+	depth := 1
+	if len(arguments) > 1 {
+		depth = int(toNumber(arguments[1]))
+	}
+	return flatten(toArray(arg(arguments, 0)), depth), nil
+}
+
+func fnSlice(ex *execution, arguments []interface{}) (interface{}, error) {
+	values := toArray(arg(arguments, 0))
+	start := int(toNumber(arg(arguments, 1)))
+	if start < 0 {
+		start += len(values)
+	}
+	start = int(math.Max(0, math.Min(float64(start), float64(len(values)))))
+
+	end := len(values)
+	if length := arg(arguments, 2); length != nil {
+		if n := int(toNumber(length)); n < 0 {
+			end = len(values) + n
+		} else {
+			end = start + n
+		}
+	}
+	end = int(math.Max(float64(start), math.Min(float64(end), float64(len(values)))))
+
+	return append([]interface{}{}, values[start:end]...), nil
+}
+
+func fnReverse(ex *execution, arguments []interface{}) (interface{}, error) {
+	if text, ok := arg(arguments, 0).(string); ok {
+		runes := []rune(text)
+		for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+			runes[i], runes[j] = runes[j], runes[i]
+		}
+		return string(runes), nil
+	}
+
+	values := toArray(arg(arguments, 0))
+	reversed := make([]interface{}, len(values))
+	for i, item := range values {
+		reversed[len(values)-1-i] = item
+	}
+	return reversed, nil
+}
+
+func fnNotNull(ex *execution, arguments []interface{}) (interface{}, error) {
+	for _, argument := range arguments {
+		if argument != nil {
+			return argument, nil
+		}
+	}
+	return nil, nil
+}
+
+// ----------------
+// documents
+// ----------------
+
+func fnHas(ex *execution, arguments []interface{}) (interface{}, error) {
+	object, ok := arg(arguments, 0).(map[string]interface{})
+	if !ok {
+		return false, nil
+	}
+	_, exists := object[toString(arg(arguments, 1))]
+	return exists, nil
+}
+
+func fnAttributes(ex *execution, arguments []interface{}) (interface{}, error) {
+	object, _ := arg(arguments, 0).(map[string]interface{})
+	names := make([]interface{}, 0, len(object))
+	for _, name := range sortedKeys(object) {
+		if truthy(arg(arguments, 1)) && strings.HasPrefix(name, "_") {
+			continue
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+func fnValues(ex *execution, arguments []interface{}) (interface{}, error) {
+	object, _ := arg(arguments, 0).(map[string]interface{})
+	values := make([]interface{}, 0, len(object))
+	for _, name := range sortedKeys(object) {
+		if truthy(arg(arguments, 1)) && strings.HasPrefix(name, "_") {
+			continue
+		}
+		values = append(values, object[name])
+	}
+	return values, nil
+}
+
+// objects are the arguments of MERGE, either given one by one or as one array
+func objects(arguments []interface{}) []interface{} {
+	if len(arguments) == 1 {
+		if values, ok := arguments[0].([]interface{}); ok {
+			return values
+		}
+	}
+	return arguments
+}
+
+func fnMerge(ex *execution, arguments []interface{}) (interface{}, error) {
+	merged := make(map[string]interface{})
+	for _, argument := range objects(arguments) {
+		object, ok := argument.(map[string]interface{})
+		if !ok {
+			return nil, nil
+		}
+		for name, value := range object {
+			merged[name] = value
+		}
+	}
+	return merged, nil
+}
+
+func mergeRecursive(into, from map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(into))
+	for name, value := range into {
+		merged[name] = value
+	}
+	for name, value := range from {
+		existing, existingIsObject := merged[name].(map[string]interface{})
+		incoming, incomingIsObject := value.(map[string]interface{})
+		if existingIsObject && incomingIsObject {
+			merged[name] = mergeRecursive(existing, incoming)
+			continue
+		}
+		merged[name] = value
+	}
+	return merged
+}
+
+func fnMergeRecursive(ex *execution, arguments []interface{}) (interface{}, error) {
+	merged := make(map[string]interface{})
+	for _, argument := range arguments {
+		object, ok := argument.(map[string]interface{})
+		if !ok {
+			return nil, nil
+		}
+		merged = mergeRecursive(merged, object)
+	}
+	return merged, nil
+}
+
+// attributeNames are the names given to UNSET and KEEP, one by one or as an array
+func attributeNames(arguments []interface{}) map[string]bool {
+	names := make(map[string]bool)
+	for _, argument := range arguments {
+		for _, name := range toArray(argument) {
+			names[toString(name)] = true
+		}
+	}
+	return names
+}
+
+func fnUnset(ex *execution, arguments []interface{}) (interface{}, error) {
+	object, ok := arg(arguments, 0).(map[string]interface{})
+	if !ok {
+		return nil, nil
+	}
+	names := attributeNames(arguments[1:])
+	result := make(map[string]interface{}, len(object))
+	for name, value := range object {
+		if !names[name] {
+			result[name] = value
+		}
+	}
+	return result, nil
+}
+
+func fnKeep(ex *execution, arguments []interface{}) (interface{}, error) {
+	object, ok := arg(arguments, 0).(map[string]interface{})
+	if !ok {
+		return nil, nil
+	}
+	names := attributeNames(arguments[1:])
+	result := make(map[string]interface{}, len(names))
+	for name, value := range object {
+		if names[name] {
+			result[name] = value
+		}
+	}
+	return result, nil
+}
+
+// fnIsSameCollection checks a document, or a document id, is in the collection: IS_SAME_COLLECTION("coll", doc)
+func fnIsSameCollection(ex *execution, arguments []interface{}) (interface{}, error) {
+	id := arg(arguments, 1)
+	if document, ok := id.(map[string]interface{}); ok {
+		id = document["_id"]
+	}
+	return strings.HasPrefix(toString(id), toString(arg(arguments, 0))+"/"), nil
+}
+
+// fnDocument looks documents up by id, DOCUMENT("coll/key"), DOCUMENT(["coll/key", ...]) or DOCUMENT("coll", "key")
+func fnDocument(ex *execution, arguments []interface{}) (interface{}, error) {
+	if len(arguments) == 2 {
+		collection := toString(arguments[0])
+		if keys, ok := arguments[1].([]interface{}); ok {
+			documents := make([]interface{}, 0, len(keys))
+			for _, key := range keys {
+				if document := ex.db.vertex(collection + "/" + toString(key)); document != nil {
+					documents = append(documents, document)
+				}
+			}
+			return documents, nil
+		}
+		return mapOrNil(ex.db.vertex(collection + "/" + toString(arguments[1]))), nil
+	}
+
+	if ids, ok := arg(arguments, 0).([]interface{}); ok {
+		documents := make([]interface{}, 0, len(ids))
+		for _, id := range ids {
+			if document := ex.db.vertex(toString(id)); document != nil {
+				documents = append(documents, document)
+			}
+		}
+		return documents, nil
+	}
+	return mapOrNil(ex.db.vertex(toString(arg(arguments, 0)))), nil
+}
+
+// ----------------
+// strings
+// ----------------
+
+func fnConcat(ex *execution, arguments []interface{}) (interface{}, error) {
+	var builder strings.Builder
+	for _, argument := range objects(arguments) {
+		builder.WriteString(toString(argument))
+	}
+	return builder.String(), nil
+}
+
+func fnConcatSeparator(ex *execution, arguments []interface{}) (interface{}, error) {
+	parts := make([]string, 0, len(arguments))
+	for _, argument := range objects(arguments[1:]) {
+		if argument != nil {
+			parts = append(parts, toString(argument))
+		}
+	}
+	return strings.Join(parts, toString(arg(arguments, 0))), nil
+}
+
+func fnSubstring(ex *execution, arguments []interface{}) (interface{}, error) {
+	runes := []rune(toString(arg(arguments, 0)))
+	start := int(toNumber(arg(arguments, 1)))
+	if start < 0 {
+		start += len(runes)
+	}
+	start = int(math.Max(0, math.Min(float64(start), float64(len(runes)))))
+
+	end := len(runes)
+	if length := arg(arguments, 2); length != nil {
+		end = int(math.Min(float64(start)+toNumber(length), float64(len(runes))))
+	}
+	if end < start {
+		end = start
+	}
+	return string(runes[start:end]), nil
+}
+
+func fnContains(ex *execution, arguments []interface{}) (interface{}, error) {
+	text, search := toString(arg(arguments, 0)), toString(arg(arguments, 1))
+	if !truthy(arg(arguments, 2)) {
+		return strings.Contains(text, search), nil
+	}
+
+	// the position, in characters
+	index := strings.Index(text, search)
+	if index < 0 {
+		return -1.0, nil
+	}
+	return float64(len([]rune(text[:index]))), nil
+}
+
+func fnStartsWith(ex *execution, arguments []interface{}) (interface{}, error) {
+	return strings.HasPrefix(toString(arg(arguments, 0)), toString(arg(arguments, 1))), nil
+}
+
+func fnLike(ex *execution, arguments []interface{}) (interface{}, error) {
+	return like(toString(arg(arguments, 0)), toString(arg(arguments, 1)), truthy(arg(arguments, 2)))
+}
+
+func fnSplit(ex *execution, arguments []interface{}) (interface{}, error) {
+	parts := strings.Split(toString(arg(arguments, 0)), toString(arg(arguments, 1)))
+	values := make([]interface{}, len(parts))
+	for i, part := range parts {
+		values[i] = part
+	}
+	return values, nil
+}
+
+func fnRegexTest(ex *execution, arguments []interface{}) (interface{}, error) {
+	pattern := toString(arg(arguments, 1))
+	if truthy(arg(arguments, 2)) {
+		pattern = "(?i)" + pattern
+	}
+	matcher, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, queryError(1575, "invalid regular expression %s", pattern)
+	}
+	return matcher.MatchString(toString(arg(arguments, 0))), nil
+}
+
+// ----------------
+// geo, with points as [longitude, latitude] like GeoJSON
+// ----------------
+
+const earthRadius = 6371000.0
+
+func fnGeoPoint(ex *execution, arguments []interface{}) (interface{}, error) {
+	return map[string]interface{}{
+		"type":        "Point",
+		"coordinates": []interface{}{toNumber(arg(arguments, 0)), toNumber(arg(arguments, 1))},
+	}, nil
+}
+
+// coordinates of a GeoJSON point or a [longitude, latitude] pair
+func coordinates(value interface{}) (float64, float64, bool) {
+	if object, ok := value.(map[string]interface{}); ok {
+		value = object["coordinates"]
+	}
+	pair, ok := value.([]interface{})
+	if !ok || len(pair) < 2 {
+		return 0, 0, false
+	}
+	return toNumber(pair[0]), toNumber(pair[1]), true
+}
+
+func fnGeoDistance(ex *execution, arguments []interface{}) (interface{}, error) {
+	lon1, lat1, ok1 := coordinates(arg(arguments, 0))
+	lon2, lat2, ok2 := coordinates(arg(arguments, 1))
+	if !ok1 || !ok2 {
+		return nil, nil
+	}
+
+	// haversine
+	radians := math.Pi / 180
+	dLat := (lat2 - lat1) * radians
+	dLon := (lon2 - lon1) * radians
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*radians)*math.Cos(lat2*radians)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return number(2 * earthRadius * math.Asin(math.Sqrt(a))), nil
+}
+
+// fnGeoContains checks whether a polygon contains a point, by ray casting on its outer ring
+func fnGeoContains(ex *execution, arguments []interface{}) (interface{}, error) {
+	polygon, ok := arg(arguments, 0).(map[string]interface{})
+	if !ok {
+		return nil, nil
+	}
+	rings, ok := polygon["coordinates"].([]interface{})
+	if !ok || len(rings) == 0 {
+		return nil, nil
+	}
+	ring, _ := rings[0].([]interface{})
+
+	x, y, ok := coordinates(arg(arguments, 1))
+	if !ok {
+		return nil, nil
+	}
+
+	inside := false
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		xi, yi, _ := coordinates(ring[i])
+		xj, yj, _ := coordinates(ring[j])
+		if (yi > y) != (yj > y) && x < (xj-xi)*(y-yi)/(yj-yi)+xi {
+			inside = !inside
+		}
+	}
+	return inside, nil
+}
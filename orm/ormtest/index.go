@@ -0,0 +1,257 @@
+package ormtest
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/arangodb/go-driver"
+)
+
+// -------------------------------------
+// Indexes. Only unique ones change behavior (writes that would break them fail), the
+// rest are kept so index management can be tested.
+// -------------------------------------
+
+type Index struct {
+	collection  *Collection
+	id          string
+	name        string
+	kind        driver.IndexType
+	fields      []string
+	unique      bool
+	sparse      bool
+	geoJSON     bool
+	minLength   int
+	expireAfter int
+}
+
+func (c *Index) Name() string {
+	return c.id
+}
+
+func (c *Index) ID() string {
+	return c.collection.name + "/" + c.id
+}
+
+func (c *Index) UserName() string {
+	return c.name
+}
+
+func (c *Index) Type() driver.IndexType {
+	return c.kind
+}
+
+func (c *Index) Remove(ctx context.Context) error {
+	db := c.collection.db
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if c.kind == driver.PrimaryIndex || c.kind == driver.EdgeIndex {
+		return arangoError(403, 1212, "cannot drop a system index")
+	}
+
+	indexes := make([]*Index, 0, len(c.collection.indexes))
+	found := false
+	for _, index := range c.collection.indexes {
+		if index == c {
+			found = true
+			continue
+		}
+		indexes = append(indexes, index)
+	}
+	if !found {
+		return arangoError(404, 1212, "index not found")
+	}
+	c.collection.indexes = indexes
+	return nil
+}
+
+func (c *Index) Fields() []string {
+	return c.fields
+}
+
+func (c *Index) Unique() bool {
+	return c.unique
+}
+
+func (c *Index) Deduplicate() bool {
+	return true
+}
+
+func (c *Index) Sparse() bool {
+	return c.sparse
+}
+
+func (c *Index) GeoJSON() bool {
+	return c.geoJSON
+}
+
+func (c *Index) InBackground() bool {
+	return false
+}
+
+func (c *Index) Estimates() bool {
+	return c.kind == driver.PersistentIndex || c.kind == driver.PrimaryIndex || c.kind == driver.EdgeIndex
+}
+
+func (c *Index) MinLength() int {
+	return c.minLength
+}
+
+func (c *Index) ExpireAfter() int {
+	return c.expireAfter
+}
+
+// values are the indexed values of a document, false when a sparse index leaves it out
+func (c *Index) values(document map[string]interface{}) ([]interface{}, bool) {
+	values := make([]interface{}, len(c.fields))
+	for i, field := range c.fields {
+		var value interface{} = document
+		for _, name := range strings.Split(field, ".") {
+			object, _ := value.(map[string]interface{})
+			value = object[name]
+		}
+		if value == nil && c.sparse {
+			return nil, false
+		}
+		values[i] = value
+	}
+	return values, true
+}
+
+func (c *Index) sameDefinition(other *Index) bool {
+	return c.kind == other.kind && reflect.DeepEqual(c.fields, other.fields) && c.unique == other.unique &&
+		c.sparse == other.sparse && c.geoJSON == other.geoJSON && c.minLength == other.minLength &&
+		c.expireAfter == other.expireAfter
+}
+
+// ----------------
+// driver.Collection indexes
+// ----------------
+
+func (c *Collection) Index(ctx context.Context, name string) (driver.Index, error) {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+
+	for _, index := range c.indexes {
+		if index.id == name || index.name == name || index.ID() == name {
+			return index, nil
+		}
+	}
+	return nil, arangoError(404, 1212, "index not found")
+}
+
+func (c *Collection) IndexExists(ctx context.Context, name string) (bool, error) {
+	_, err := c.Index(ctx, name)
+	if driver.IsNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (c *Collection) Indexes(ctx context.Context) ([]driver.Index, error) {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+
+	indexes := make([]driver.Index, len(c.indexes))
+	for i, index := range c.indexes {
+		indexes[i] = index
+	}
+	return indexes, nil
+}
+
+// ensureIndex returns the index with the same definition, or creates it
+func (c *Collection) ensureIndex(wanted *Index) (driver.Index, bool, error) {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+
+	for _, index := range c.indexes {
+		if index.sameDefinition(wanted) && (wanted.name == "" || wanted.name == index.name) {
+			return index, false, nil
+		}
+		if wanted.name != "" && index.name == wanted.name {
+			return nil, false, arangoError(409, 1207, "duplicate value: index %s already exists with another definition", wanted.name)
+		}
+	}
+
+	if wanted.unique {
+		seen := make(map[string]bool)
+		for _, key := range c.keys {
+			values, indexed := wanted.values(c.documents[key])
+			if !indexed {
+				continue
+			}
+			id := identity(values)
+			if seen[id] {
+				return nil, false, arangoError(409, 1210, "unique constraint violated")
+			}
+			seen[id] = true
+		}
+	}
+
+	c.index++
+	wanted.collection = c
+	wanted.id = strconv.Itoa(c.index)
+	if wanted.name == "" {
+		wanted.name = "idx_" + wanted.id
+	}
+	c.indexes = append(c.indexes, wanted)
+	return wanted, true, nil
+}
+
+func (c *Collection) EnsureFullTextIndex(ctx context.Context, fields []string, options *driver.EnsureFullTextIndexOptions) (driver.Index, bool, error) {
+	index := &Index{kind: driver.FullTextIndex, fields: fields, sparse: true}
+	if options != nil {
+		index.name, index.minLength = options.Name, options.MinLength
+	}
+	return c.ensureIndex(index)
+}
+
+func (c *Collection) EnsureGeoIndex(ctx context.Context, fields []string, options *driver.EnsureGeoIndexOptions) (driver.Index, bool, error) {
+	index := &Index{kind: driver.GeoIndex, fields: fields, sparse: true}
+	if options != nil {
+		index.name, index.geoJSON = options.Name, options.GeoJSON
+	}
+	return c.ensureIndex(index)
+}
+
+// EnsureHashIndex makes a persistent index, like arangodb does these days
+func (c *Collection) EnsureHashIndex(ctx context.Context, fields []string, options *driver.EnsureHashIndexOptions) (driver.Index, bool, error) {
+	index := &Index{kind: driver.PersistentIndex, fields: fields}
+	if options != nil {
+		index.name, index.unique, index.sparse = options.Name, options.Unique, options.Sparse
+	}
+	return c.ensureIndex(index)
+}
+
+func (c *Collection) EnsurePersistentIndex(ctx context.Context, fields []string, options *driver.EnsurePersistentIndexOptions) (driver.Index, bool, error) {
+	index := &Index{kind: driver.PersistentIndex, fields: fields}
+	if options != nil {
+		index.name, index.unique, index.sparse = options.Name, options.Unique, options.Sparse
+	}
+	return c.ensureIndex(index)
+}
+
+// EnsureSkipListIndex makes a persistent index, like arangodb does these days
+func (c *Collection) EnsureSkipListIndex(ctx context.Context, fields []string, options *driver.EnsureSkipListIndexOptions) (driver.Index, bool, error) {
+	index := &Index{kind: driver.PersistentIndex, fields: fields}
+	if options != nil {
+		index.name, index.unique, index.sparse = options.Name, options.Unique, options.Sparse
+	}
+	return c.ensureIndex(index)
+}
+
+// EnsureTTLIndex keeps the index, but documents don't expire
+func (c *Collection) EnsureZKDIndex(ctx context.Context, fields []string, options *driver.EnsureZKDIndexOptions) (driver.Index, bool, error) {
+	return nil, false, notSupported("EnsureZKDIndex")
+}
+
+func (c *Collection) EnsureTTLIndex(ctx context.Context, field string, expireAfter int, options *driver.EnsureTTLIndexOptions) (driver.Index, bool, error) {
+	index := &Index{kind: driver.TTLIndex, fields: []string{field}, sparse: true, expireAfter: expireAfter}
+	if options != nil {
+		index.name = options.Name
+	}
+	return c.ensureIndex(index)
+}
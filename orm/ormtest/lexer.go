@@ -0,0 +1,229 @@
+package ormtest
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// -------------------------------------
+// Tokenizer for the AQL subset
+// -------------------------------------
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdentifier
+	tokenNumber
+	tokenString
+	tokenBindVar        // @name
+	tokenBindCollection // @@name
+	tokenOperator
+)
+
+type token struct {
+	kind   tokenKind
+	text   string
+	number float64
+	pos    int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokenEOF:
+		return "end of query"
+	case tokenString:
+		return strconv.Quote(t.text)
+	case tokenBindVar:
+		return "@" + t.text
+	case tokenBindCollection:
+		return "@@" + t.text
+	}
+	return t.text
+}
+
+// is checks for an operator, or a keyword (case insensitively)
+func (t token) is(text string) bool {
+	switch t.kind {
+	case tokenOperator:
+		return t.text == text
+	case tokenIdentifier:
+		return strings.EqualFold(t.text, text)
+	}
+	return false
+}
+
+// operators, longest first so "==" wins over "="
+var operators = []string{
+	"[*]", "..", "==", "!=", "<=", ">=", "&&", "||", "=~", "!~", "::",
+	"<", ">", "=", "!", "+", "-", "*", "/", "%", "?", ":", ",", ".", "(", ")", "[", "]", "{", "}",
+}
+
+func tokenize(query string) ([]token, error) {
+	tokens := make([]token, 0)
+	runes := []rune(query)
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+
+		switch {
+		case unicode.IsSpace(r):
+			i++
+
+		case r == '/' && i+1 < len(runes) && runes[i+1] == '/':
+			for i < len(runes) && runes[i] != '\n' {
+				i++
+			}
+
+		case r == '/' && i+1 < len(runes) && runes[i+1] == '*':
+			start := i
+			i += 2
+			for i+1 < len(runes) && !(runes[i] == '*' && runes[i+1] == '/') {
+				i++
+			}
+			if i+1 >= len(runes) {
+				return nil, fmt.Errorf("unterminated comment at %d", start)
+			}
+			i += 2
+
+		case r == '"' || r == '\'':
+			text, next, err := readString(runes, i)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, token{kind: tokenString, text: text, pos: i})
+			i = next
+
+		case r == '`' || r == '´':
+			end := i + 1
+			for end < len(runes) && runes[end] != r {
+				end++
+			}
+			if end >= len(runes) {
+				return nil, fmt.Errorf("unterminated name at %d", i)
+			}
+			tokens = append(tokens, token{kind: tokenIdentifier, text: string(runes[i+1 : end]), pos: i})
+			i = end + 1
+
+		case unicode.IsDigit(r):
+			start := i
+			for i < len(runes) && unicode.IsDigit(runes[i]) {
+				i++
+			}
+			// a fraction, but not a range like 1..3
+			if i+1 < len(runes) && runes[i] == '.' && unicode.IsDigit(runes[i+1]) {
+				i++
+				for i < len(runes) && unicode.IsDigit(runes[i]) {
+					i++
+				}
+			}
+			if i < len(runes) && (runes[i] == 'e' || runes[i] == 'E') {
+				i++
+				if i < len(runes) && (runes[i] == '+' || runes[i] == '-') {
+					i++
+				}
+				for i < len(runes) && unicode.IsDigit(runes[i]) {
+					i++
+				}
+			}
+			value, err := strconv.ParseFloat(string(runes[start:i]), 64)
+			if err != nil {
+				return nil, fmt.Errorf("bad number %s at %d", string(runes[start:i]), start)
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: string(runes[start:i]), number: value, pos: start})
+
+		case r == '@':
+			kind := tokenBindVar
+			start := i
+			i++
+			if i < len(runes) && runes[i] == '@' {
+				kind = tokenBindCollection
+				i++
+			}
+			nameStart := i
+			for i < len(runes) && isNameRune(runes[i]) {
+				i++
+			}
+			if nameStart == i {
+				return nil, fmt.Errorf("bind parameter without a name at %d", start)
+			}
+			tokens = append(tokens, token{kind: kind, text: string(runes[nameStart:i]), pos: start})
+
+		case isNameRune(r):
+			start := i
+			for i < len(runes) && isNameRune(runes[i]) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenIdentifier, text: string(runes[start:i]), pos: start})
+
+		default:
+			matched := false
+			for _, operator := range operators {
+				if strings.HasPrefix(string(runes[i:min(i+len(operator), len(runes))]), operator) {
+					tokens = append(tokens, token{kind: tokenOperator, text: operator, pos: i})
+					i += len(operator)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("unexpected character %q at %d", r, i)
+			}
+		}
+	}
+
+	return append(tokens, token{kind: tokenEOF, pos: len(runes)}), nil
+}
+
+func isNameRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func readString(runes []rune, start int) (string, int, error) {
+	quote := runes[start]
+	var builder strings.Builder
+
+	for i := start + 1; i < len(runes); i++ {
+		r := runes[i]
+		if r == quote {
+			return builder.String(), i + 1, nil
+		}
+		if r != '\\' {
+			builder.WriteRune(r)
+			continue
+		}
+
+		i++
+		if i >= len(runes) {
+			break
+		}
+		switch runes[i] {
+		case 'n':
+			builder.WriteRune('\n')
+		case 'r':
+			builder.WriteRune('\r')
+		case 't':
+			builder.WriteRune('\t')
+		case 'b':
+			builder.WriteRune('\b')
+		case 'f':
+			builder.WriteRune('\f')
+		case 'u':
+			if i+4 >= len(runes) {
+				return "", 0, fmt.Errorf("bad unicode escape at %d", i)
+			}
+			code, err := strconv.ParseUint(string(runes[i+1:i+5]), 16, 32)
+			if err != nil {
+				return "", 0, fmt.Errorf("bad unicode escape at %d", i)
+			}
+			builder.WriteRune(rune(code))
+			i += 4
+		default:
+			builder.WriteRune(runes[i])
+		}
+	}
+
+	return "", 0, fmt.Errorf("unterminated string at %d", start)
+}
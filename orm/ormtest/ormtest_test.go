@@ -0,0 +1,927 @@
+package ormtest
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/arangodb/go-driver"
+	"github.com/houqp/gtest"
+	"github.com/ridelabs/simply_arango/orm"
+	"github.com/stretchr/testify/assert"
+)
+
+type Person struct {
+	Id             string `json:"id"`
+	Rev            string `json:"_rev,omitempty"`
+	Name           string `json:"name"`
+	Team           string `json:"team"`
+	Age            int    `json:"age"`
+	Counter        int    `json:"counter"`
+	ManagerId      string `json:"manager_id,omitempty"`
+	OrganizationId string `json:"organization_id"`
+}
+
+type Signup struct {
+	Id    string `json:"id"`
+	Rev   string `json:"_rev,omitempty"`
+	Name  string `json:"name" validate:"required,max=10"`
+	Email string `json:"email" validate:"omitempty,email"`
+	Plan  string `json:"plan" validate:"oneof=free pro"`
+}
+
+type Account struct {
+	Id             string `json:"id"`
+	Email          string `json:"email"`
+	Domain         string `json:"domain"`
+	OrganizationId string `json:"organization_id"`
+	ReferrerId     string `json:"referrer_id,omitempty"`
+	Loaded         bool   `json:"-"`
+}
+
+func (c *Account) BeforeCreate(ctx context.Context) error {
+	c.Email = strings.ToLower(c.Email)
+	_, c.Domain, _ = strings.Cut(c.Email, "@")
+	return nil
+}
+
+func (c *Account) BeforeUpdate(ctx context.Context) error {
+	return c.BeforeCreate(ctx)
+}
+
+func (c *Account) BeforeDelete(ctx context.Context) error {
+	if c.Domain == "example.com" {
+		return errors.New("example accounts stay")
+	}
+	return nil
+}
+
+func (c *Account) AfterRead(ctx context.Context) error {
+	c.Loaded = true
+	return nil
+}
+
+type Referral struct {
+	Id    string `json:"id"`
+	From  string `json:"_from"`
+	To    string `json:"_to"`
+	Stamp string `json:"stamp"`
+}
+
+func (c *Referral) BeforeCreate(ctx context.Context) error {
+	c.Stamp = "referred"
+	return nil
+}
+
+type OrmtestTests struct {
+	conn   *orm.Connection
+	people *orm.Collection
+	ids    map[string]string
+}
+
+func (s *OrmtestTests) Setup(t *testing.T) {}
+
+func (s *OrmtestTests) Teardown(t *testing.T) {}
+
+func (s *OrmtestTests) BeforeEach(t *testing.T) {
+	ctx := context.TODO()
+
+	s.conn = NewConnection()
+	s.people = &orm.Collection{
+		Connection: s.conn,
+		TableName:  "people",
+		AllocateRecord: func() interface{} {
+			return &Person{}
+		},
+	}
+	assert.Nil(t, s.people.Initialize(ctx))
+
+	s.ids = make(map[string]string)
+	for _, person := range []*Person{
+		{Name: "ann", Team: "red", Age: 31, OrganizationId: "8675309"},
+		{Name: "bob", Team: "blue", Age: 25, OrganizationId: "8675309"},
+		{Name: "cat", Team: "red", Age: 42, OrganizationId: "8675309"},
+		{Name: "dan", Team: "blue", Age: 19, OrganizationId: "8675309"},
+		{Name: "eve", Team: "red", Age: 25, OrganizationId: "5551212"},
+	} {
+		id, err := s.people.Create(ctx, person)
+		assert.Nil(t, err)
+		s.ids[person.Name] = id
+	}
+}
+
+func (s *OrmtestTests) AfterEach(t *testing.T) {}
+
+func names(records []interface{}) []string {
+	found := make([]string, len(records))
+	for i, record := range records {
+		found[i] = record.(*Person).Name
+	}
+	return found
+}
+
+func (s *OrmtestTests) SubTestQuery(t *testing.T) {
+	ctx := context.TODO()
+
+	records, err := s.people.Query().WithinOrg("8675309").Filter("team", "red").List().All(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"ann", "cat"}, names(records))
+	assert.Equal(t, s.ids["ann"], records[0].(*Person).Id)
+
+	records, err = s.people.Query().WithinOrg("8675309").List().OrderBy("age").Desc().Paging(2, 1).All(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"bob", "dan"}, names(records))
+
+	filter := s.people.Query()
+	op := filter.Operator()
+	records, err = filter.Where(op.Or(op.GreaterThan("age", op.MakeVariableIfNative(40)), op.Equal("name", "dan"))).
+		List().OrderBy("name").Asc().All(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"cat", "dan"}, names(records))
+
+	count, err := s.people.Query().WithinOrg("8675309").Count(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, count)
+
+	record, err := s.people.Get(ctx, s.ids["eve"])
+	assert.Nil(t, err)
+	assert.Equal(t, "5551212", record.(*Person).OrganizationId)
+
+	_, err = s.people.Get(ctx, "nobody")
+	assert.True(t, orm.IsNotFound(err))
+}
+
+func (s *OrmtestTests) SubTestModify(t *testing.T) {
+	ctx := context.TODO()
+
+	record, err := s.people.Get(ctx, s.ids["ann"])
+	assert.Nil(t, err)
+	ann := record.(*Person)
+	ann.Team = "green"
+	assert.Nil(t, s.people.Update(ctx, ann))
+
+	// ann's revision moved on, so updating with the one read earlier conflicts
+	stale := *record.(*Person)
+	stale.Rev = "_stale"
+	assert.True(t, orm.IsConflict(s.people.Update(ctx, &stale)))
+
+	updated, err := s.people.Query().Filter("team", "blue").UpdateAll(ctx, map[string]interface{}{"team": "green"})
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []string{s.ids["bob"], s.ids["dan"]}, updated)
+
+	assert.Nil(t, s.people.Increment(ctx, &Person{Id: s.ids["bob"], OrganizationId: "8675309"}, "counter"))
+	record, err = s.people.Get(ctx, s.ids["bob"])
+	assert.Nil(t, err)
+	assert.Equal(t, 1, record.(*Person).Counter)
+	assert.Equal(t, "green", record.(*Person).Team)
+
+	removed, err := s.people.Query().Filter("team", "green").DeleteAll(ctx)
+	assert.Nil(t, err)
+	assert.Len(t, removed, 3)
+
+	assert.Nil(t, s.people.Delete(ctx, &Person{Id: s.ids["cat"]}))
+	count, err := s.people.Query().Count(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, count)
+}
+
+func (s *OrmtestTests) SubTestUpsert(t *testing.T) {
+	ctx := context.TODO()
+
+	key, inserted, err := s.people.Query().WithinOrg("8675309").Filter("name", "fay").
+		Upsert(ctx, &Person{Team: "red"}, map[string]interface{}{"team": "blue"})
+	assert.Nil(t, err)
+	assert.True(t, inserted)
+
+	again, inserted, err := s.people.Query().WithinOrg("8675309").Filter("name", "fay").
+		Upsert(ctx, &Person{Team: "red"}, map[string]interface{}{"team": "blue"})
+	assert.Nil(t, err)
+	assert.False(t, inserted)
+	assert.Equal(t, key, again)
+
+	record, err := s.people.Get(ctx, key)
+	assert.Nil(t, err)
+	assert.Equal(t, "blue", record.(*Person).Team)
+}
+
+func (s *OrmtestTests) SubTestAggregate(t *testing.T) {
+	type teamStats struct {
+		Team    string   `json:"team"`
+		People  int      `json:"people"`
+		Oldest  int      `json:"oldest"`
+		AvgAge  float64  `json:"avg_age"`
+		Members []string `json:"members"`
+	}
+
+	stats := make([]teamStats, 0)
+	err := s.people.Query().WithinOrg("8675309").Aggregate().GroupBy("team").Count("people").
+		Max("oldest", "age").Avg("avg_age", "age").Push("members", "name").All(context.TODO(), &stats)
+	assert.Nil(t, err)
+	assert.Equal(t, []teamStats{
+		{Team: "blue", People: 2, Oldest: 25, AvgAge: 22, Members: []string{"bob", "dan"}},
+		{Team: "red", People: 2, Oldest: 42, AvgAge: 36.5, Members: []string{"ann", "cat"}},
+	}, stats)
+}
+
+func (s *OrmtestTests) SubTestInclude(t *testing.T) {
+	ctx := context.TODO()
+
+	type withManager struct {
+		Person
+		Manager *Person `json:"manager"`
+	}
+
+	_, err := s.people.Query().ById(s.ids["bob"]).UpdateAll(ctx, map[string]interface{}{"manager_id": s.ids["cat"]})
+	assert.Nil(t, err)
+
+	record, err := s.people.Query().ById(s.ids["bob"]).List().Include("manager_id", s.people, "manager").
+		As(func() interface{} { return &withManager{} }).First(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, "bob", record.(*withManager).Name)
+	assert.Equal(t, "cat", record.(*withManager).Manager.Name)
+	assert.Equal(t, s.ids["cat"], record.(*withManager).Manager.Id)
+}
+
+func (s *OrmtestTests) SubTestKeysetPagination(t *testing.T) {
+	ctx := context.TODO()
+
+	seen := make([]string, 0)
+	token := ""
+	for page := 0; page < 5; page++ {
+		items := s.people.Query().List().OrderBy("age").Asc().Limit(2).After(token)
+		records, err := items.All(ctx)
+		assert.Nil(t, err)
+		seen = append(seen, names(records)...)
+
+		if token = items.PageToken(); token == "" {
+			break
+		}
+	}
+
+	// bob and eve are both 25, the _key tiebreak (the keys are random) keeps either from being skipped or repeated
+	assert.Len(t, seen, 5)
+	assert.Equal(t, "dan", seen[0])
+	assert.ElementsMatch(t, []string{"bob", "eve"}, seen[1:3])
+	assert.Equal(t, []string{"ann", "cat"}, seen[3:])
+}
+
+func (s *OrmtestTests) SubTestTraversal(t *testing.T) {
+	ctx := context.TODO()
+
+	reports := &orm.EdgeCollection{Collection: &orm.Collection{Connection: s.conn, TableName: "reports_to"}}
+	assert.Nil(t, reports.Initialize(ctx))
+
+	for _, pair := range [][2]string{{"dan", "bob"}, {"bob", "cat"}, {"ann", "cat"}} {
+		_, err := reports.Connect(ctx, s.people.Handle(s.ids[pair[0]]), s.people.Handle(s.ids[pair[1]]), nil)
+		assert.Nil(t, err)
+	}
+
+	records, err := s.people.Query().ById(s.ids["dan"]).Traverse(reports).Depth(1, 3).All(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"bob", "cat"}, names(records))
+
+	records, err = s.people.Query().ById(s.ids["cat"]).Traverse(reports).Inbound().All(ctx)
+	assert.Nil(t, err)
+	found := names(records)
+	sort.Strings(found)
+	assert.Equal(t, []string{"ann", "bob"}, found)
+
+	_, err = reports.Create(ctx, map[string]interface{}{"id": "x", "_from": "people/1"})
+	assert.NotNil(t, err, "edges need both handles")
+}
+
+func (s *OrmtestTests) SubTestTransactions(t *testing.T) {
+	ctx := context.TODO()
+	failure := errors.New("changed my mind")
+
+	err := s.conn.RunInTransaction(ctx, nil, []string{"people"}, func(ctx context.Context) error {
+		if _, err := s.people.Create(ctx, &Person{Name: "gus", OrganizationId: "8675309"}); err != nil {
+			return err
+		}
+		if _, err := s.people.Query().DeleteAll(ctx); err != nil {
+			return err
+		}
+		return failure
+	})
+	assert.Equal(t, failure, err)
+
+	count, err := s.people.Query().Count(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, count, "the aborted transaction left nothing behind")
+
+	err = s.conn.RunInTransaction(ctx, nil, []string{"people"}, func(ctx context.Context) error {
+		_, err := s.people.Create(ctx, &Person{Name: "gus", OrganizationId: "8675309"})
+		return err
+	})
+	assert.Nil(t, err)
+
+	count, err = s.people.Query().Count(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 6, count)
+
+	// aborting a transaction only undoes its own writes, not the ones made outside it meanwhile
+	db := s.conn.Database
+	other, err := db.BeginTransaction(ctx, driver.TransactionCollections{Write: []string{"people"}}, nil)
+	assert.Nil(t, err)
+	err = s.conn.RunInTransaction(ctx, nil, []string{"people"}, func(txCtx context.Context) error {
+		if _, err := s.people.Create(txCtx, &Person{Name: "hal", OrganizationId: "8675309"}); err != nil {
+			return err
+		}
+		if _, err := s.people.Query().ById(s.ids["ann"]).UpdateAll(txCtx, map[string]interface{}{"team": "green"}); err != nil {
+			return err
+		}
+		if _, err := s.people.Create(ctx, &Person{Name: "ivy", OrganizationId: "8675309"}); err != nil {
+			return err
+		}
+		if _, err := s.people.Create(driver.WithTransactionID(ctx, other), &Person{Name: "jon", OrganizationId: "8675309"}); err != nil {
+			return err
+		}
+		if err := s.people.Delete(txCtx, &Person{Id: s.ids["bob"]}); err != nil {
+			return err
+		}
+		return failure
+	})
+	assert.Equal(t, failure, err)
+	assert.Nil(t, db.CommitTransaction(ctx, other, nil))
+
+	people, err := s.people.Query().List().All(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"ann", "bob", "cat", "dan", "eve", "gus", "ivy", "jon"}, names(people))
+	assert.Equal(t, "red", people[0].(*Person).Team)
+
+	collection, err := db.Collection(ctx, "people")
+	assert.Nil(t, err)
+	assert.NotNil(t, collection.Truncate(driver.WithTransactionID(ctx, other)), "the transaction is over")
+}
+
+func (s *OrmtestTests) SubTestNotSupported(t *testing.T) {
+	ctx := context.TODO()
+
+	view := &orm.SearchView{Name: "people_view", Collection: s.people, Fields: map[string][]string{"name": nil}}
+	assert.ErrorIs(t, view.Initialize(ctx), ErrNotSupported)
+
+	exists, err := s.conn.Database.GraphExists(ctx, "org_chart")
+	assert.Nil(t, err)
+	assert.False(t, exists)
+	_, err = s.conn.Database.CreateGraphV2(ctx, "org_chart", nil)
+	assert.ErrorIs(t, err, ErrNotSupported)
+}
+
+func (s *OrmtestTests) SubTestBulk(t *testing.T) {
+	ctx := context.TODO()
+
+	results, err := s.people.CreateMany(ctx, []interface{}{&Person{Name: "hal"}, &Person{Name: "ivy"}})
+	assert.Nil(t, err)
+	assert.Nil(t, results.Err())
+
+	results, err = s.people.DeleteMany(ctx, []interface{}{&Person{Id: results[0].Key}, &Person{Id: "nobody"}})
+	assert.Nil(t, err)
+	assert.Nil(t, results[0].Err)
+	assert.True(t, driver.IsNotFound(results[1].Err))
+}
+
+func (s *OrmtestTests) SubTestUniqueIndex(t *testing.T) {
+	ctx := context.TODO()
+
+	s.people.Indexes = []orm.IndexSpec{{Fields: []string{"name"}, Unique: true}}
+	assert.Nil(t, s.people.Initialize(ctx))
+	assert.Equal(t, []string{s.people.Indexes[0].IndexName()}, s.people.LastIndexDiff.Created)
+	assert.Equal(t, []string{orm.OrganizationIndexName}, s.people.LastIndexDiff.Unchanged)
+
+	_, err := s.people.Create(ctx, &Person{Name: "ann"})
+	assert.True(t, driver.IsConflict(err))
+
+	// skiplists are made as persistent indexes
+	s.people.Indexes = append(s.people.Indexes, orm.IndexSpec{Kind: orm.IndexKind(driver.SkipListIndex), Fields: []string{"age"}})
+	assert.Nil(t, s.people.Initialize(ctx))
+	assert.Equal(t, []string{s.people.Indexes[1].IndexName()}, s.people.LastIndexDiff.Created)
+
+	assert.Nil(t, s.people.Initialize(ctx))
+	assert.True(t, s.people.LastIndexDiff.InSync())
+}
+
+func (s *OrmtestTests) SubTestQueryErrors(t *testing.T) {
+	ctx := context.TODO()
+
+	_, err := s.conn.Database.Query(ctx, "FOR doc IN @@collection RETURN", map[string]interface{}{"@collection": "people"})
+	assert.True(t, driver.IsArangoErrorWithErrorNum(err, 1501))
+
+	_, err = s.conn.Database.Query(ctx, "FOR doc IN @@collection RETURN doc", map[string]interface{}{"@collection": "nope"})
+	assert.True(t, driver.IsNotFound(err))
+
+	_, err = s.conn.Database.Query(ctx, "FOR doc IN people FILTER doc.age > @age RETURN doc", nil)
+	assert.True(t, driver.IsArangoErrorWithErrorNum(err, 1552))
+
+	// a failing query doesn't leave half its changes behind
+	_, err = s.conn.Database.Query(ctx, "FOR doc IN people UPDATE doc WITH { age: doc.age + 1 } IN people "+
+		"FILTER NEW.age > 40 INSERT { _key: @key } INTO people", map[string]interface{}{"key": s.ids["ann"]})
+	assert.NotNil(t, err)
+	record, err := s.people.Get(ctx, s.ids["ann"])
+	assert.Nil(t, err)
+	assert.Equal(t, 31, record.(*Person).Age)
+}
+
+func (s *OrmtestTests) SubTestExpressions(t *testing.T) {
+	cursor, err := s.conn.Database.Query(context.TODO(), `
+LET teams = (FOR doc IN people COLLECT team = doc.team WITH COUNT INTO n RETURN { team, n })
+RETURN {
+	teams: teams,
+	names: (FOR doc IN people FILTER doc.name LIKE "_a%" SORT doc.name DESC RETURN UPPER(doc.name)),
+	ages: SORTED_UNIQUE(people[*].age),
+	range: 1..3,
+	first: FIRST(FOR n IN [3, 1, 2] SORT n LIMIT 1 RETURN n),
+	maths: [7 % 3, 10 / 4, 1 / 0, -2 * 3],
+	ternary: LENGTH(teams) > 1 ? "many" : "one",
+	merged: MERGE({ a: 1 }, UNSET({ b: 2, c: 3 }, "c"))
+}`, nil)
+	assert.Nil(t, err)
+
+	var result map[string]interface{}
+	_, err = cursor.ReadDocument(context.TODO(), &result)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"teams":   []interface{}{map[string]interface{}{"team": "blue", "n": 2.0}, map[string]interface{}{"team": "red", "n": 3.0}},
+		"names":   []interface{}{"DAN", "CAT"},
+		"ages":    []interface{}{19.0, 25.0, 31.0, 42.0},
+		"range":   []interface{}{1.0, 2.0, 3.0},
+		"first":   1.0,
+		"maths":   []interface{}{1.0, 2.5, nil, -6.0},
+		"ternary": "many",
+		"merged":  map[string]interface{}{"a": 1.0, "b": 2.0},
+	}, result)
+
+	assert.False(t, cursor.HasMore())
+	_, err = cursor.ReadDocument(context.TODO(), &result)
+	assert.True(t, driver.IsNoMoreDocuments(err))
+}
+
+func (s *OrmtestTests) SubTestTenants(t *testing.T) {
+	ctx := context.TODO()
+	mine := orm.Typed[Person](s.people).ForOrg("8675309")
+
+	// queries only see the organization's records
+	records, err := mine.Query().Filter("team", "red").List().All(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(records))
+	count, err := mine.Query().Filter("age", 25).Count(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, count)
+
+	// other organizations' records can't be read, updated or deleted
+	_, err = mine.Get(ctx, s.ids["eve"])
+	assert.True(t, orm.IsNotFound(err))
+	eve := &Person{Id: s.ids["eve"], Name: "eve", OrganizationId: "5551212"}
+	assert.ErrorIs(t, mine.Update(ctx, eve), orm.ErrWrongOrg)
+	assert.True(t, orm.IsNotFound(mine.Delete(ctx, eve)))
+
+	eve.OrganizationId = ""
+	assert.True(t, orm.IsNotFound(mine.Update(ctx, eve)), "not even by leaving out the organization")
+	stored, err := orm.Typed[Person](s.people).Get(ctx, s.ids["eve"])
+	assert.Nil(t, err)
+	assert.Equal(t, "5551212", stored.OrganizationId)
+
+	// creates are stamped, and can't be for someone else
+	frank := &Person{Name: "frank", Team: "blue"}
+	id, err := mine.Create(ctx, frank)
+	assert.Nil(t, err)
+	assert.Equal(t, "8675309", frank.OrganizationId)
+	_, err = mine.Create(ctx, &Person{Name: "gus", OrganizationId: "5551212"})
+	assert.ErrorIs(t, err, orm.ErrWrongOrg)
+
+	// and updates can't move records to another organization
+	found, err := mine.Get(ctx, id)
+	assert.Nil(t, err)
+	found.Team = "red"
+	assert.Nil(t, mine.Update(ctx, found))
+	found.OrganizationId = "5551212"
+	assert.ErrorIs(t, mine.Update(ctx, found), orm.ErrWrongOrg)
+
+	found.OrganizationId = ""
+	found.Rev = ""
+	assert.Nil(t, mine.Update(ctx, found))
+	found, err = mine.Get(ctx, id)
+	assert.Nil(t, err)
+	assert.Equal(t, "8675309", found.OrganizationId)
+	assert.Equal(t, "red", found.Team)
+
+	assert.Nil(t, mine.Delete(ctx, found))
+	_, err = mine.Get(ctx, id)
+	assert.True(t, orm.IsNotFound(err))
+
+	// traversals and includes stay inside the organization
+	reports := &orm.EdgeCollection{Collection: &orm.Collection{Connection: s.conn, TableName: "reports_to"}}
+	assert.Nil(t, reports.Initialize(ctx))
+	for _, pair := range [][2]string{{"dan", "bob"}, {"dan", "eve"}, {"eve", "cat"}} {
+		_, err := reports.Connect(ctx, s.people.Handle(s.ids[pair[0]]), s.people.Handle(s.ids[pair[1]]), nil)
+		assert.Nil(t, err)
+	}
+	vertices, err := mine.Query().ById(s.ids["dan"]).Traverse(reports).Depth(1, 2).All(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(vertices), "not eve, nor cat through eve")
+	assert.Equal(t, "bob", vertices[0].Name)
+
+	type withManager struct {
+		Person
+		Manager *Person `json:"manager"`
+	}
+	_, err = s.people.Query().ById(s.ids["bob"]).UpdateAll(ctx, map[string]interface{}{"manager_id": s.ids["eve"]})
+	assert.Nil(t, err)
+	record, err := mine.Query().ById(s.ids["bob"]).List().Include("manager_id", s.people, "manager").
+		As(func() interface{} { return &withManager{} }).First(ctx)
+	assert.Nil(t, err)
+	assert.Nil(t, record.(*withManager).Manager)
+
+	// global collections are still included and walked through
+	type Organization struct {
+		Id   string `json:"id"`
+		Name string `json:"name"`
+	}
+	orgs := &orm.Collection{Connection: s.conn, TableName: "orgs", NoOrganization: true,
+		AllocateRecord: func() interface{} { return &Organization{} }}
+	assert.Nil(t, orgs.Initialize(ctx))
+	orgId, err := orgs.Create(ctx, &Organization{Name: "Acme"})
+	assert.Nil(t, err)
+	acme := orm.Typed[Person](s.people).ForOrg(orgId)
+	_, err = acme.Create(ctx, &Person{Name: "ivy", Team: "red"})
+	assert.Nil(t, err)
+
+	type withOrganization struct {
+		Person
+		Organization *Organization `json:"organization"`
+	}
+	record, err = acme.Query().Filter("name", "ivy").List().Include("organization_id", orgs, "organization").
+		As(func() interface{} { return &withOrganization{} }).First(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, &Organization{Id: orgId, Name: "Acme"}, record.(*withOrganization).Organization)
+
+	teams := &orm.Collection{Connection: s.conn, TableName: "teams", NoOrganization: true,
+		AllocateRecord: func() interface{} { return &map[string]interface{}{} }}
+	assert.Nil(t, teams.Initialize(ctx))
+	red, err := teams.Create(ctx, map[string]interface{}{"name": "red"})
+	assert.Nil(t, err)
+	members := &orm.EdgeCollection{Collection: &orm.Collection{Connection: s.conn, TableName: "member_of"}}
+	assert.Nil(t, members.Initialize(ctx))
+	for _, name := range []string{"ann", "eve"} {
+		_, err := members.Connect(ctx, s.people.Handle(s.ids[name]), teams.Handle(red), nil)
+		assert.Nil(t, err)
+	}
+	joined, err := orm.TraverseInto(mine.Query().ById(s.ids["ann"]).Traverse(members).Any().Depth(1, 2),
+		orm.Typed[map[string]interface{}](teams)).All(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(joined), "the team, but not eve through it")
+	assert.Equal(t, "red", (*joined[0])["name"])
+
+	// writes check the revision in the same statement
+	ann, err := mine.Get(ctx, s.ids["ann"])
+	assert.Nil(t, err)
+	stale := *ann
+	assert.Nil(t, mine.Update(ctx, ann))
+	assert.NotEqual(t, stale.Rev, ann.Rev)
+	assert.True(t, orm.IsConflict(mine.Update(ctx, &stale)))
+	assert.True(t, orm.IsConflict(mine.Delete(ctx, &stale)))
+
+	// records need somewhere to hold the organization, maps are stamped as they are
+	notes := &orm.Collection{Connection: s.conn, TableName: "notes", OrganizationIdKey: "organization_id",
+		AllocateRecord: func() interface{} { return &map[string]interface{}{} }}
+	assert.Nil(t, notes.Initialize(ctx))
+	type untenanted struct {
+		Id   string `json:"id"`
+		Text string `json:"text"`
+	}
+	_, err = notes.ForOrg("8675309").Create(ctx, &untenanted{Text: "lost"})
+	assert.EqualError(t, err, "notes: records of type *ormtest.untenanted have no organization_id field to hold the organization")
+	note := map[string]interface{}{"text": "kept"}
+	_, err = notes.ForOrg("8675309").Create(ctx, note)
+	assert.Nil(t, err)
+	assert.Equal(t, "8675309", note["organization_id"])
+	count, err = notes.ForOrg("8675309").Query().Count(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, count)
+
+	// an empty organization isn't a scope
+	_, err = s.people.Query().WithinOrg("").List().All(ctx)
+	assert.ErrorIs(t, err, orm.ErrUnscopedQuery)
+	_, err = s.people.ForOrg("").Create(ctx, &Person{Name: "hal"})
+	assert.ErrorIs(t, err, orm.ErrUnscopedQuery)
+
+	// strict collections refuse queries that forgot the organization
+	s.people.RequireOrg = true
+	_, err = s.people.Query().Filter("team", "red").List().All(ctx)
+	assert.ErrorIs(t, err, orm.ErrUnscopedQuery)
+	_, err = s.people.Query().DeleteAll(ctx)
+	assert.ErrorIs(t, err, orm.ErrUnscopedQuery)
+	_, err = s.people.Query().Count(ctx)
+	assert.EqualError(t, err, "people: query isn't scoped to an organization")
+
+	count, err = s.people.Query().WithinOrg("5551212").Count(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, count)
+	count, err = mine.Query().Count(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, count)
+}
+
+func (s *OrmtestTests) SubTestTenantBulkWrites(t *testing.T) {
+	ctx := context.TODO()
+	people := orm.Typed[Person](s.people)
+	mine := people.ForOrg("8675309")
+
+	// creates are stamped, and records of someone else are refused
+	results, err := mine.CreateMany(ctx, []*Person{{Name: "jo"}, {Name: "ken", OrganizationId: "5551212"}})
+	assert.Nil(t, err)
+	assert.Nil(t, results[0].Err)
+	assert.ErrorIs(t, results[1].Err, orm.ErrWrongOrg)
+	jo, err := people.Get(ctx, results[0].Key)
+	assert.Nil(t, err)
+	assert.Equal(t, "8675309", jo.OrganizationId)
+
+	// updates only reach the organization's documents, even without an organization on the record
+	ann, err := mine.Get(ctx, s.ids["ann"])
+	assert.Nil(t, err)
+	ann.Team = "green"
+	eve := &Person{Id: s.ids["eve"], Name: "eve", Team: "green"}
+	results, err = mine.UpdateMany(ctx, []*Person{ann, eve, {Name: "no id"}})
+	assert.Nil(t, err)
+	assert.Nil(t, results[0].Err)
+	assert.True(t, orm.IsNotFound(results[1].Err))
+	assert.EqualError(t, results[2].Err, "document must have an actual id")
+	stored, err := people.Get(ctx, s.ids["eve"])
+	assert.Nil(t, err)
+	assert.Equal(t, "red", stored.Team)
+	assert.Equal(t, "5551212", stored.OrganizationId)
+
+	results, err = mine.ReplaceMany(ctx, []*Person{{Id: s.ids["eve"], Name: "mallory"}})
+	assert.Nil(t, err)
+	assert.True(t, orm.IsNotFound(results[0].Err))
+
+	// deletes and increments too
+	results, err = mine.DeleteMany(ctx, []*Person{{Id: s.ids["eve"]}, {Id: s.ids["dan"]}})
+	assert.Nil(t, err)
+	assert.True(t, orm.IsNotFound(results[0].Err))
+	assert.Nil(t, results[1].Err)
+	_, err = people.Get(ctx, s.ids["eve"])
+	assert.Nil(t, err)
+	_, err = people.Get(ctx, s.ids["dan"])
+	assert.True(t, orm.IsNotFound(err))
+
+	assert.True(t, orm.IsNotFound(mine.Increment(ctx, &Person{Id: s.ids["eve"]}, "counter")))
+	assert.Nil(t, mine.Increment(ctx, &Person{Id: s.ids["ann"]}, "counter"))
+	stored, err = people.Get(ctx, s.ids["eve"])
+	assert.Nil(t, err)
+	assert.Equal(t, 0, stored.Counter)
+	stored, err = people.Get(ctx, s.ids["ann"])
+	assert.Nil(t, err)
+	assert.Equal(t, 1, stored.Counter)
+}
+
+func (s *OrmtestTests) SubTestAtomicUpdates(t *testing.T) {
+	ctx := context.TODO()
+
+	record, err := s.people.Atomic(s.ids["ann"]).Inc("counter", 2).Max("age", 35).
+		Push("tags", "a", "b").Set("stats.views", 1).One(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, record.(*Person).Counter)
+	assert.Equal(t, 35, record.(*Person).Age)
+
+	record, err = s.people.Atomic(s.ids["ann"]).Dec("counter", 1).Min("age", 40).AddToSet("tags", "b", "c").
+		Inc("stats.views", 1).Unset("team").One(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, record.(*Person).Counter)
+	assert.Equal(t, 35, record.(*Person).Age)
+	assert.Equal(t, "", record.(*Person).Team)
+	_, err = s.people.Atomic(s.ids["ann"]).Pull("tags", "a").One(ctx)
+	assert.Nil(t, err)
+
+	collection, err := s.conn.Database.Collection(ctx, "people")
+	assert.Nil(t, err)
+	doc := make(map[string]interface{})
+	_, err = collection.ReadDocument(ctx, s.ids["ann"], &doc)
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{"b", "c"}, doc["tags"])
+	assert.Equal(t, map[string]interface{}{"views": float64(2)}, doc["stats"])
+	assert.NotContains(t, doc, "team")
+
+	// filters update every match
+	records, err := s.people.Query().WithinOrg("8675309").Filter("team", "blue").Atomic().Inc("counter", 5).All(ctx)
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []string{"bob", "dan"}, names(records))
+	assert.Equal(t, 5, records[0].(*Person).Counter)
+
+	// and an organization can't touch another's records
+	_, err = s.people.ForOrg("8675309").Atomic(s.ids["eve"]).Inc("counter", 1).One(ctx)
+	assert.True(t, orm.IsNotFound(err))
+}
+
+func (s *OrmtestTests) SubTestUpdateExpressions(t *testing.T) {
+	ctx := context.TODO()
+
+	filter := s.people.Query().WithinOrg("8675309").Filter("team", "red")
+	op := filter.Operator()
+	ids, err := filter.UpdateAll(ctx, map[string]interface{}{
+		"counter": op.Add(op.Attribute("age"), 1),
+		"name":    op.Call("UPPER", op.Attribute("name")),
+		"team":    "green",
+	})
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []string{s.ids["ann"], s.ids["cat"]}, ids)
+
+	record, err := s.people.Get(ctx, s.ids["cat"])
+	assert.Nil(t, err)
+	assert.Equal(t, &Person{Id: s.ids["cat"], Rev: record.(*Person).Rev, Name: "CAT", Team: "green", Age: 42,
+		Counter: 43, OrganizationId: "8675309"}, record)
+
+	// the documents before and after, and nulls dropped
+	filter = s.people.Query().ById(s.ids["bob"])
+	op = filter.Operator()
+	results, err := filter.UpdateAllWith(ctx,
+		map[string]interface{}{"team": nil, "counter": op.Multiply(op.Attribute("age"), 2)},
+		orm.UpdateOptions{DropNulls: true, ReturnOld: true, ReturnNew: true})
+	assert.Nil(t, err)
+	assert.Len(t, results, 1)
+	assert.Equal(t, s.ids["bob"], results[0].Key)
+	assert.Equal(t, "blue", results[0].Old.(*Person).Team)
+	assert.Equal(t, 0, results[0].Old.(*Person).Counter)
+	assert.Equal(t, "", results[0].New.(*Person).Team)
+	assert.Equal(t, 50, results[0].New.(*Person).Counter)
+
+	collection, err := s.conn.Database.Collection(ctx, "people")
+	assert.Nil(t, err)
+	doc := make(map[string]interface{})
+	_, err = collection.ReadDocument(ctx, s.ids["bob"], &doc)
+	assert.Nil(t, err)
+	assert.NotContains(t, doc, "team")
+}
+
+func (s *OrmtestTests) SubTestValidation(t *testing.T) {
+	ctx := context.TODO()
+
+	signups := &orm.Collection{
+		Connection:      s.conn,
+		TableName:       "signups",
+		AllocateRecord:  func() interface{} { return &Signup{} },
+		ValidateRecords: true,
+		SchemaLevel:     driver.CollectionSchemaLevelStrict,
+	}
+	assert.Nil(t, signups.Initialize(ctx))
+
+	// the orm checks records before writing them
+	id, err := signups.Create(ctx, &Signup{Name: "ann", Email: "ann@example.com", Plan: "pro"})
+	assert.Nil(t, err)
+	_, err = signups.Create(ctx, &Signup{Name: "bob", Email: "bob", Plan: "pro"})
+	assert.EqualError(t, err, "validation failed: email must be an email address")
+	assert.True(t, orm.IsValidationError(signups.Update(ctx, &Signup{Id: id, Name: "ann", Plan: "gold"})))
+
+	results, err := signups.CreateMany(ctx, []interface{}{&Signup{Name: "cat", Plan: "free"}, &Signup{Plan: "free"}})
+	assert.Nil(t, err)
+	assert.Nil(t, results[0].Err)
+	assert.True(t, orm.IsValidationError(results[1].Err))
+
+	// and the schema stops writes that skip it
+	collection, err := s.conn.Database.Collection(ctx, "signups")
+	assert.Nil(t, err)
+	properties, err := collection.Properties(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, driver.CollectionSchemaLevelStrict, properties.Schema.Level)
+
+	_, err = collection.CreateDocument(ctx, map[string]interface{}{"name": "a very long name", "plan": "free"})
+	assert.True(t, orm.IsValidationError(err))
+	_, err = collection.CreateDocument(ctx, map[string]interface{}{"plan": "free"})
+	assert.True(t, orm.IsValidationError(err), "name is required")
+	_, err = s.conn.Database.Query(ctx, `INSERT { name: "dan", plan: "gold" } INTO signups`, nil)
+	assert.True(t, orm.IsValidationError(err))
+	_, err = collection.CreateDocument(ctx, map[string]interface{}{"name": "dan", "email": "", "plan": "free"})
+	assert.Nil(t, err)
+
+	count, err := signups.Query().Count(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, count)
+
+	// rules change with the record type when Initialize runs again
+	signups.SchemaLevel = driver.CollectionSchemaLevelNone
+	assert.Nil(t, signups.Initialize(ctx))
+	_, err = collection.CreateDocument(ctx, map[string]interface{}{"plan": "gold"})
+	assert.Nil(t, err)
+
+	// edges are validated as the record they're created from
+	type invite struct {
+		Id   string `json:"id"`
+		From string `json:"_from"`
+		To   string `json:"_to"`
+		Note string `json:"note" validate:"required"`
+	}
+	invites := &orm.EdgeCollection{Collection: &orm.Collection{
+		Connection:      s.conn,
+		TableName:       "invites",
+		AllocateRecord:  func() interface{} { return &invite{} },
+		ValidateRecords: true,
+	}}
+	assert.Nil(t, invites.Initialize(ctx))
+	_, err = invites.Create(ctx, &invite{From: s.people.Handle(s.ids["ann"]), To: s.people.Handle(s.ids["bob"])})
+	assert.EqualError(t, err, "validation failed: note is required")
+	_, err = invites.Create(ctx, &invite{From: s.people.Handle(s.ids["ann"]), To: s.people.Handle(s.ids["bob"]), Note: "welcome"})
+	assert.Nil(t, err)
+	_, err = invites.Connect(ctx, s.people.Handle(s.ids["ann"]), s.people.Handle(s.ids["cat"]), &invite{})
+	assert.EqualError(t, err, "validation failed: note is required")
+}
+
+func (s *OrmtestTests) SubTestHooks(t *testing.T) {
+	ctx := context.TODO()
+
+	audit := &orm.Collection{Connection: s.conn, TableName: "audit", AllocateRecord: func() interface{} { return &map[string]interface{}{} }}
+	assert.Nil(t, audit.Initialize(ctx))
+	accounts := orm.NewTypedCollection[Account](s.conn, "accounts")
+	assert.Nil(t, accounts.Initialize(ctx))
+
+	// a collection hook writes the audit trail, in the same transaction as the write
+	accounts.Hooks.AfterCreate = append(accounts.Hooks.AfterCreate, func(ctx context.Context, record interface{}) error {
+		_, err := audit.Create(ctx, map[string]interface{}{"email": record.(*Account).Email})
+		return err
+	})
+
+	err := s.conn.RunInTransaction(ctx, nil, []string{"accounts", "audit"}, func(ctx context.Context) error {
+		_, err := accounts.ForOrg("8675309").Create(ctx, &Account{Email: "Ann@Example.com"})
+		return err
+	})
+	assert.Nil(t, err)
+
+	found, err := accounts.ForOrg("8675309").Query().List().All(ctx)
+	assert.Nil(t, err)
+	assert.Len(t, found, 1)
+	ann := found[0]
+	assert.Equal(t, "ann@example.com", ann.Email)
+	assert.Equal(t, "example.com", ann.Domain)
+	assert.True(t, ann.Loaded)
+
+	count, err := audit.Query().Filter("email", "ann@example.com").Count(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, count)
+
+	// hooks run on updates, and can refuse deletes
+	ann.Email = "ANN@elsewhere.org"
+	assert.Nil(t, accounts.Update(ctx, ann))
+	stored, err := accounts.Get(ctx, ann.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, "elsewhere.org", stored.Domain)
+	assert.True(t, stored.Loaded)
+
+	stored.Domain = "example.com"
+	assert.EqualError(t, accounts.Delete(ctx, stored), "example accounts stay")
+	_, err = accounts.Get(ctx, ann.Id)
+	assert.Nil(t, err)
+
+	// edges run the hooks on the record itself
+	referrals := &orm.EdgeCollection{Collection: &orm.Collection{
+		Connection:     s.conn,
+		TableName:      "referrals",
+		AllocateRecord: func() interface{} { return &Referral{} },
+	}}
+	assert.Nil(t, referrals.Initialize(ctx))
+	hooked := make([]interface{}, 0)
+	referrals.Hooks.BeforeCreate = append(referrals.Hooks.BeforeCreate, func(ctx context.Context, record interface{}) error {
+		hooked = append(hooked, record)
+		return nil
+	})
+
+	bobId, err := accounts.Create(ctx, &Account{Email: "bob@example.org", ReferrerId: ann.Id})
+	assert.Nil(t, err)
+	referral := &Referral{From: accounts.Handle(ann.Id), To: accounts.Handle(bobId)}
+	referralId, err := referrals.Create(ctx, referral)
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{referral}, hooked)
+	edge, err := referrals.Get(ctx, referralId)
+	assert.Nil(t, err)
+	assert.Equal(t, "referred", edge.(*Referral).Stamp)
+
+	// and so does Connect, on the record it's given
+	hooked = hooked[:0]
+	connected := &Referral{}
+	referralId, err = referrals.Connect(ctx, accounts.Handle(bobId), accounts.Handle(ann.Id), connected)
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{connected}, hooked)
+	assert.Equal(t, accounts.Handle(bobId), connected.From)
+	edge, err = referrals.Get(ctx, referralId)
+	assert.Nil(t, err)
+	assert.Equal(t, "referred", edge.(*Referral).Stamp)
+	assert.Equal(t, accounts.Handle(ann.Id), edge.(*Referral).To)
+
+	// records without the handle fields can't hold the edge
+	_, err = referrals.Connect(ctx, accounts.Handle(bobId), accounts.Handle(ann.Id), &Account{})
+	assert.EqualError(t, err, "edges in referrals must have a _from document handle")
+
+	// included records get their collection's AfterRead hooks too
+	type withReferrer struct {
+		Account
+		Referrer *Account `json:"referrer"`
+	}
+	record, err := accounts.Query().ById(bobId).List().Include("referrer_id", accounts.Collection, "referrer").
+		As(func() interface{} { return &withReferrer{} }).First(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, "ann@elsewhere.org", record.(*withReferrer).Referrer.Email)
+	assert.True(t, record.(*withReferrer).Referrer.Loaded)
+}
+
+func TestOrmtest(t *testing.T) {
+	gtest.RunSubTests(t, &OrmtestTests{})
+}
@@ -0,0 +1,1013 @@
+package ormtest
+
+import (
+	"fmt"
+	"strings"
+)
+
+// -------------------------------------
+// Parser for the AQL subset: FOR (including graph traversals), FILTER, SORT, LIMIT,
+// LET, COLLECT, INSERT, UPDATE, REPLACE, REMOVE, UPSERT and RETURN, with the usual
+// expressions, bind parameters, subqueries and [*] expansion.
+// -------------------------------------
+
+// reserved words can't be variable names
+var reserved = map[string]bool{
+	"FOR": true, "RETURN": true, "FILTER": true, "SEARCH": true, "SORT": true, "LIMIT": true, "LET": true,
+	"COLLECT": true, "WINDOW": true, "INSERT": true, "UPDATE": true, "REPLACE": true, "REMOVE": true,
+	"UPSERT": true, "WITH": true, "INTO": true, "AGGREGATE": true, "IN": true, "NOT": true, "AND": true,
+	"OR": true, "LIKE": true, "TRUE": true, "FALSE": true, "NULL": true, "DISTINCT": true, "ASC": true,
+	"DESC": true, "OUTBOUND": true, "INBOUND": true, "ANY": true, "ALL": true, "NONE": true, "GRAPH": true,
+	"SHORTEST_PATH": true, "K_SHORTEST_PATHS": true, "K_PATHS": true, "ALL_SHORTEST_PATHS": true, "PRUNE": true,
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+	noIn   bool // IN ends the expression rather than testing membership, as in `UPDATE doc IN coll`
+
+	modifies bool // there's an INSERT, UPDATE, REPLACE, REMOVE or UPSERT somewhere
+}
+
+func parse(text string) (*query, error) {
+	tokens, err := tokenize(text)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	q, err := p.parseQuery()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.peek().kind != tokenEOF {
+		return nil, p.unexpected()
+	}
+	q.modifies = p.modifies
+
+	return q, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) peekAt(offset int) token {
+	if p.pos+offset >= len(p.tokens) {
+		return p.tokens[len(p.tokens)-1]
+	}
+	return p.tokens[p.pos+offset]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) accept(text string) bool {
+	if p.peek().is(text) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(text string) error {
+	if !p.accept(text) {
+		return fmt.Errorf("expected %s but found %s at %d", text, p.peek(), p.peek().pos)
+	}
+	return nil
+}
+
+func (p *parser) unexpected() error {
+	return fmt.Errorf("unexpected %s at %d", p.peek(), p.peek().pos)
+}
+
+func (p *parser) variableName() (string, error) {
+	t := p.next()
+	if t.kind != tokenIdentifier || reserved[strings.ToUpper(t.text)] {
+		return "", fmt.Errorf("expected a variable name but found %s at %d", t, t.pos)
+	}
+	return t.text, nil
+}
+
+// ----------------
+// statements
+// ----------------
+
+func (p *parser) parseQuery() (*query, error) {
+	q := &query{}
+
+	for {
+		t := p.peek()
+		if t.kind != tokenIdentifier {
+			break
+		}
+
+		var statement statement
+		var err error
+		switch strings.ToUpper(t.text) {
+		case "FOR":
+			statement, err = p.parseFor()
+		case "FILTER":
+			p.next()
+			var condition node
+			condition, err = p.parseExpression()
+			statement = &filterStatement{condition: condition}
+		case "SEARCH":
+			return nil, fmt.Errorf("SEARCH isn't supported by ormtest")
+		case "SORT":
+			statement, err = p.parseSort()
+		case "LIMIT":
+			statement, err = p.parseLimit()
+		case "LET":
+			statement, err = p.parseLet()
+		case "COLLECT":
+			statement, err = p.parseCollect()
+		case "INSERT", "UPDATE", "REPLACE", "REMOVE":
+			statement, err = p.parseModification()
+		case "UPSERT":
+			statement, err = p.parseUpsert()
+		case "RETURN":
+			p.next()
+			ret := &returnStatement{distinct: p.accept("DISTINCT")}
+			ret.expression, err = p.parseExpression()
+			if err != nil {
+				return nil, err
+			}
+			q.ret = ret
+			return q, nil
+		default:
+			return q, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+		q.statements = append(q.statements, statement)
+	}
+
+	if len(q.statements) == 0 {
+		return nil, p.unexpected()
+	}
+
+	return q, nil
+}
+
+func (p *parser) parseFor() (statement, error) {
+	p.next()
+
+	f := &forStatement{}
+	for {
+		name, err := p.variableName()
+		if err != nil {
+			return nil, err
+		}
+		f.variables = append(f.variables, name)
+		if !p.accept(",") {
+			break
+		}
+	}
+
+	if err := p.expect("IN"); err != nil {
+		return nil, err
+	}
+
+	if direction := p.direction(); direction != "" {
+		f.traversal = &traversal{direction: direction, min: &literal{value: 1.0}, max: &literal{value: 1.0}}
+	} else {
+		source, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+
+		direction := p.direction()
+		if direction == "" {
+			if len(f.variables) != 1 {
+				return nil, fmt.Errorf("only traversals can have more than one FOR variable")
+			}
+			f.source = source
+			return f, nil
+		}
+
+		f.traversal = &traversal{direction: direction, min: source, max: source}
+		if r, ok := source.(*rangeNode); ok {
+			f.traversal.min, f.traversal.max = r.from, r.to
+		}
+	}
+
+	if len(f.variables) > 3 {
+		return nil, fmt.Errorf("traversals have at most three variables")
+	}
+
+	start, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+	f.traversal.start = start
+
+	switch t := p.next(); {
+	case t.kind == tokenBindCollection:
+		f.traversal.edges = &bindCollection{name: t.text}
+	case t.kind == tokenIdentifier && !reserved[strings.ToUpper(t.text)]:
+		f.traversal.edges = &literal{value: t.text}
+	case t.kind == tokenString:
+		f.traversal.edges = &literal{value: t.text}
+	default:
+		return nil, fmt.Errorf("expected an edge collection but found %s at %d", t, t.pos)
+	}
+
+	if p.accept("OPTIONS") {
+		if _, err := p.parseExpression(); err != nil {
+			return nil, err
+		}
+	}
+
+	return f, nil
+}
+
+func (p *parser) direction() string {
+	for _, direction := range []string{"OUTBOUND", "INBOUND", "ANY"} {
+		if p.peek().is(direction) {
+			p.next()
+			return direction
+		}
+	}
+	return ""
+}
+
+func (p *parser) parseSort() (statement, error) {
+	p.next()
+
+	s := &sortStatement{}
+	for {
+		expression, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+		key := sortKey{expression: expression}
+		if p.accept("DESC") {
+			key.descending = true
+		} else {
+			p.accept("ASC")
+		}
+		s.keys = append(s.keys, key)
+
+		if !p.accept(",") {
+			return s, nil
+		}
+	}
+}
+
+func (p *parser) parseLimit() (statement, error) {
+	p.next()
+
+	first, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+
+	if !p.accept(",") {
+		return &limitStatement{offset: &literal{value: 0.0}, count: first}, nil
+	}
+
+	count, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+
+	return &limitStatement{offset: first, count: count}, nil
+}
+
+func (p *parser) parseLet() (statement, error) {
+	p.next()
+
+	name, err := p.variableName()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect("="); err != nil {
+		return nil, err
+	}
+
+	expression, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+
+	return &letStatement{name: name, expression: expression}, nil
+}
+
+func (p *parser) parseAssignments() ([]assignment, error) {
+	assignments := make([]assignment, 0)
+	for {
+		name, err := p.variableName()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("="); err != nil {
+			return nil, err
+		}
+		expression, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+		assignments = append(assignments, assignment{name: name, expression: expression})
+
+		if !p.accept(",") {
+			return assignments, nil
+		}
+	}
+}
+
+func (p *parser) parseCollect() (statement, error) {
+	p.next()
+
+	c := &collectStatement{}
+	var err error
+
+	startsGroup := p.peek().kind == tokenIdentifier && !reserved[strings.ToUpper(p.peek().text)] && p.peekAt(1).is("=")
+	if startsGroup {
+		if c.groups, err = p.parseAssignments(); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.accept("AGGREGATE") {
+		if c.aggregates, err = p.parseAssignments(); err != nil {
+			return nil, err
+		}
+		for _, aggregate := range c.aggregates {
+			if _, ok := aggregate.expression.(*call); !ok {
+				return nil, fmt.Errorf("AGGREGATE %s needs an aggregate function", aggregate.name)
+			}
+		}
+	}
+
+	if p.accept("INTO") {
+		if c.into, err = p.variableName(); err != nil {
+			return nil, err
+		}
+		if p.accept("=") {
+			if c.intoExpression, err = p.parseExpression(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if p.accept("WITH") {
+		if err := p.expect("COUNT"); err != nil {
+			return nil, err
+		}
+		if err := p.expect("INTO"); err != nil {
+			return nil, err
+		}
+		if c.countInto, err = p.variableName(); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.accept("OPTIONS") {
+		if _, err := p.parseExpression(); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(c.groups) == 0 && len(c.aggregates) == 0 && c.into == "" && c.countInto == "" {
+		return nil, p.unexpected()
+	}
+
+	return c, nil
+}
+
+// parseTarget reads `IN collection [OPTIONS {...}]` at the end of a modification
+func (p *parser) parseTarget() (node, node, error) {
+	if !p.accept("IN") && !p.accept("INTO") {
+		return nil, nil, fmt.Errorf("expected IN but found %s at %d", p.peek(), p.peek().pos)
+	}
+
+	var collection node
+	switch t := p.next(); {
+	case t.kind == tokenBindCollection:
+		collection = &bindCollection{name: t.text}
+	case t.kind == tokenIdentifier && !reserved[strings.ToUpper(t.text)]:
+		collection = &literal{value: t.text}
+	default:
+		return nil, nil, fmt.Errorf("expected a collection but found %s at %d", t, t.pos)
+	}
+
+	var options node
+	if p.accept("OPTIONS") {
+		var err error
+		if options, err = p.parseExpression(); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return collection, options, nil
+}
+
+func (p *parser) parseNoIn() (node, error) {
+	saved := p.noIn
+	p.noIn = true
+	defer func() { p.noIn = saved }()
+
+	return p.parseExpression()
+}
+
+func (p *parser) parseModification() (statement, error) {
+	m := &modification{kind: strings.ToUpper(p.next().text)}
+	p.modifies = true
+
+	var err error
+	if m.document, err = p.parseNoIn(); err != nil {
+		return nil, err
+	}
+
+	if (m.kind == "UPDATE" || m.kind == "REPLACE") && p.accept("WITH") {
+		m.key = m.document
+		if m.document, err = p.parseNoIn(); err != nil {
+			return nil, err
+		}
+	}
+
+	if m.collection, m.options, err = p.parseTarget(); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+func (p *parser) parseUpsert() (statement, error) {
+	p.next()
+	p.modifies = true
+
+	u := &upsert{}
+	var err error
+	if u.search, err = p.parseNoIn(); err != nil {
+		return nil, err
+	}
+
+	if err := p.expect("INSERT"); err != nil {
+		return nil, err
+	}
+	if u.insert, err = p.parseNoIn(); err != nil {
+		return nil, err
+	}
+
+	if p.accept("REPLACE") {
+		u.replace = true
+	} else if err := p.expect("UPDATE"); err != nil {
+		return nil, err
+	}
+	if u.update, err = p.parseNoIn(); err != nil {
+		return nil, err
+	}
+
+	if u.collection, u.options, err = p.parseTarget(); err != nil {
+		return nil, err
+	}
+
+	return u, nil
+}
+
+// ----------------
+// expressions, lowest precedence first
+// ----------------
+
+func (p *parser) parseExpression() (node, error) {
+	return p.parseTernary()
+}
+
+func (p *parser) parseTernary() (node, error) {
+	condition, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	if !p.accept("?") {
+		return condition, nil
+	}
+
+	var then node = condition // `a ?: b` is `a ? a : b`
+	if !p.peek().is(":") {
+		if then, err = p.parseTernary(); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.expect(":"); err != nil {
+		return nil, err
+	}
+
+	otherwise, err := p.parseTernary()
+	if err != nil {
+		return nil, err
+	}
+
+	return &ternary{condition: condition, then: then, otherwise: otherwise}, nil
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.accept("||") || p.accept("OR") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &binary{operator: "||", left: left, right: right}
+	}
+
+	return left, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseEquality()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.accept("&&") || p.accept("AND") {
+		right, err := p.parseEquality()
+		if err != nil {
+			return nil, err
+		}
+		left = &binary{operator: "&&", left: left, right: right}
+	}
+
+	return left, nil
+}
+
+func (p *parser) parseEquality() (node, error) {
+	left, err := p.parseIn()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		// array comparisons, like doc.tags[*].kind ALL == "a"
+		for _, quantifier := range []string{"ALL", "ANY", "NONE"} {
+			if p.peek().is(quantifier) && (p.peekAt(1).is("==") || p.peekAt(1).is("!=")) {
+				operator := p.peekAt(1).text
+				p.pos += 2
+				right, err := p.parseIn()
+				if err != nil {
+					return nil, err
+				}
+				left = &quantified{quantifier: quantifier, operator: operator, left: left, right: right}
+			}
+		}
+
+		var operator string
+		switch {
+		case p.accept("=="):
+			operator = "=="
+		case p.accept("!="):
+			operator = "!="
+		case p.accept("=~"):
+			operator = "=~"
+		case p.accept("!~"):
+			operator = "!~"
+		case p.accept("LIKE"):
+			operator = "LIKE"
+		case p.peek().is("NOT") && p.peekAt(1).is("LIKE"):
+			p.pos += 2
+			operator = "NOT LIKE"
+		default:
+			return left, nil
+		}
+
+		right, err := p.parseIn()
+		if err != nil {
+			return nil, err
+		}
+		left = &binary{operator: operator, left: left, right: right}
+	}
+}
+
+func (p *parser) parseIn() (node, error) {
+	left, err := p.parseRelational()
+	if err != nil {
+		return nil, err
+	}
+
+	for !p.noIn {
+		var operator string
+		switch {
+		case p.accept("IN"):
+			operator = "IN"
+		case p.peek().is("NOT") && p.peekAt(1).is("IN"):
+			p.pos += 2
+			operator = "NOT IN"
+		default:
+			return left, nil
+		}
+
+		right, err := p.parseRelational()
+		if err != nil {
+			return nil, err
+		}
+		left = &binary{operator: operator, left: left, right: right}
+	}
+
+	return left, nil
+}
+
+func (p *parser) parseRelational() (node, error) {
+	left, err := p.parseRange()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		operator := ""
+		for _, candidate := range []string{"<=", ">=", "<", ">"} {
+			if p.accept(candidate) {
+				operator = candidate
+				break
+			}
+		}
+		if operator == "" {
+			return left, nil
+		}
+
+		right, err := p.parseRange()
+		if err != nil {
+			return nil, err
+		}
+		left = &binary{operator: operator, left: left, right: right}
+	}
+}
+
+func (p *parser) parseRange() (node, error) {
+	left, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+
+	if !p.accept("..") {
+		return left, nil
+	}
+
+	right, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+
+	return &rangeNode{from: left, to: right}, nil
+}
+
+func (p *parser) parseAdditive() (node, error) {
+	left, err := p.parseMultiplicative()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peek().is("+") || p.peek().is("-") {
+		operator := p.next().text
+		right, err := p.parseMultiplicative()
+		if err != nil {
+			return nil, err
+		}
+		left = &binary{operator: operator, left: left, right: right}
+	}
+
+	return left, nil
+}
+
+func (p *parser) parseMultiplicative() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peek().is("*") || p.peek().is("/") || p.peek().is("%") {
+		operator := p.next().text
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &binary{operator: operator, left: left, right: right}
+	}
+
+	return left, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	switch {
+	case p.accept("!"), p.accept("NOT"):
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &unary{operator: "!", operand: operand}, nil
+	case p.peek().is("-") || p.peek().is("+"):
+		operator := p.next().text
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &unary{operator: operator, operand: operand}, nil
+	}
+
+	return p.parsePostfix()
+}
+
+func (p *parser) parsePostfix() (node, error) {
+	value, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+
+	return p.parseAccessors(value)
+}
+
+// parseAccessors reads .attribute, [index], [*] and [* FILTER condition] after a value
+func (p *parser) parseAccessors(value node) (node, error) {
+	for {
+		switch {
+		case p.accept("."):
+			t := p.next()
+			if t.kind != tokenIdentifier && t.kind != tokenString {
+				return nil, fmt.Errorf("expected an attribute name but found %s at %d", t, t.pos)
+			}
+			value = &attribute{object: value, name: t.text}
+
+		case p.accept("[*]"):
+			projection, err := p.parseAccessors(&variable{name: "CURRENT"})
+			if err != nil {
+				return nil, err
+			}
+			return &expansion{array: value, projection: projection}, nil
+
+		// an inline filter, like p.vertices[* FILTER CURRENT.age > 18].name
+		case p.peek().is("[") && p.peekAt(1).is("*") && p.peekAt(2).is("FILTER"):
+			p.next()
+			p.next()
+			p.next()
+			saved := p.noIn
+			p.noIn = false
+			condition, err := p.parseExpression()
+			p.noIn = saved
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			projection, err := p.parseAccessors(&variable{name: "CURRENT"})
+			if err != nil {
+				return nil, err
+			}
+			return &expansion{array: value, filter: condition, projection: projection}, nil
+
+		case p.peek().is("["):
+			p.next()
+			saved := p.noIn
+			p.noIn = false
+			index, err := p.parseExpression()
+			p.noIn = saved
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			value = &indexAccess{object: value, index: index}
+
+		default:
+			return value, nil
+		}
+	}
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	t := p.peek()
+
+	switch t.kind {
+	case tokenNumber:
+		p.next()
+		return &literal{value: t.number}, nil
+
+	case tokenString:
+		p.next()
+		return &literal{value: t.text}, nil
+
+	case tokenBindVar:
+		p.next()
+		return &bindVar{name: t.text}, nil
+
+	case tokenBindCollection:
+		p.next()
+		return &bindCollection{name: t.text}, nil
+
+	case tokenIdentifier:
+		switch strings.ToUpper(t.text) {
+		case "TRUE":
+			p.next()
+			return &literal{value: true}, nil
+		case "FALSE":
+			p.next()
+			return &literal{value: false}, nil
+		case "NULL":
+			p.next()
+			return &literal{value: nil}, nil
+		}
+
+		if p.peekAt(1).is("(") || p.peekAt(1).is("::") {
+			return p.parseCall()
+		}
+
+		if reserved[strings.ToUpper(t.text)] {
+			return nil, p.unexpected()
+		}
+		p.next()
+		return &variable{name: t.text}, nil
+
+	case tokenOperator:
+		switch t.text {
+		case "(":
+			return p.parseParenthesized()
+		case "[":
+			return p.parseArray()
+		case "{":
+			return p.parseObject()
+		}
+	}
+
+	return nil, p.unexpected()
+}
+
+func (p *parser) parseCall() (node, error) {
+	name := p.next().text
+	for p.accept("::") {
+		name += "::" + p.next().text
+	}
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+
+	saved := p.noIn
+	p.noIn = false
+	defer func() { p.noIn = saved }()
+
+	c := &call{name: strings.ToUpper(name)}
+	if p.accept(")") {
+		return c, nil
+	}
+
+	// a subquery can be the only argument without parentheses of its own, as in FIRST(FOR ...)
+	if p.startsQuery() {
+		q, err := p.parseQuery()
+		if err != nil {
+			return nil, err
+		}
+		c.arguments = append(c.arguments, &subquery{query: q})
+		return c, p.expect(")")
+	}
+	for {
+		argument, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+		c.arguments = append(c.arguments, argument)
+
+		if p.accept(")") {
+			return c, nil
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *parser) startsQuery() bool {
+	t := p.peek()
+	if t.kind != tokenIdentifier {
+		return false
+	}
+	switch strings.ToUpper(t.text) {
+	case "FOR", "LET", "RETURN", "COLLECT", "INSERT", "UPDATE", "REPLACE", "REMOVE", "UPSERT":
+		return true
+	}
+	return false
+}
+
+func (p *parser) parseParenthesized() (node, error) {
+	p.next()
+
+	saved := p.noIn
+	p.noIn = false
+	defer func() { p.noIn = saved }()
+
+	if p.startsQuery() {
+		q, err := p.parseQuery()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return &subquery{query: q}, nil
+	}
+
+	expression, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+
+	return expression, nil
+}
+
+func (p *parser) parseArray() (node, error) {
+	p.next()
+
+	saved := p.noIn
+	p.noIn = false
+	defer func() { p.noIn = saved }()
+
+	a := &arrayLiteral{}
+	if p.accept("]") {
+		return a, nil
+	}
+	for {
+		item, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+		a.items = append(a.items, item)
+
+		if p.accept("]") {
+			return a, nil
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *parser) parseObject() (node, error) {
+	p.next()
+
+	saved := p.noIn
+	p.noIn = false
+	defer func() { p.noIn = saved }()
+
+	o := &objectLiteral{}
+	if p.accept("}") {
+		return o, nil
+	}
+	for {
+		entry := objectEntry{}
+		t := p.next()
+		switch {
+		case t.kind == tokenIdentifier || t.kind == tokenString || t.kind == tokenNumber:
+			entry.name = t.text
+			if t.kind == tokenIdentifier && !p.peek().is(":") {
+				entry.value = &variable{name: t.text} // shorthand { name }
+			}
+		case t.kind == tokenBindVar:
+			entry.key = &bindVar{name: t.text}
+		case t.is("["):
+			key, err := p.parseExpression()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			entry.key = key
+		default:
+			return nil, fmt.Errorf("expected an attribute name but found %s at %d", t, t.pos)
+		}
+
+		if entry.value == nil {
+			if err := p.expect(":"); err != nil {
+				return nil, err
+			}
+			value, err := p.parseExpression()
+			if err != nil {
+				return nil, err
+			}
+			entry.value = value
+		}
+		o.entries = append(o.entries, entry)
+
+		if p.accept("}") {
+			return o, nil
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+	}
+}
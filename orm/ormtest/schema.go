@@ -0,0 +1,188 @@
+package ormtest
+
+import (
+	"context"
+	"regexp"
+	"unicode/utf8"
+
+	"github.com/arangodb/go-driver"
+)
+
+// -------------------------------------
+// Collection schemas. Documents are checked against a collection's JSON schema on every
+// write, whatever the level (except none). Only the keywords the ORM generates are
+// understood: type, properties, required, items, enum, not, anyOf, pattern and the
+// min/max length, item, property and value limits.
+// -------------------------------------
+
+func (c *Collection) Properties(ctx context.Context) (driver.CollectionProperties, error) {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+
+	return driver.CollectionProperties{
+		CollectionInfo: driver.CollectionInfo{Name: c.name, Type: c.kind},
+		Schema:         c.schema,
+	}, nil
+}
+
+func (c *Collection) SetProperties(ctx context.Context, options driver.SetCollectionPropertiesOptions) error {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+
+	if options.Schema != nil {
+		schema, err := normalizeSchema(options.Schema)
+		if err != nil {
+			return err
+		}
+		c.schema = schema
+	}
+	return nil
+}
+
+func normalizeSchema(schema *driver.CollectionSchemaOptions) (*driver.CollectionSchemaOptions, error) {
+	rule, err := normalize(schema.Rule)
+	if err != nil {
+		return nil, arangoError(400, 1621, "invalid schema: %s", err)
+	}
+
+	normalized := *schema
+	normalized.Rule = rule
+	return &normalized, nil
+}
+
+// checkSchema returns arango's validation error when the document doesn't match the schema
+func (c *Collection) checkSchema(document map[string]interface{}) error {
+	if c.schema == nil || c.schema.Level == driver.CollectionSchemaLevelNone {
+		return nil
+	}
+
+	rule, _ := c.schema.Rule.(map[string]interface{})
+	if rule == nil || matchesSchema(rule, document) {
+		return nil
+	}
+
+	message := c.schema.Message
+	if message == "" {
+		message = "Schema violation"
+	}
+	return arangoError(400, 1620, "%s", message)
+}
+
+func matchesSchema(schema map[string]interface{}, value interface{}) bool {
+	if kind, ok := schema["type"].(string); ok && jsonType(value) != kind && !(kind == "number" && jsonType(value) == "integer") {
+		return false
+	}
+
+	if options, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, option := range options {
+			if equalValues(option, value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if not, ok := schema["not"].(map[string]interface{}); ok && matchesSchema(not, value) {
+		return false
+	}
+
+	if anyOf, ok := schema["anyOf"].([]interface{}); ok {
+		found := false
+		for _, option := range anyOf {
+			if option, ok := option.(map[string]interface{}); ok && matchesSchema(option, value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	switch v := value.(type) {
+	case string:
+		length := float64(utf8.RuneCountInString(v))
+		if !withinLimits(schema, "minLength", "maxLength", length) {
+			return false
+		}
+		if pattern, ok := schema["pattern"].(string); ok {
+			matched, err := regexp.MatchString(pattern, v)
+			if err != nil || !matched {
+				return false
+			}
+		}
+	case float64:
+		if !withinLimits(schema, "minimum", "maximum", v) {
+			return false
+		}
+	case []interface{}:
+		if !withinLimits(schema, "minItems", "maxItems", float64(len(v))) {
+			return false
+		}
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for _, item := range v {
+				if !matchesSchema(items, item) {
+					return false
+				}
+			}
+		}
+	case map[string]interface{}:
+		if !withinLimits(schema, "minProperties", "maxProperties", float64(len(v))) {
+			return false
+		}
+		if required, ok := schema["required"].([]interface{}); ok {
+			for _, name := range required {
+				if name, ok := name.(string); ok {
+					if _, exists := v[name]; !exists {
+						return false
+					}
+				}
+			}
+		}
+		if properties, ok := schema["properties"].(map[string]interface{}); ok {
+			for name, property := range properties {
+				property, ok := property.(map[string]interface{})
+				field, exists := v[name]
+				if ok && exists && !matchesSchema(property, field) {
+					return false
+				}
+			}
+		}
+	}
+
+	return true
+}
+
+func withinLimits(schema map[string]interface{}, minimum, maximum string, value float64) bool {
+	if limit, ok := schema[minimum].(float64); ok && value < limit {
+		return false
+	}
+	if limit, ok := schema[maximum].(float64); ok && value > limit {
+		return false
+	}
+	return true
+}
+
+// jsonType is the JSON schema type of a normalized value
+func jsonType(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if v == float64(int64(v)) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	}
+	return "object"
+}
@@ -0,0 +1,237 @@
+package ormtest
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// -------------------------------------
+// AQL values. Everything the fake database stores or computes is normalized to what
+// a JSON round trip produces (nil, bool, float64, string, []interface{} and
+// map[string]interface{}), which is also what the real server hands back.
+// -------------------------------------
+
+// normalize turns any go value into plain json values
+func normalize(value interface{}) (interface{}, error) {
+	switch value.(type) {
+	case nil, bool, float64, string:
+		return value, nil
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	var normalized interface{}
+	if err := json.Unmarshal(data, &normalized); err != nil {
+		return nil, err
+	}
+
+	return normalized, nil
+}
+
+// clone deep copies a normalized value, so stored documents can't be changed from outside
+func clone(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			copied[key] = clone(item)
+		}
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(v))
+		for i, item := range v {
+			copied[i] = clone(item)
+		}
+		return copied
+	default:
+		return v
+	}
+}
+
+// typeRank is the order AQL sorts values of different types in
+func typeRank(value interface{}) int {
+	switch value.(type) {
+	case nil:
+		return 0
+	case bool:
+		return 1
+	case float64:
+		return 2
+	case string:
+		return 3
+	case []interface{}:
+		return 4
+	default:
+		return 5
+	}
+}
+
+// compareValues orders two values the way AQL does: null < bool < number < string < array < object
+func compareValues(left, right interface{}) int {
+	leftRank, rightRank := typeRank(left), typeRank(right)
+	if leftRank != rightRank {
+		return compareInts(leftRank, rightRank)
+	}
+
+	switch l := left.(type) {
+	case nil:
+		return 0
+	case bool:
+		r := right.(bool)
+		if l == r {
+			return 0
+		} else if !l {
+			return -1
+		}
+		return 1
+	case float64:
+		r := right.(float64)
+		if l < r {
+			return -1
+		} else if l > r {
+			return 1
+		}
+		return 0
+	case string:
+		return strings.Compare(l, right.(string))
+	case []interface{}:
+		r := right.([]interface{})
+		for i := 0; i < len(l) && i < len(r); i++ {
+			if c := compareValues(l[i], r[i]); c != 0 {
+				return c
+			}
+		}
+		return compareInts(len(l), len(r))
+	case map[string]interface{}:
+		r := right.(map[string]interface{})
+		keys := sortedKeys(l, r)
+		for _, key := range keys {
+			if c := compareValues(l[key], r[key]); c != 0 {
+				return c
+			}
+		}
+		return 0
+	}
+
+	return 0
+}
+
+func compareInts(left, right int) int {
+	if left < right {
+		return -1
+	} else if left > right {
+		return 1
+	}
+	return 0
+}
+
+func sortedKeys(objects ...map[string]interface{}) []string {
+	seen := make(map[string]bool)
+	keys := make([]string, 0)
+	for _, object := range objects {
+		for key := range object {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func equalValues(left, right interface{}) bool {
+	return compareValues(left, right) == 0
+}
+
+// truthy is AQL's boolean conversion: null, false, 0 and "" are false, everything else (even [] and {}) is true
+func truthy(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case float64:
+		return v != 0
+	case string:
+		return v != ""
+	default:
+		return true
+	}
+}
+
+// toNumber is AQL's numeric conversion, values that aren't numbers become 0
+func toNumber(value interface{}) float64 {
+	switch v := value.(type) {
+	case bool:
+		if v {
+			return 1
+		}
+		return 0
+	case float64:
+		return v
+	case string:
+		number, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil || math.IsNaN(number) || math.IsInf(number, 0) {
+			return 0
+		}
+		return number
+	case []interface{}:
+		if len(v) == 1 {
+			return toNumber(v[0])
+		}
+		if len(v) == 0 {
+			return 0
+		}
+	}
+
+	return 0
+}
+
+func toString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		data, _ := json.Marshal(v)
+		return string(data)
+	}
+}
+
+func toArray(value interface{}) []interface{} {
+	switch v := value.(type) {
+	case nil:
+		return []interface{}{}
+	case []interface{}:
+		return v
+	default:
+		return []interface{}{v}
+	}
+}
+
+// number makes arithmetic results valid json, NaN and infinity become null
+func number(value float64) interface{} {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return nil
+	}
+	return value
+}
+
+// identity is a canonical form of a value, for grouping and DISTINCT
+func identity(value interface{}) string {
+	data, _ := json.Marshal(value) // maps marshal with sorted keys
+	return string(data)
+}
@@ -6,11 +6,11 @@ import (
 	"github.com/houqp/gtest"
 	"github.com/joho/godotenv"
 	"github.com/stretchr/testify/assert"
+	"log/slog"
 	"reflect"
 	"sort"
 	"strings"
 
-	log "github.com/sirupsen/logrus"
 	"os"
 	"testing"
 )
@@ -56,9 +56,10 @@ func (s *RealOrmTests) Setup(t *testing.T) {
 
 	conn, err := NewConnection(ctx, dbName, dbUser, dbPass, dbUrl)
 	if err != nil {
-		log.Error("Failed to connect to arangodb", log.Fields{"err": err})
+		slog.Error("Failed to connect to arangodb", "err", err)
 		os.Exit(5)
 	}
+	conn.Logger = NewSlogLogger(slog.Default().Handler())
 
 	s.conn = conn
 }
@@ -0,0 +1,183 @@
+package orm
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/arangodb/go-driver"
+)
+
+// -------------------------------------
+// Retries. With a RetryPolicy on the Connection, operations that fail with a transient error
+// (a write-write conflict, a cluster leader change, a 503) are tried again after a backoff.
+// Reads are always retried. Writes are retried only if the policy says RetryWrites, or the
+// context was made WithRetriedWrites, since a write that timed out may have happened anyway.
+//
+// Operations inside a transaction are never retried on their own, the transaction is aborted
+// by then. RunInTransactionWithRetry runs the whole transaction again instead.
+// -------------------------------------
+
+type RetryPolicy struct {
+	MaxAttempts    int // including the first one, so 1 or less never retries
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64 // how much the backoff grows each attempt, 2 if unset
+	Jitter         float64 // the fraction of each backoff that's random, 0 to 1
+
+	// Retryable tells which errors are worth another attempt, IsTransient when nil
+	Retryable func(err error) bool
+
+	RetryWrites bool
+}
+
+// DefaultRetryPolicy makes 3 attempts, waiting around 50ms then 100ms
+func DefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: 50 * time.Millisecond,
+		MaxBackoff:     2 * time.Second,
+		Multiplier:     2,
+		Jitter:         0.5,
+	}
+}
+
+// IsTransient tells if an error is one arangodb may not make again: a write-write conflict,
+// a cluster leadership change or the server being unavailable. Revision mismatches aren't
+// transient, the document really did change.
+func IsTransient(err error) bool {
+	var arangoErr driver.ArangoError
+	if !errors.As(err, &arangoErr) {
+		var ok bool
+		if arangoErr, ok = driver.AsArangoError(err); !ok {
+			return false
+		}
+	}
+
+	switch {
+	case arangoErr.Code == http.StatusConflict && arangoErr.ErrorNum == driver.ErrArangoConflict:
+		return true
+	case arangoErr.ErrorNum == driver.ErrClusterNotLeader || arangoErr.ErrorNum == driver.ErrClusterLeadershipChallengeOngoing:
+		return true
+	case arangoErr.Code == http.StatusServiceUnavailable:
+		return true
+	}
+
+	return false
+}
+
+func (c *RetryPolicy) retryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if c.Retryable != nil {
+		return c.Retryable(err)
+	}
+	return IsTransient(err)
+}
+
+// backoff is how long to wait before the attempt after this one (counting from 1)
+func (c *RetryPolicy) backoff(attempt int) time.Duration {
+	multiplier := c.Multiplier
+	if multiplier <= 0 {
+		multiplier = 2
+	}
+
+	backoff := float64(c.InitialBackoff) * math.Pow(multiplier, float64(attempt-1))
+	if c.MaxBackoff > 0 && backoff > float64(c.MaxBackoff) {
+		backoff = float64(c.MaxBackoff)
+	}
+
+	jitter := math.Min(math.Max(c.Jitter, 0), 1)
+	return time.Duration(backoff * (1 - jitter*rand.Float64()))
+}
+
+// sleep waits between attempts, unless the context ends first
+var sleep = defaultSleep
+
+func defaultSleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// run calls fn until it succeeds, fails for good or runs out of attempts
+func (c *RetryPolicy) run(ctx context.Context, fn func() error, onRetry func(attempt int, err error)) error {
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if attempt >= c.MaxAttempts || !c.retryable(err) {
+			return err
+		}
+
+		if onRetry != nil {
+			onRetry(attempt, err)
+		}
+		if sleepErr := sleep(ctx, c.backoff(attempt)); sleepErr != nil {
+			return err
+		}
+	}
+}
+
+type retriedWritesKey struct{}
+
+// WithRetriedWrites opts the writes made with the context in to retries
+func WithRetriedWrites(ctx context.Context) context.Context {
+	return context.WithValue(ctx, retriedWritesKey{}, true)
+}
+
+// readOperations are safe to run again
+var readOperations = map[string]bool{
+	OpQuery:     true,
+	OpCount:     true,
+	OpAggregate: true,
+	OpTraverse:  true,
+	OpProfile:   true,
+	OpExplain:   true,
+	OpGet:       true,
+}
+
+// retry runs the database call of an operation, again if the Connection's RetryPolicy allows it
+func (c *operation) retry(fn func() error) error {
+	policy := c.conn.RetryPolicy
+	if policy == nil || InTransaction(c.ctx) {
+		return fn()
+	}
+
+	writesRetried, _ := c.ctx.Value(retriedWritesKey{}).(bool)
+	if !readOperations[c.name] && !policy.RetryWrites && !writesRetried {
+		return fn()
+	}
+
+	return policy.run(c.ctx, fn, func(attempt int, err error) {
+		c.attempts = attempt + 1
+		c.conn.log(c.ctx, c.conn.logLevel(c.name), "ORM retrying "+c.name, func() Fields {
+			return Fields{"collection": c.collection, "operation": c.name, "attempt": attempt, "error": err.Error()}
+		})
+	})
+}
+
+// RunInTransactionWithRetry is RunInTransaction, but when the transaction fails with an error the
+// Connection's RetryPolicy finds retryable, it's aborted and fn runs again in a new transaction.
+// fn must be safe to run more than once.
+func (c *Connection) RunInTransactionWithRetry(ctx context.Context, readCols, writeCols []string, fn func(ctx context.Context) error) error {
+	if c.RetryPolicy == nil || InTransaction(ctx) {
+		return c.RunInTransaction(ctx, readCols, writeCols, fn)
+	}
+
+	return c.RetryPolicy.run(ctx, func() error {
+		return c.RunInTransaction(ctx, readCols, writeCols, fn)
+	}, func(attempt int, err error) {
+		c.log(ctx, LevelInfo, "ORM retrying transaction", func() Fields {
+			return Fields{"attempt": attempt, "error": err.Error(), "collections": writeCols}
+		})
+	})
+}
@@ -0,0 +1,79 @@
+package orm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/arangodb/go-driver"
+	"github.com/ridelabs/simply_arango/encoding"
+)
+
+// -------------------------------------
+// Optimistic concurrency control. Records that carry their revision (a field tagged
+// `json:"_rev,omitempty"`, or an embedded Meta) are only updated or deleted if nobody
+// changed the document since it was read, otherwise the operation fails with ErrConflict.
+// -------------------------------------
+
+const RevisionKey = "_rev"
+
+// Meta can be embedded in records to carry the id and the revision
+type Meta struct {
+	Id  string `json:"id"`
+	Rev string `json:"_rev,omitempty"`
+}
+
+var ErrConflict = errors.New("document was changed by someone else")
+
+type ConflictError struct {
+	Collection string
+	Id         string
+	Rev        string
+	Err        error
+}
+
+func (c *ConflictError) Error() string {
+	return fmt.Sprintf("%s/%s no longer has revision %s: %s", c.Collection, c.Id, c.Rev, ErrConflict)
+}
+
+func (c *ConflictError) Is(target error) bool {
+	return target == ErrConflict
+}
+
+func (c *ConflictError) Unwrap() error {
+	return c.Err
+}
+
+func IsConflict(err error) bool {
+	return errors.Is(err, ErrConflict)
+}
+
+// withRevision pulls the revision out of the document and makes the context require it
+func withRevision(ctx context.Context, doc map[string]interface{}) (context.Context, string) {
+	rev, _ := doc[RevisionKey].(string)
+	delete(doc, RevisionKey)
+
+	if rev == "" {
+		return ctx, ""
+	}
+
+	return driver.WithRevision(ctx, rev), rev
+}
+
+func (c *Collection) conflictError(err error, id, rev string) error {
+	// queries report a revision mismatch as a conflict rather than a failed precondition
+	if rev != "" && (driver.IsPreconditionFailed(err) || driver.IsArangoErrorWithErrorNum(err, driver.ErrArangoConflict)) {
+		return &ConflictError{Collection: c.TableName, Id: id, Rev: rev, Err: err}
+	}
+
+	return err
+}
+
+// storeRevision copies the new revision back into the record, so it can be updated again
+func storeRevision(obj interface{}, rev string) error {
+	if rev == "" {
+		return nil
+	}
+
+	return encoding.MapToObject(map[string]interface{}{RevisionKey: rev}, obj)
+}
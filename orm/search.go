@@ -0,0 +1,229 @@
+package orm
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/arangodb/go-driver"
+)
+
+// -------------------------------------
+// ArangoSearch. A SearchView declares a view over a collection (and the analyzers it
+// needs), Initialize creates it or brings an existing one in line with the declaration.
+// Search() then builds `FOR doc IN view SEARCH ...` queries that end with the usual
+// ItemsOperator terminals:
+//
+//	articles := &orm.SearchView{
+//		Name:       "articles_view",
+//		Collection: articlesCollection,
+//		Fields:     map[string][]string{"title": {"text_en"}, "body": {"text_en"}},
+//	}
+//	articles.Initialize(ctx)
+//	docs, err := articles.Search().Phrase("title", "quick brown fox", "text_en").Boost(2).
+//		Tokens("body", "fox", "text_en").WithinOrg("8675309").ByRelevance().Limit(10).All(ctx)
+// -------------------------------------
+
+type SearchView struct {
+	Connection *Connection // defaults to the Collection's connection
+	Name       string
+	Collection *Collection
+
+	// Fields maps the indexed attributes to the analyzers they're indexed with, "identity" when none are given
+	Fields map[string][]string
+	// Analyzers are custom analyzers to make sure exist before the view is set up
+	Analyzers []driver.ArangoSearchAnalyzerDefinition
+	// IncludeAllFields indexes every attribute of the documents, not just Fields
+	IncludeAllFields bool
+}
+
+func (c *SearchView) connection() *Connection {
+	if c.Connection != nil {
+		return c.Connection
+	}
+
+	return c.Collection.Connection
+}
+
+// Properties are the view properties the declaration boils down to
+func (c *SearchView) Properties() driver.ArangoSearchViewProperties {
+	fields := make(driver.ArangoSearchFields, len(c.Fields))
+	for name, analyzers := range c.Fields {
+		fields[name] = driver.ArangoSearchElementProperties{Analyzers: analyzers}
+	}
+
+	link := driver.ArangoSearchElementProperties{Fields: fields}
+	if c.IncludeAllFields {
+		includeAll := true
+		link.IncludeAllFields = &includeAll
+	}
+
+	return driver.ArangoSearchViewProperties{
+		Links: driver.ArangoSearchLinks{c.Collection.TableName: link},
+	}
+}
+
+// Initialize makes sure the analyzers exist, then creates the view or replaces the properties of the existing one
+func (c *SearchView) Initialize(ctx context.Context) error {
+	db := c.connection().Database
+
+	for _, analyzer := range c.Analyzers {
+		if _, _, err := db.EnsureAnalyzer(ctx, analyzer); err != nil {
+			return fmt.Errorf("analyzer %s: %w", analyzer.Name, err)
+		}
+	}
+
+	properties := c.Properties()
+
+	exists, err := db.ViewExists(ctx, c.Name)
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		op := c.connection().startOperation(ctx, OpView, c.Collection.TableName)
+		_, err := db.CreateArangoSearchView(op.ctx, c.Name, &properties)
+		op.end(1, err)
+		return err
+	}
+
+	view, err := db.View(ctx, c.Name)
+	if err != nil {
+		return err
+	}
+
+	searchView, err := view.ArangoSearchView()
+	if err != nil {
+		return err
+	}
+
+	op := c.connection().startOperation(ctx, OpView, c.Collection.TableName)
+	err = searchView.SetProperties(op.ctx, properties)
+	op.end(1, err)
+	return err
+}
+
+// Search starts a search, the results are records of the view's collection
+func (c *SearchView) Search() *Search {
+	filter := c.Collection.Query()
+	filter.view = c
+
+	return &Search{collectionFilter: filter}
+}
+
+// ----------------
+// Search
+// ----------------
+
+type Search struct {
+	collectionFilter *CollectionFilter
+}
+
+func (c *Search) Operator() *Operator {
+	return c.collectionFilter.Operator()
+}
+
+// Where adds a SEARCH condition, the comparisons made by Operator work as long as the attributes are indexed
+func (c *Search) Where(expression Expression) *Search {
+	c.collectionFilter.searches = append(c.collectionFilter.searches, expression)
+	return c
+}
+
+// Phrase matches documents where key contains text as a phrase, as tokenized by analyzer
+func (c *Search) Phrase(key, text, analyzer string) *Search {
+	variables := c.collectionFilter.variableFactory
+	return c.Where(&ExpressionWrapper{item: fmt.Sprintf("ANALYZER(PHRASE(%s, %s), %s)",
+		&DocumentAttribute{name: key}, variables.MakeVariable(text), variables.MakeVariable(analyzer))})
+}
+
+// Tokens matches documents where key contains any of the tokens analyzer makes of text
+func (c *Search) Tokens(key, text, analyzer string) *Search {
+	variables := c.collectionFilter.variableFactory
+	analyzerVariable := variables.MakeVariable(analyzer)
+	return c.Where(&ExpressionWrapper{item: fmt.Sprintf("ANALYZER(%s IN TOKENS(%s, %s), %s)",
+		&DocumentAttribute{name: key}, variables.MakeVariable(text), analyzerVariable, analyzerVariable)})
+}
+
+// Prefix matches documents where key starts with prefix
+func (c *Search) Prefix(key, prefix string) *Search {
+	return c.Where(&ExpressionWrapper{item: fmt.Sprintf("STARTS_WITH(%s, %s)",
+		&DocumentAttribute{name: key}, c.collectionFilter.variableFactory.MakeVariable(prefix))})
+}
+
+// Boost weighs the last condition more (or less) when ranking the results
+func (c *Search) Boost(weight float64) *Search {
+	searches := c.collectionFilter.searches
+	if len(searches) == 0 {
+		c.collectionFilter.collection.Connection.warn("Boost: there's no search condition to boost", nil)
+		return c
+	}
+
+	searches[len(searches)-1] = &ExpressionWrapper{item: fmt.Sprintf("BOOST(%s, %s)",
+		searches[len(searches)-1], c.collectionFilter.variableFactory.MakeVariable(weight))}
+	return c
+}
+
+// Filter narrows the results after the search, like CollectionFilter.Filter
+func (c *Search) Filter(key string, value interface{}) *Search {
+	c.collectionFilter.Filter(key, value)
+	return c
+}
+
+func (c *Search) WithinOrg(orgId string) *Search {
+	c.collectionFilter.WithinOrg(orgId)
+	return c
+}
+
+func (c *Search) List() *ItemsOperator {
+	return c.collectionFilter.List()
+}
+
+// ByRelevance lists the results best match first (by BM25 score)
+func (c *Search) ByRelevance() *ItemsOperator {
+	items := c.List()
+	items.orderBy = &Relevance{Scorer: "BM25"}
+	return items
+}
+
+func (c *Search) First(ctx context.Context) (interface{}, error) {
+	return c.List().First(ctx)
+}
+
+// Relevance orders search results by a scoring function, BM25 or TFIDF
+type Relevance struct {
+	Scorer string
+	Asc    bool
+}
+
+func (c *Relevance) OrderFormat() string {
+	direction := "DESC"
+	if c.Asc {
+		direction = "ASC"
+	}
+	return fmt.Sprintf("SORT %s(%s) %s ", c.Scorer, DocumentName, direction)
+}
+
+// formatSearch joins the search conditions into the SEARCH clause
+func (c *CollectionFilter) formatSearch() string {
+	if len(c.searches) == 0 {
+		return ""
+	}
+
+	clause := "SEARCH "
+	for i, search := range c.searches {
+		if i > 0 {
+			clause += " AND "
+		}
+		clause += fmt.Sprintf("%s", search)
+	}
+
+	return clause + " \n"
+}
+
+// source is what the query loops over, the view when searching
+func (c *CollectionFilter) source() string {
+	if c.view != nil {
+		return c.view.Name
+	}
+
+	return c.collection.TableName
+}
@@ -0,0 +1,123 @@
+package orm
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// -------------------------------------
+// OpenTelemetry. With Telemetry on the Connection, each database operation (the same ones
+// that are logged, see logging.go) gets a span, its latency goes in a histogram and its
+// failures are counted:
+//
+//	telemetry, err := orm.NewTelemetry(tracerProvider, meterProvider)
+//	conn.Telemetry = telemetry
+//
+// Spans carry the query with its bind variable placeholders, never the values.
+// -------------------------------------
+
+const instrumentationName = "github.com/ridelabs/simply_arango/orm"
+
+// the attributes that aren't in the semantic conventions
+const (
+	CollectionKey     = attribute.Key("db.collection.name")
+	RowsKey           = attribute.Key("db.arangodb.rows")
+	AttemptsKey       = attribute.Key("db.arangodb.attempts")
+	WritesExecutedKey = attribute.Key("db.arangodb.writes_executed")
+	WritesIgnoredKey  = attribute.Key("db.arangodb.writes_ignored")
+	ScannedFullKey    = attribute.Key("db.arangodb.scanned_full")
+	ScannedIndexKey   = attribute.Key("db.arangodb.scanned_index")
+	FilteredKey       = attribute.Key("db.arangodb.filtered")
+	ExecutionTimeKey  = attribute.Key("db.arangodb.execution_time")
+)
+
+type Telemetry struct {
+	tracer   trace.Tracer
+	duration metric.Float64Histogram
+	errors   metric.Int64Counter
+}
+
+// NewTelemetry instruments with the given providers, or the global ones when they're nil
+func NewTelemetry(tracerProvider trace.TracerProvider, meterProvider metric.MeterProvider) (*Telemetry, error) {
+	if tracerProvider == nil {
+		tracerProvider = otel.GetTracerProvider()
+	}
+	if meterProvider == nil {
+		meterProvider = otel.GetMeterProvider()
+	}
+
+	meter := meterProvider.Meter(instrumentationName)
+	duration, err := meter.Float64Histogram("db.client.operation.duration",
+		metric.WithUnit("s"), metric.WithDescription("How long ORM operations take"))
+	if err != nil {
+		return nil, err
+	}
+
+	errors, err := meter.Int64Counter("db.client.operation.errors",
+		metric.WithUnit("{error}"), metric.WithDescription("ORM operations that failed"))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Telemetry{
+		tracer:   tracerProvider.Tracer(instrumentationName),
+		duration: duration,
+		errors:   errors,
+	}, nil
+}
+
+// start starts the span of an operation, the returned context carries it
+func (c *Telemetry) start(ctx context.Context, conn *Connection, name, collection string) (context.Context, trace.Span) {
+	return c.tracer.Start(ctx, name+" "+collection,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemKey.String("arangodb"),
+			semconv.DBNameKey.String(conn.Database.Name()),
+			semconv.DBOperationKey.String(name),
+			CollectionKey.String(collection),
+		))
+}
+
+// end finishes the span and records the metrics of an operation
+func (c *Telemetry) end(op *operation, elapsed time.Duration, rows int, err error) {
+	span := op.span
+	if op.query != "" {
+		span.SetAttributes(semconv.DBStatementKey.String(op.query))
+	}
+	span.SetAttributes(RowsKey.Int(rows))
+	if op.attempts > 1 {
+		span.SetAttributes(AttemptsKey.Int(op.attempts))
+	}
+	if op.stats != nil {
+		span.SetAttributes(
+			WritesExecutedKey.Int64(op.stats.WritesExecuted()),
+			WritesIgnoredKey.Int64(op.stats.WritesIgnored()),
+			ScannedFullKey.Int64(op.stats.ScannedFull()),
+			ScannedIndexKey.Int64(op.stats.ScannedIndex()),
+			FilteredKey.Int64(op.stats.Filtered()),
+			ExecutionTimeKey.Float64(op.stats.ExecutionTime().Seconds()),
+		)
+	}
+
+	attributes := metric.WithAttributes(
+		semconv.DBSystemKey.String("arangodb"),
+		semconv.DBOperationKey.String(op.name),
+		CollectionKey.String(op.collection),
+	)
+
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		c.errors.Add(op.ctx, 1, attributes)
+	}
+
+	c.duration.Record(op.ctx, elapsed.Seconds(), attributes)
+	span.End()
+}
@@ -0,0 +1,459 @@
+package orm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/arangodb/go-driver"
+	"github.com/ridelabs/simply_arango/encoding"
+)
+
+// -------------------------------------
+// Multi-tenancy. ForOrg hands out a collection scoped to one organization, so callers
+// can't forget WithinOrg:
+//
+//	orders := collection.ForOrg(orgId)
+//	orders.Create(ctx, &Order{...})                      // stamped with orgId
+//	order, err := orders.Get(ctx, id)                    // not found if it's another org's
+//	open, err := orders.Query().Filter("open", true).List().All(ctx)
+//	results, err := orders.UpdateMany(ctx, changed)      // each one checked and stamped
+//
+// Setting RequireOrg on the Collection makes any query without WithinOrg fail, to catch
+// the places that still use the collection directly. Scoped queries also only include and
+// traverse the organization's documents, except from collections marked NoOrganization.
+// -------------------------------------
+
+var ErrUnscopedQuery = errors.New("query isn't scoped to an organization")
+
+var ErrWrongOrg = errors.New("record belongs to another organization")
+
+func (c *CollectionFilter) checkScope() error {
+	if c.orgScoped && c.orgId == "" {
+		return fmt.Errorf("%s: %w, the organization id is empty", c.collection.TableName, ErrUnscopedQuery)
+	}
+	if c.collection.RequireOrg && !c.orgScoped {
+		return fmt.Errorf("%s: %w", c.collection.TableName, ErrUnscopedQuery)
+	}
+
+	return nil
+}
+
+// orgVariable binds the organization of a scoped query, for filtering the other documents it reads
+// (included documents, traversed vertices). It's nil when the query isn't scoped.
+func (c *CollectionFilter) orgVariable() Variable {
+	if !c.orgScoped {
+		return nil
+	}
+
+	return c.variableFactory.MakeVariable(c.orgId)
+}
+
+// organizationIdKey is OrganizationIdKey, with Initialize's default for collections that weren't initialized
+func (c *Collection) organizationIdKey() string {
+	if c.OrganizationIdKey == "" {
+		return "organization_id"
+	}
+
+	return c.OrganizationIdKey
+}
+
+// organizationKey is the attribute holding the organization of the collection's documents,
+// empty for NoOrganization collections
+func (c *Collection) organizationKey() string {
+	if c.NoOrganization {
+		return ""
+	}
+
+	return c.organizationIdKey()
+}
+
+type OrgCollection struct {
+	collection *Collection
+	orgId      string
+}
+
+// ForOrg scopes the collection to an organization
+func (c *Collection) ForOrg(orgId string) *OrgCollection {
+	return &OrgCollection{collection: c, orgId: orgId}
+}
+
+func (c *OrgCollection) OrgId() string {
+	return c.orgId
+}
+
+// Query starts a query that only sees the organization's documents
+func (c *OrgCollection) Query() *CollectionFilter {
+	return c.collection.Query().WithinOrg(c.orgId)
+}
+
+// Get reads a document, documents of other organizations are reported as not found
+func (c *OrgCollection) Get(ctx context.Context, id string) (interface{}, error) {
+	obj, err := c.Query().ById(id).First(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if obj == nil {
+		return nil, c.notFound(id)
+	}
+
+	return obj, nil
+}
+
+// Create stamps the organization on the object and creates it
+func (c *OrgCollection) Create(ctx context.Context, obj interface{}) (string, error) {
+	if err := c.stamp(obj); err != nil {
+		return "", err
+	}
+
+	return c.collection.Create(ctx, obj)
+}
+
+// Update updates a document of the organization, the object can't move it to another one.
+// The ownership check and the write are one statement.
+func (c *OrgCollection) Update(ctx context.Context, obj interface{}) error {
+	collection := c.collection
+	if err := collection.beforeUpdate(ctx, obj); err != nil {
+		return err
+	}
+	if err := c.stamp(obj); err != nil {
+		return err
+	}
+	if err := collection.validate(obj); err != nil {
+		return err
+	}
+
+	doc, err := encoding.ObjectToMap(obj)
+	if err != nil {
+		return err
+	}
+
+	id, err := getId(doc)
+	if err != nil {
+		return err
+	}
+
+	delete(doc, "id") // don't store the id in the database record
+	_, rev := withRevision(ctx, doc)
+
+	filter := c.Query().ById(id)
+	if err := filter.checkScope(); err != nil {
+		return err
+	}
+
+	query := fmt.Sprintf(`
+FOR doc IN @@collection
+ %s
+ UPDATE %s WITH %s IN @@collection%s
+ RETURN NEW._rev`, filter.formatExpressions(), filter.revisionKey(rev), filter.variableFactory.MakeVariable(doc), revisionOptions(rev))
+
+	newRev, err := c.write(ctx, OpUpdate, filter, query, id, rev)
+	if err != nil {
+		return err
+	}
+
+	return storeRevision(obj, newRev)
+}
+
+// Delete removes a document of the organization, in one statement with the ownership check
+func (c *OrgCollection) Delete(ctx context.Context, obj interface{}) error {
+	if err := c.collection.beforeDelete(ctx, obj); err != nil {
+		return err
+	}
+
+	doc, err := encoding.ObjectToMap(obj)
+	if err != nil {
+		return err
+	}
+
+	id, err := getId(doc)
+	if err != nil {
+		return err
+	}
+
+	_, rev := withRevision(ctx, doc)
+
+	filter := c.Query().ById(id)
+	if err := filter.checkScope(); err != nil {
+		return err
+	}
+
+	query := fmt.Sprintf(`
+FOR doc IN @@collection
+ %s
+ REMOVE %s IN @@collection%s
+ RETURN OLD._rev`, filter.formatExpressions(), filter.revisionKey(rev), revisionOptions(rev))
+
+	_, err = c.write(ctx, OpDelete, filter, query, id, rev)
+	return err
+}
+
+// Increment adds 1 to a field of the organization's record, not found if it's another organization's
+func (c *OrgCollection) Increment(ctx context.Context, obj interface{}, varName string) error {
+	doc, err := encoding.ObjectToMap(obj)
+	if err != nil {
+		return err
+	}
+
+	id, err := getId(doc)
+	if err != nil {
+		return err
+	}
+
+	update := c.Atomic(id).Inc(varName, 1)
+	update.operation = OpIncrement
+
+	_, err = update.One(ctx)
+	return err
+}
+
+// CreateMany stamps the organization on each object and creates them, objects of another organization are refused
+func (c *OrgCollection) CreateMany(ctx context.Context, objs []interface{}) (BulkResults, error) {
+	return c.writeMany(ctx, objs, true, false, c.collection.CreateMany)
+}
+
+// UpdateMany updates the organization's documents, see Collection.UpdateMany. Documents of other
+// organizations are reported as not found, and the objects can't move documents to another one.
+func (c *OrgCollection) UpdateMany(ctx context.Context, objs []interface{}) (BulkResults, error) {
+	return c.writeMany(ctx, objs, true, true, c.collection.UpdateMany)
+}
+
+// ReplaceMany replaces the organization's documents, see Collection.ReplaceMany and UpdateMany
+func (c *OrgCollection) ReplaceMany(ctx context.Context, objs []interface{}) (BulkResults, error) {
+	return c.writeMany(ctx, objs, true, true, c.collection.ReplaceMany)
+}
+
+// DeleteMany removes the organization's documents, documents of other organizations are reported as not found
+func (c *OrgCollection) DeleteMany(ctx context.Context, objs []interface{}) (BulkResults, error) {
+	return c.writeMany(ctx, objs, false, true, c.collection.DeleteMany)
+}
+
+// writeMany hands the objects that could be stamped (when stamp is set) and that the organization
+// owns (when checkOwner is set) to write, the others get their error in the results. Arango's batch
+// apis can't filter, so ownership is checked by a query first. Scoped writes never move a document
+// to another organization, so only unscoped writes in between could change the answer.
+func (c *OrgCollection) writeMany(ctx context.Context, objs []interface{}, stamp, checkOwner bool,
+	write func(ctx context.Context, objs []interface{}) (BulkResults, error)) (BulkResults, error) {
+	if c.orgId == "" {
+		return nil, fmt.Errorf("%s: %w, the organization id is empty", c.collection.TableName, ErrUnscopedQuery)
+	}
+
+	results := make(BulkResults, len(objs))
+	ids := make([]string, len(objs))
+	for i, obj := range objs {
+		if stamp {
+			if err := c.stamp(obj); err != nil {
+				results[i].Err = err
+				continue
+			}
+		}
+		if !checkOwner {
+			continue
+		}
+
+		doc, err := encoding.ObjectToMap(obj)
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+		if ids[i], err = getId(doc); err != nil {
+			results[i].Err = err
+		}
+	}
+
+	if checkOwner {
+		owned, err := c.owned(ctx, ids)
+		if err != nil {
+			results.fail(pendingPositions(results), err)
+			return results, err
+		}
+		for i := range objs {
+			if results[i].Err == nil && !owned[ids[i]] {
+				results[i].Err = c.notFound(ids[i])
+			}
+		}
+	}
+
+	positions := pendingPositions(results)
+	if len(positions) == 0 {
+		return results, nil
+	}
+
+	pending := make([]interface{}, len(positions))
+	for j, i := range positions {
+		pending[j] = objs[i]
+	}
+
+	written, err := write(ctx, pending)
+	if written == nil {
+		results.fail(positions, err)
+		return results, err
+	}
+	for j, i := range positions {
+		results[i] = written[j]
+	}
+
+	return results, err
+}
+
+// pendingPositions are the positions of the results without an error so far
+func pendingPositions(results BulkResults) []int {
+	positions := make([]int, 0, len(results))
+	for i, result := range results {
+		if result.Err == nil {
+			positions = append(positions, i)
+		}
+	}
+
+	return positions
+}
+
+// owned tells which of the ids are documents of the organization
+func (c *OrgCollection) owned(ctx context.Context, ids []string) (map[string]bool, error) {
+	keys := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if id != "" {
+			keys = append(keys, id)
+		}
+	}
+
+	owned := make(map[string]bool)
+	if len(keys) == 0 {
+		return owned, nil
+	}
+
+	filter := c.Query()
+	filter.Where(&InArrayExpression{value: &DocumentAttribute{name: "_key"}, arrayName: filter.variableFactory.MakeVariable(keys)})
+
+	query := fmt.Sprintf(`
+FOR doc IN @@collection
+ %s
+ RETURN doc._key`, filter.formatExpressions())
+	variables := filter.variableFactory.SymbolTable()
+	variables["@collection"] = c.collection.TableName
+
+	cursor, err := c.collection.Connection.query(ctx, OpQuery, c.collection.TableName, query, variables)
+	if err != nil {
+		return nil, err
+	}
+
+	defer cursor.Close()
+
+	for cursor.HasMore() {
+		var key string
+		if _, err := cursor.ReadDocument(ctx, &key); err != nil {
+			return nil, err
+		}
+		owned[key] = true
+	}
+
+	return owned, nil
+}
+
+// revisionKey is the document to write, with the revision it must still have when the record carries one
+func (c *CollectionFilter) revisionKey(rev string) string {
+	if rev == "" {
+		return DocumentName
+	}
+
+	return fmt.Sprintf("{ _key: %s._key, _rev: %s }", DocumentName, c.variableFactory.MakeVariable(rev))
+}
+
+func revisionOptions(rev string) string {
+	if rev == "" {
+		return ""
+	}
+
+	return " OPTIONS { ignoreRevs: false }"
+}
+
+// write runs an update or remove of one document, returning its revision. No row means the organization doesn't have it.
+func (c *OrgCollection) write(ctx context.Context, operation string, filter *CollectionFilter, query, id, rev string) (string, error) {
+	variables := filter.variableFactory.SymbolTable()
+	variables["@collection"] = c.collection.TableName
+
+	cursor, err := c.collection.Connection.query(ctx, operation, c.collection.TableName, query, variables)
+	if err != nil {
+		return "", c.collection.conflictError(err, id, rev)
+	}
+
+	defer cursor.Close()
+
+	if !cursor.HasMore() {
+		return "", c.notFound(id)
+	}
+
+	var written string
+	if _, err := cursor.ReadDocument(ctx, &written); err != nil {
+		return "", err
+	}
+
+	return written, nil
+}
+
+// stamp sets the organization on an object that doesn't have one, and refuses one with another
+func (c *OrgCollection) stamp(obj interface{}) error {
+	key := c.collection.organizationKey()
+	if key == "" {
+		return fmt.Errorf("%s: records of NoOrganization collections don't belong to an organization", c.collection.TableName)
+	}
+	if c.orgId == "" {
+		return fmt.Errorf("%s: %w, the organization id is empty", c.collection.TableName, ErrUnscopedQuery)
+	}
+
+	// maps are stamped directly, decoding into them doesn't set anything
+	var doc map[string]interface{}
+	switch record := obj.(type) {
+	case map[string]interface{}:
+		doc = record
+	case *map[string]interface{}:
+		if *record == nil {
+			*record = make(map[string]interface{})
+		}
+		doc = *record
+	}
+	if doc != nil {
+		switch current := doc[key]; current {
+		case nil, "", c.orgId:
+			doc[key] = c.orgId
+			return nil
+		default:
+			return ErrWrongOrg
+		}
+	}
+
+	doc, err := encoding.ObjectToMap(obj)
+	if err != nil {
+		return err
+	}
+
+	switch current := doc[key]; current {
+	case nil, "", c.orgId:
+	default:
+		return ErrWrongOrg
+	}
+
+	if err := encoding.MapToObject(map[string]interface{}{key: c.orgId}, obj); err != nil {
+		return err
+	}
+
+	// records without the field would be stored without an organization, and nobody could see them
+	if doc, err = encoding.ObjectToMap(obj); err != nil {
+		return err
+	}
+	if doc[key] != c.orgId {
+		return fmt.Errorf("%s: records of type %T have no %s field to hold the organization", c.collection.TableName, obj, key)
+	}
+
+	return nil
+}
+
+// notFound is the error for documents that don't exist, or that the organization can't see
+func (c *OrgCollection) notFound(id string) error {
+	return driver.ArangoError{
+		HasError:     true,
+		Code:         404,
+		ErrorNum:     driver.ErrArangoDocumentNotFound,
+		ErrorMessage: fmt.Sprintf("%s/%s not found", c.collection.TableName, id),
+	}
+}
@@ -0,0 +1,101 @@
+package orm
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/arangodb/go-driver"
+)
+
+// ----------------------
+// Stream transactions
+// ----------------------
+
+type transactionKey struct{}
+
+// transaction is what the context carries, failed is the first error of a nested RunInTransaction
+type transaction struct {
+	id driver.TransactionID
+
+	mu     sync.Mutex
+	failed error
+}
+
+func (c *transaction) fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.failed == nil {
+		c.failed = err
+	}
+}
+
+func (c *transaction) rollbackOnly() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.failed
+}
+
+// RunInTransaction starts an arangodb stream transaction and runs fn with a context carrying the transaction id.
+// Every Collection and CollectionFilter operation that is handed that context takes part in the transaction.
+// The transaction is committed when fn returns nil, and aborted when it returns an error or panics.
+// If ctx is already inside a transaction, fn simply joins it. An error from such a nested call marks
+// the transaction rollback-only, so it's aborted (and the error returned) even if the outer fn ignores it.
+func (c *Connection) RunInTransaction(ctx context.Context, readCols, writeCols []string, fn func(ctx context.Context) error) (err error) {
+	if tx, ok := ctx.Value(transactionKey{}).(*transaction); ok {
+		if err := fn(ctx); err != nil {
+			tx.fail(err)
+			return err
+		}
+		return nil
+	}
+
+	tid, err := c.Database.BeginTransaction(ctx, driver.TransactionCollections{
+		Read:  readCols,
+		Write: writeCols,
+	}, nil)
+	if err != nil {
+		return err
+	}
+
+	tx := &transaction{id: tid}
+	txCtx := context.WithValue(driver.WithTransactionID(ctx, tid), transactionKey{}, tx)
+
+	defer func() {
+		if r := recover(); r != nil {
+			_ = c.Database.AbortTransaction(ctx, tid, nil)
+			panic(r)
+		}
+	}()
+
+	err = fn(txCtx)
+	if err == nil {
+		if failed := tx.rollbackOnly(); failed != nil {
+			err = fmt.Errorf("transaction %s is rollback-only after a nested call failed: %w", tid, failed)
+		}
+	}
+	if err != nil {
+		if abortErr := c.Database.AbortTransaction(ctx, tid, nil); abortErr != nil {
+			return fmt.Errorf("%w (abort of transaction %s also failed: %s)", err, tid, abortErr)
+		}
+		return err
+	}
+
+	return c.Database.CommitTransaction(ctx, tid, nil)
+}
+
+// TransactionId returns the id of the stream transaction the context belongs to, if any
+func TransactionId(ctx context.Context) (driver.TransactionID, bool) {
+	tx, ok := ctx.Value(transactionKey{}).(*transaction)
+	if !ok {
+		return "", false
+	}
+	return tx.id, true
+}
+
+func InTransaction(ctx context.Context) bool {
+	_, ok := TransactionId(ctx)
+	return ok
+}
@@ -0,0 +1,508 @@
+package orm
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// -------------------------------------
+// Generic, type-safe wrappers around Collection, CollectionFilter and ItemsOperator.
+// Go won't let Collection and Collection[T] live side by side, so the generic
+// flavors are named TypedCollection[T], TypedCollectionFilter[T], etc. They share
+// all the query building with the untyped versions and just convert the results.
+//
+// Aggregate, Atomic and Upsert aren't wrapped, they don't return records: use them
+// through the embedded CollectionFilter.
+// -------------------------------------
+
+type TypedCollection[T any] struct {
+	*Collection
+}
+
+// NewTypedCollection makes a collection whose records are allocated as *T, no AllocateRecord needed
+func NewTypedCollection[T any](conn *Connection, tableName string) *TypedCollection[T] {
+	return Typed[T](&Collection{
+		Connection:        conn,
+		TableName:         tableName,
+		OrganizationIdKey: "organization_id",
+	})
+}
+
+// Typed wraps a copy of an existing collection, so the original is left alone. If the collection
+// doesn't have an AllocateRecord, the copy gets one for T.
+func Typed[T any](collection *Collection) *TypedCollection[T] {
+	copied := *collection
+	if copied.AllocateRecord == nil {
+		copied.AllocateRecord = func() interface{} {
+			return new(T)
+		}
+	}
+
+	return &TypedCollection[T]{Collection: &copied}
+}
+
+func (c *TypedCollection[T]) Get(ctx context.Context, id string) (*T, error) {
+	obj, err := c.Collection.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return castRecord[T](obj)
+}
+
+func (c *TypedCollection[T]) Create(ctx context.Context, record *T) (string, error) {
+	return c.Collection.Create(ctx, record)
+}
+
+func (c *TypedCollection[T]) Update(ctx context.Context, record *T) error {
+	return c.Collection.Update(ctx, record)
+}
+
+func (c *TypedCollection[T]) Delete(ctx context.Context, record *T) error {
+	return c.Collection.Delete(ctx, record)
+}
+
+func (c *TypedCollection[T]) CreateMany(ctx context.Context, records []*T) (BulkResults, error) {
+	return c.Collection.CreateMany(ctx, toObjects(records))
+}
+
+func (c *TypedCollection[T]) UpdateMany(ctx context.Context, records []*T) (BulkResults, error) {
+	return c.Collection.UpdateMany(ctx, toObjects(records))
+}
+
+func (c *TypedCollection[T]) ReplaceMany(ctx context.Context, records []*T) (BulkResults, error) {
+	return c.Collection.ReplaceMany(ctx, toObjects(records))
+}
+
+func (c *TypedCollection[T]) DeleteMany(ctx context.Context, records []*T) (BulkResults, error) {
+	return c.Collection.DeleteMany(ctx, toObjects(records))
+}
+
+func (c *TypedCollection[T]) Query() *TypedCollectionFilter[T] {
+	return &TypedCollectionFilter[T]{CollectionFilter: c.Collection.Query()}
+}
+
+// ----------------
+// TypedCollectionFilter
+// ----------------
+
+type TypedCollectionFilter[T any] struct {
+	*CollectionFilter
+}
+
+func (c *TypedCollectionFilter[T]) Where(expression Expression) *TypedCollectionFilter[T] {
+	c.CollectionFilter.Where(expression)
+	return c
+}
+
+func (c *TypedCollectionFilter[T]) Filter(key string, value interface{}) *TypedCollectionFilter[T] {
+	c.CollectionFilter.Filter(key, value)
+	return c
+}
+
+func (c *TypedCollectionFilter[T]) InArrayOfDocuments(value string, arrayName string, documentKey string) *TypedCollectionFilter[T] {
+	c.CollectionFilter.InArrayOfDocuments(value, arrayName, documentKey)
+	return c
+}
+
+func (c *TypedCollectionFilter[T]) InArray(value string, arrayName string) *TypedCollectionFilter[T] {
+	c.CollectionFilter.InArray(value, arrayName)
+	return c
+}
+
+func (c *TypedCollectionFilter[T]) ArrayEmpty(arrayName string) *TypedCollectionFilter[T] {
+	c.CollectionFilter.ArrayEmpty(arrayName)
+	return c
+}
+
+func (c *TypedCollectionFilter[T]) WithinOrg(orgId string) *TypedCollectionFilter[T] {
+	c.CollectionFilter.WithinOrg(orgId)
+	return c
+}
+
+func (c *TypedCollectionFilter[T]) ById(docId string) *TypedCollectionFilter[T] {
+	c.CollectionFilter.ById(docId)
+	return c
+}
+
+func (c *TypedCollectionFilter[T]) First(ctx context.Context) (*T, error) {
+	return c.List().First(ctx)
+}
+
+func (c *TypedCollectionFilter[T]) List() *TypedItemsOperator[T] {
+	return &TypedItemsOperator[T]{ItemsOperator: c.CollectionFilter.List()}
+}
+
+// Traverse walks the graph from the matched documents, the vertices are read as *T.
+// TraverseInto reads them from another collection.
+func (c *TypedCollectionFilter[T]) Traverse(edges *EdgeCollection) *TypedTraversal[T] {
+	return &TypedTraversal[T]{Traversal: c.CollectionFilter.Traverse(edges)}
+}
+
+// Search looks the documents up in view, which indexes this collection, keeping the filters so far
+func (c *TypedCollectionFilter[T]) Search(view *SearchView) *TypedSearch[T] {
+	c.CollectionFilter.view = view
+	return &TypedSearch[T]{Search: &Search{collectionFilter: c.CollectionFilter}}
+}
+
+// ------------------
+// TypedItemsOperator
+// ------------------
+
+type TypedItemsOperator[T any] struct {
+	*ItemsOperator
+}
+
+type TypedOrderBy[T any] struct {
+	orderBy *OrderBy
+	items   *TypedItemsOperator[T]
+}
+
+func (c *TypedOrderBy[T]) Desc() *TypedItemsOperator[T] {
+	c.orderBy.Desc()
+	return c.items
+}
+
+func (c *TypedOrderBy[T]) Asc() *TypedItemsOperator[T] {
+	c.orderBy.Asc()
+	return c.items
+}
+
+func (c *TypedItemsOperator[T]) OrderBy(key string) *TypedOrderBy[T] {
+	return &TypedOrderBy[T]{orderBy: c.ItemsOperator.OrderBy(key), items: c}
+}
+
+func (c *TypedItemsOperator[T]) RandomOrder() *TypedItemsOperator[T] {
+	c.ItemsOperator.RandomOrder()
+	return c
+}
+
+func (c *TypedItemsOperator[T]) SortByDistance(attribute string, latitude, longitude float64) *TypedItemsOperator[T] {
+	c.ItemsOperator.SortByDistance(attribute, latitude, longitude)
+	return c
+}
+
+func (c *TypedItemsOperator[T]) Paging(pageSize, page int) *TypedItemsOperator[T] {
+	c.ItemsOperator.Paging(pageSize, page)
+	return c
+}
+
+func (c *TypedItemsOperator[T]) Limit(count int) *TypedItemsOperator[T] {
+	c.ItemsOperator.Limit(count)
+	return c
+}
+
+func (c *TypedItemsOperator[T]) BatchSize(size int) *TypedItemsOperator[T] {
+	c.ItemsOperator.BatchSize(size)
+	return c
+}
+
+func (c *TypedItemsOperator[T]) TTL(ttl time.Duration) *TypedItemsOperator[T] {
+	c.ItemsOperator.TTL(ttl)
+	return c
+}
+
+func (c *TypedItemsOperator[T]) After(token string) *TypedItemsOperator[T] {
+	c.ItemsOperator.After(token)
+	return c
+}
+
+func (c *TypedItemsOperator[T]) Select(keys ...string) *TypedItemsOperator[T] {
+	c.ItemsOperator.Select(keys...)
+	return c
+}
+
+func (c *TypedItemsOperator[T]) Omit(keys ...string) *TypedItemsOperator[T] {
+	c.ItemsOperator.Omit(keys...)
+	return c
+}
+
+func (c *TypedItemsOperator[T]) Include(foreignKey string, related *Collection, field string) *TypedItemsOperator[T] {
+	c.ItemsOperator.Include(foreignKey, related, field)
+	return c
+}
+
+// ProjectAs decodes the results as *S instead, usually a slimmer struct that goes with a Select:
+//
+//	names, err := orm.ProjectAs[UserName](users.Query().List().Select("name")).All(ctx)
+func ProjectAs[S any, T any](items *TypedItemsOperator[T]) *TypedItemsOperator[S] {
+	items.ItemsOperator.As(func() interface{} {
+		return new(S)
+	})
+
+	return &TypedItemsOperator[S]{ItemsOperator: items.ItemsOperator}
+}
+
+func (c *TypedItemsOperator[T]) Iterate(ctx context.Context) (*TypedIterator[T], error) {
+	it, err := c.ItemsOperator.Iterate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TypedIterator[T]{Iterator: it}, nil
+}
+
+func (c *TypedItemsOperator[T]) First(ctx context.Context) (*T, error) {
+	obj, err := c.ItemsOperator.First(ctx)
+	if err != nil || obj == nil {
+		return nil, err
+	}
+
+	return castRecord[T](obj)
+}
+
+func (c *TypedItemsOperator[T]) All(ctx context.Context) ([]*T, error) {
+	objects, err := c.ItemsOperator.All(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return castRecords[T](objects)
+}
+
+type TypedIterator[T any] struct {
+	*Iterator
+}
+
+func (c *TypedIterator[T]) Next() bool {
+	if !c.Iterator.Next() {
+		return false
+	}
+
+	if _, err := castRecord[T](c.Iterator.Item()); err != nil {
+		c.err = err
+		_ = c.Close()
+		return false
+	}
+
+	return true
+}
+
+func (c *TypedIterator[T]) Item() *T {
+	record, _ := c.Iterator.Item().(*T)
+	return record
+}
+
+// --------------
+// TypedTraversal
+// --------------
+
+type TypedTraversal[V any] struct {
+	*Traversal
+}
+
+// TraverseInto reads the vertices the traversal finds from another collection, as *V:
+//
+//	teams, err := orm.TraverseInto(users.Query().ById(userId).Traverse(memberships), teams).All(ctx)
+func TraverseInto[V any, T any](traversal *TypedTraversal[T], vertices *TypedCollection[V]) *TypedTraversal[V] {
+	traversal.Traversal.Into(vertices.Collection)
+	return &TypedTraversal[V]{Traversal: traversal.Traversal}
+}
+
+// Into reads the vertices from another collection of *V records
+func (c *TypedTraversal[V]) Into(vertices *TypedCollection[V]) *TypedTraversal[V] {
+	c.Traversal.Into(vertices.Collection)
+	return c
+}
+
+func (c *TypedTraversal[V]) Outbound() *TypedTraversal[V] {
+	c.Traversal.Outbound()
+	return c
+}
+
+func (c *TypedTraversal[V]) Inbound() *TypedTraversal[V] {
+	c.Traversal.Inbound()
+	return c
+}
+
+func (c *TypedTraversal[V]) Any() *TypedTraversal[V] {
+	c.Traversal.Any()
+	return c
+}
+
+func (c *TypedTraversal[V]) Depth(min, max int) *TypedTraversal[V] {
+	c.Traversal.Depth(min, max)
+	return c
+}
+
+func (c *TypedTraversal[V]) Where(expression Expression) *TypedTraversal[V] {
+	c.Traversal.Where(expression)
+	return c
+}
+
+func (c *TypedTraversal[V]) Filter(key string, value interface{}) *TypedTraversal[V] {
+	c.Traversal.Filter(key, value)
+	return c
+}
+
+func (c *TypedTraversal[V]) FilterEdge(key string, value interface{}) *TypedTraversal[V] {
+	c.Traversal.FilterEdge(key, value)
+	return c
+}
+
+func (c *TypedTraversal[V]) Limit(count int) *TypedTraversal[V] {
+	c.Traversal.Limit(count)
+	return c
+}
+
+func (c *TypedTraversal[V]) First(ctx context.Context) (*V, error) {
+	obj, err := c.Traversal.First(ctx)
+	if err != nil || obj == nil {
+		return nil, err
+	}
+
+	return castRecord[V](obj)
+}
+
+func (c *TypedTraversal[V]) All(ctx context.Context) ([]*V, error) {
+	objects, err := c.Traversal.All(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return castRecords[V](objects)
+}
+
+// -----------
+// TypedSearch
+// -----------
+
+type TypedSearch[T any] struct {
+	*Search
+}
+
+func (c *TypedSearch[T]) Where(expression Expression) *TypedSearch[T] {
+	c.Search.Where(expression)
+	return c
+}
+
+func (c *TypedSearch[T]) Phrase(key, text, analyzer string) *TypedSearch[T] {
+	c.Search.Phrase(key, text, analyzer)
+	return c
+}
+
+func (c *TypedSearch[T]) Tokens(key, text, analyzer string) *TypedSearch[T] {
+	c.Search.Tokens(key, text, analyzer)
+	return c
+}
+
+func (c *TypedSearch[T]) Prefix(key, prefix string) *TypedSearch[T] {
+	c.Search.Prefix(key, prefix)
+	return c
+}
+
+func (c *TypedSearch[T]) Boost(weight float64) *TypedSearch[T] {
+	c.Search.Boost(weight)
+	return c
+}
+
+func (c *TypedSearch[T]) Filter(key string, value interface{}) *TypedSearch[T] {
+	c.Search.Filter(key, value)
+	return c
+}
+
+func (c *TypedSearch[T]) WithinOrg(orgId string) *TypedSearch[T] {
+	c.Search.WithinOrg(orgId)
+	return c
+}
+
+func (c *TypedSearch[T]) List() *TypedItemsOperator[T] {
+	return &TypedItemsOperator[T]{ItemsOperator: c.Search.List()}
+}
+
+func (c *TypedSearch[T]) ByRelevance() *TypedItemsOperator[T] {
+	return &TypedItemsOperator[T]{ItemsOperator: c.Search.ByRelevance()}
+}
+
+func (c *TypedSearch[T]) First(ctx context.Context) (*T, error) {
+	return c.List().First(ctx)
+}
+
+// ------------------
+// TypedOrgCollection
+// ------------------
+
+type TypedOrgCollection[T any] struct {
+	*OrgCollection
+}
+
+func (c *TypedCollection[T]) ForOrg(orgId string) *TypedOrgCollection[T] {
+	return &TypedOrgCollection[T]{OrgCollection: c.Collection.ForOrg(orgId)}
+}
+
+func (c *TypedOrgCollection[T]) Query() *TypedCollectionFilter[T] {
+	return &TypedCollectionFilter[T]{CollectionFilter: c.OrgCollection.Query()}
+}
+
+func (c *TypedOrgCollection[T]) Get(ctx context.Context, id string) (*T, error) {
+	obj, err := c.OrgCollection.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return castRecord[T](obj)
+}
+
+func (c *TypedOrgCollection[T]) Create(ctx context.Context, record *T) (string, error) {
+	return c.OrgCollection.Create(ctx, record)
+}
+
+func (c *TypedOrgCollection[T]) Update(ctx context.Context, record *T) error {
+	return c.OrgCollection.Update(ctx, record)
+}
+
+func (c *TypedOrgCollection[T]) Delete(ctx context.Context, record *T) error {
+	return c.OrgCollection.Delete(ctx, record)
+}
+
+func (c *TypedOrgCollection[T]) CreateMany(ctx context.Context, records []*T) (BulkResults, error) {
+	return c.OrgCollection.CreateMany(ctx, toObjects(records))
+}
+
+func (c *TypedOrgCollection[T]) UpdateMany(ctx context.Context, records []*T) (BulkResults, error) {
+	return c.OrgCollection.UpdateMany(ctx, toObjects(records))
+}
+
+func (c *TypedOrgCollection[T]) ReplaceMany(ctx context.Context, records []*T) (BulkResults, error) {
+	return c.OrgCollection.ReplaceMany(ctx, toObjects(records))
+}
+
+func (c *TypedOrgCollection[T]) DeleteMany(ctx context.Context, records []*T) (BulkResults, error) {
+	return c.OrgCollection.DeleteMany(ctx, toObjects(records))
+}
+
+// ----------------
+// helpers for converting the untyped records
+// ----------------
+
+func castRecord[T any](obj interface{}) (*T, error) {
+	record, ok := obj.(*T)
+	if !ok {
+		return nil, fmt.Errorf("expected a record of type %T but AllocateRecord produced %T", record, obj)
+	}
+
+	return record, nil
+}
+
+func toObjects[T any](records []*T) []interface{} {
+	objects := make([]interface{}, len(records))
+	for i, record := range records {
+		objects[i] = record
+	}
+
+	return objects
+}
+
+func castRecords[T any](objects []interface{}) ([]*T, error) {
+	records := make([]*T, 0, len(objects))
+	for _, obj := range objects {
+		record, err := castRecord[T](obj)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+
+	return records, nil
+}
@@ -0,0 +1,207 @@
+package orm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// -------------------------------------
+// Filtered updates. UpdateAll sets fields on every matching document. Plain values go in
+// bind variables, the ORM's expressions (made with the filter's Operator) go in the query
+// as they are, so fields can be computed from the document:
+//
+//	filter := collection.Query().Filter("team", "red")
+//	op := filter.Operator()
+//	ids, err := filter.UpdateAll(ctx, map[string]interface{}{
+//		"counter":    op.Add(op.Attribute("counter"), 1),
+//		"updated_at": op.Call("DATE_NOW"),
+//		"stats":      op.Merge("stats", map[string]interface{}{"seen": true}),
+//		"team":       "blue",
+//	})
+//
+// UpdateAllWith takes UpdateOptions, and can return the documents before and after.
+// -------------------------------------
+
+type UpdateOptions struct {
+	DropNulls      bool // remove the fields set to nil instead of storing null (keepNull: false)
+	ReplaceObjects bool // replace object fields instead of merging them with the update (mergeObjects: false)
+	IgnoreErrors   bool // skip the documents that fail to update instead of failing the query
+
+	ReturnOld bool // fill UpdateResult.Old
+	ReturnNew bool // fill UpdateResult.New
+}
+
+// UpdateResult is one updated document, Old and New are records of the collection
+type UpdateResult struct {
+	Key string
+	Old interface{}
+	New interface{}
+}
+
+// ormPackage is where expressions come from. Other values with a String method (like time.Time) are still values.
+var ormPackage = reflect.TypeOf(Collection{}).PkgPath()
+
+// isExpression tells if an update value is AQL made by the ORM rather than a value to bind
+func isExpression(value interface{}) bool {
+	if _, ok := value.(Expression); !ok {
+		return false
+	}
+
+	kind := reflect.TypeOf(value)
+	if kind.Kind() == reflect.Ptr {
+		kind = kind.Elem()
+	}
+	return kind.PkgPath() == ormPackage
+}
+
+func (c *CollectionFilter) formatUpdates(updates map[string]interface{}) (string, error) {
+	names := make([]string, 0, len(updates))
+	for name := range updates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fields := make([]string, len(names))
+	for i, name := range names {
+		if name == "_key" || name == "_id" || name == "_rev" {
+			return "", fmt.Errorf("%s can't be updated", name)
+		}
+
+		quoted, err := json.Marshal(name)
+		if err != nil {
+			return "", err
+		}
+
+		value := updates[name]
+		if isExpression(value) {
+			fields[i] = fmt.Sprintf("%s: %s", quoted, value)
+		} else {
+			fields[i] = fmt.Sprintf("%s: %s", quoted, c.variableFactory.MakeVariable(value))
+		}
+	}
+
+	return "{ " + strings.Join(fields, ", ") + " }", nil
+}
+
+func (c *UpdateOptions) format() string {
+	options := make([]string, 0, 3)
+	if c.DropNulls {
+		options = append(options, "keepNull: false")
+	}
+	if c.ReplaceObjects {
+		options = append(options, "mergeObjects: false")
+	}
+	if c.IgnoreErrors {
+		options = append(options, "ignoreErrors: true")
+	}
+
+	if len(options) == 0 {
+		return ""
+	}
+	return " OPTIONS { " + strings.Join(options, ", ") + " }"
+}
+
+func (c *UpdateOptions) returns() string {
+	if !c.ReturnOld && !c.ReturnNew {
+		return "NEW._key"
+	}
+
+	fields := []string{"key: NEW._key"}
+	if c.ReturnOld {
+		fields = append(fields, "old: OLD")
+	}
+	if c.ReturnNew {
+		fields = append(fields, "new: NEW")
+	}
+	return "{ " + strings.Join(fields, ", ") + " }"
+}
+
+func (c *CollectionFilter) updateQuery(updates map[string]interface{}, options UpdateOptions) (string, map[string]interface{}, error) {
+	if len(updates) == 0 {
+		return "", nil, fmt.Errorf("update of %s has nothing to update", c.collection.TableName)
+	}
+	if err := c.checkScope(); err != nil {
+		return "", nil, err
+	}
+
+	fields, err := c.formatUpdates(updates)
+	if err != nil {
+		return "", nil, err
+	}
+
+	query := fmt.Sprintf(`
+FOR doc IN @@collection
+ %s
+ UPDATE doc WITH %s IN @@collection%s
+ RETURN %s`, c.formatExpressions(), fields, options.format(), options.returns())
+
+	variables := c.variableFactory.SymbolTable()
+	variables["@collection"] = c.collection.TableName
+
+	return query, variables, nil
+}
+
+// UpdateAllWith updates every matching document like UpdateAll, with options
+func (c *CollectionFilter) UpdateAllWith(ctx context.Context, updates map[string]interface{}, options UpdateOptions) ([]UpdateResult, error) {
+	query, variables, err := c.updateQuery(updates, options)
+	if err != nil {
+		return nil, err
+	}
+
+	cursor, err := c.collection.Connection.query(ctx, OpUpdateAll, c.collection.TableName, query, variables)
+	if err != nil {
+		return nil, err
+	}
+
+	defer cursor.Close()
+
+	results := make([]UpdateResult, 0)
+	for cursor.HasMore() {
+		if !options.ReturnOld && !options.ReturnNew {
+			var key string
+			if _, err := cursor.ReadDocument(ctx, &key); err != nil {
+				return nil, err
+			}
+			results = append(results, UpdateResult{Key: key})
+			continue
+		}
+
+		var row struct {
+			Key string                 `json:"key"`
+			Old map[string]interface{} `json:"old"`
+			New map[string]interface{} `json:"new"`
+		}
+		if _, err := cursor.ReadDocument(ctx, &row); err != nil {
+			return nil, err
+		}
+
+		result := UpdateResult{Key: row.Key}
+		if options.ReturnOld {
+			if result.Old, err = c.collection.record(ctx, row.Old); err != nil {
+				return nil, err
+			}
+		}
+		if options.ReturnNew {
+			if result.New, err = c.collection.record(ctx, row.New); err != nil {
+				return nil, err
+			}
+		}
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+// record converts a document read as a map to a record of the collection
+func (c *Collection) record(ctx context.Context, doc map[string]interface{}) (interface{}, error) {
+	return readDoc(ctx, c.AllocateRecord, c.Hooks.AfterRead, func(read map[string]interface{}) error {
+		for name, value := range doc {
+			read[name] = value
+		}
+		return nil
+	})
+}
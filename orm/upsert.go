@@ -0,0 +1,118 @@
+package orm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// ----------------
+// Upsert ends the chaining by inserting a document when nothing matches the filters,
+// or updating the matching document otherwise, in one atomic statement.
+// ----------------
+
+type upsertResult struct {
+	Key      string `json:"key"`
+	Inserted bool   `json:"inserted"`
+}
+
+// upsertSearch turns the filters into the UPSERT search object, which only works for simple equality filters
+func (c *CollectionFilter) upsertSearch() (string, map[string]interface{}, error) {
+	if len(c.expressions) == 0 {
+		return "", nil, fmt.Errorf("upsert on %s needs at least one filter", c.collection.TableName)
+	}
+
+	fields := make([]string, 0, len(c.expressions))
+	values := make(map[string]interface{})
+	for _, expression := range c.expressions {
+		equality, ok := expression.(*EqualityExpression)
+		if !ok || equality.operator != EqualityExpressionEqual {
+			return "", nil, fmt.Errorf("upsert only supports equality filters, not %s", expression)
+		}
+
+		attribute, ok := equality.left.(*DocumentAttribute)
+		if !ok || (attribute.document != "" && attribute.document != DocumentName) || strings.ContainsAny(attribute.name, ".[") {
+			return "", nil, fmt.Errorf("upsert filters must compare a top level attribute, not %s", equality.left)
+		}
+
+		variable, ok := equality.right.(*QueryVariable)
+		if !ok {
+			return "", nil, fmt.Errorf("upsert filters must compare against a value, not %s", equality.right)
+		}
+
+		name, err := json.Marshal(attribute.name)
+		if err != nil {
+			return "", nil, err
+		}
+		fields = append(fields, fmt.Sprintf("%s: %s", name, variable))
+		values[attribute.name] = c.variableFactory.SymbolTable()[variable.name]
+	}
+
+	return "{ " + strings.Join(fields, ", ") + " }", values, nil
+}
+
+// Upsert inserts insertDoc if no document matches the filters, otherwise applies updateFields to the match.
+// The filter values are copied into insertDoc (where it leaves them empty) so the new document matches next time.
+// It returns the key of the document and whether it was inserted. insertDoc gets the BeforeCreate hooks
+// and validation first, whether or not it ends up inserted, and the AfterCreate hooks when it was.
+func (c *CollectionFilter) Upsert(ctx context.Context, insertDoc interface{}, updateFields map[string]interface{}) (string, bool, error) {
+	if err := c.checkScope(); err != nil {
+		return "", false, err
+	}
+
+	search, values, err := c.upsertSearch()
+	if err != nil {
+		return "", false, err
+	}
+
+	// insertDoc is a create like any other, it gets the hooks and validation before it's used
+	if err := c.collection.beforeCreate(ctx, insertDoc); err != nil {
+		return "", false, err
+	}
+	if err := c.collection.validate(insertDoc); err != nil {
+		return "", false, err
+	}
+
+	doc, err := prepareCreate(insertDoc)
+	if err != nil {
+		return "", false, err
+	}
+	for key, value := range values {
+		if current, exists := doc[key]; !exists || key == "_key" || current == nil || reflect.ValueOf(current).IsZero() {
+			doc[key] = value
+		}
+	}
+
+	if updateFields == nil {
+		updateFields = make(map[string]interface{}) // leave the match as it is
+	}
+
+	query := fmt.Sprintf(`
+UPSERT %s
+ INSERT %s
+ UPDATE %s IN @@collection
+ RETURN { key: NEW._key, inserted: OLD == null }`, search, c.variableFactory.MakeVariable(doc), c.variableFactory.MakeVariable(updateFields))
+
+	variables := c.variableFactory.SymbolTable()
+	variables["@collection"] = c.collection.TableName
+
+	cursor, err := c.collection.Connection.query(ctx, OpUpsert, c.collection.TableName, query, variables)
+	if err != nil {
+		return "", false, err
+	}
+
+	defer cursor.Close()
+
+	var result upsertResult
+	if _, err := cursor.ReadDocument(ctx, &result); err != nil {
+		return "", false, err
+	}
+
+	if result.Inserted {
+		return result.Key, true, c.collection.afterCreate(ctx, insertDoc)
+	}
+
+	return result.Key, false, nil
+}
@@ -0,0 +1,583 @@
+package orm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/arangodb/go-driver"
+)
+
+// -------------------------------------
+// Validation. With ValidateRecords set, Create, Update and the bulk writes check records
+// before sending them, by their validate tags and the Validator interface:
+//
+//	type User struct {
+//		Id    string `json:"id"`
+//		Name  string `json:"name" validate:"required,max=100"`
+//		Email string `json:"email" validate:"omitempty,email"`
+//		Role  string `json:"role" validate:"oneof=admin member"`
+//	}
+//
+// The rules are required, omitempty (skip the other rules when the field is empty), email,
+// min=n, max=n, len=n (lengths for strings, slices and maps, values for numbers) and
+// oneof=a b c. Nested structs are checked too. With a SchemaLevel, Initialize also gives
+// arangodb the same rules as the collection's JSON schema, so other clients' writes are
+// checked as well.
+// -------------------------------------
+
+// Validator is implemented by records with checks the validate tags can't express
+type Validator interface {
+	Validate() error
+}
+
+// FieldError is a field that broke a rule, Field is its json name (a path for nested fields)
+type FieldError struct {
+	Field   string
+	Rule    string // the validate rule, empty for errors from a Validator
+	Param   string
+	Message string
+}
+
+func (c FieldError) Error() string {
+	if c.Field == "" {
+		return c.Message
+	}
+	return c.Field + " " + c.Message
+}
+
+type ValidationError struct {
+	Errors []FieldError
+}
+
+func (c *ValidationError) Error() string {
+	messages := make([]string, len(c.Errors))
+	for i, fieldError := range c.Errors {
+		messages[i] = fieldError.Error()
+	}
+	return "validation failed: " + strings.Join(messages, "; ")
+}
+
+// IsValidationError tells if a write was refused by Validate, or by the collection's schema in arangodb
+func IsValidationError(err error) bool {
+	var validationError *ValidationError
+	if errors.As(err, &validationError) {
+		return true
+	}
+
+	arangoErr, ok := driver.AsArangoError(err)
+	return ok && arangoErr.ErrorNum == errValidationFailed
+}
+
+// errValidationFailed is arangodb's error for documents that don't match the collection's schema
+const errValidationFailed = 1620
+
+// Validate checks a record's validate tags, then its Validate method if it's a Validator.
+// It returns a *ValidationError listing every field that's wrong.
+func Validate(obj interface{}) error {
+	value := reflect.Indirect(reflect.ValueOf(obj))
+	if value.Kind() != reflect.Struct {
+		return nil
+	}
+
+	fieldErrors, err := validateStruct(value, "")
+	if err != nil {
+		return err
+	}
+
+	if validator, ok := obj.(Validator); ok {
+		if err := validator.Validate(); err != nil {
+			var validationError *ValidationError
+			var fieldError FieldError
+			switch {
+			case errors.As(err, &validationError):
+				fieldErrors = append(fieldErrors, validationError.Errors...)
+			case errors.As(err, &fieldError):
+				fieldErrors = append(fieldErrors, fieldError)
+			default:
+				fieldErrors = append(fieldErrors, FieldError{Message: err.Error()})
+			}
+		}
+	}
+
+	if len(fieldErrors) > 0 {
+		return &ValidationError{Errors: fieldErrors}
+	}
+	return nil
+}
+
+// validate checks a record before it's written, when the collection asks for it
+func (c *Collection) validate(obj interface{}) error {
+	if !c.ValidateRecords {
+		return nil
+	}
+	return Validate(obj)
+}
+
+// ----------------
+// Rules
+// ----------------
+
+type rule struct {
+	name  string
+	param string
+}
+
+// fieldRules are the rules of a field, from its validate tag
+type fieldRules struct {
+	required  bool
+	omitEmpty bool
+	rules     []rule
+}
+
+var knownRules = map[string]bool{"email": true, "min": true, "max": true, "len": true, "oneof": true}
+
+func parseRules(field reflect.StructField) (*fieldRules, error) {
+	tag, ok := field.Tag.Lookup("validate")
+	if !ok || tag == "" || tag == "-" {
+		return nil, nil
+	}
+
+	parsed := &fieldRules{}
+	for _, part := range strings.Split(tag, ",") {
+		name, param, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch {
+		case name == "required":
+			parsed.required = true
+		case name == "omitempty":
+			parsed.omitEmpty = true
+		case knownRules[name]:
+			if name != "email" && param == "" {
+				return nil, fmt.Errorf("field %s: validate rule %s needs a parameter", field.Name, name)
+			}
+			if name == "min" || name == "max" || name == "len" {
+				if _, err := strconv.ParseFloat(param, 64); err != nil {
+					return nil, fmt.Errorf("field %s: validate rule %s needs a number, not %q", field.Name, name, param)
+				}
+			}
+			parsed.rules = append(parsed.rules, rule{name: name, param: param})
+		default:
+			return nil, fmt.Errorf("field %s: unknown validate rule %q", field.Name, name)
+		}
+	}
+
+	return parsed, nil
+}
+
+// jsonName is the name a field is stored under, empty if it isn't stored
+func jsonName(field reflect.StructField) string {
+	if !field.IsExported() {
+		return ""
+	}
+
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "-" {
+		return ""
+	}
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
+
+// squashed tells if a field's own fields are stored in the parent document, the way encoding
+// flattens embedded structs
+func squashed(field reflect.StructField) bool {
+	if !field.IsExported() {
+		return false
+	}
+
+	_, options, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if field.Anonymous && field.Type.Kind() == reflect.Struct {
+		return true
+	}
+	return strings.Contains(options, "squash")
+}
+
+func fieldPath(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "." + name
+}
+
+func validateStruct(value reflect.Value, prefix string) ([]FieldError, error) {
+	fieldErrors := make([]FieldError, 0)
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		if squashed(field) {
+			embedded := reflect.Indirect(value.Field(i))
+			if embedded.Kind() != reflect.Struct {
+				continue
+			}
+			flattened, err := validateStruct(embedded, prefix)
+			if err != nil {
+				return nil, err
+			}
+			fieldErrors = append(fieldErrors, flattened...)
+			continue
+		}
+
+		name := jsonName(field)
+		if name == "" {
+			continue
+		}
+
+		rules, err := parseRules(field)
+		if err != nil {
+			return nil, err
+		}
+
+		path := fieldPath(prefix, name)
+		fieldValue := value.Field(i)
+		if rules != nil {
+			fieldErrors = append(fieldErrors, rules.check(path, fieldValue)...)
+		}
+
+		nested, err := validateNested(fieldValue, path)
+		if err != nil {
+			return nil, err
+		}
+		fieldErrors = append(fieldErrors, nested...)
+	}
+
+	return fieldErrors, nil
+}
+
+// validateNested checks structs inside a field, and inside its slices
+func validateNested(value reflect.Value, path string) ([]FieldError, error) {
+	value = reflect.Indirect(value)
+	switch {
+	case value.Kind() == reflect.Struct && value.Type().PkgPath() != "time":
+		return validateStruct(value, path)
+	case value.Kind() == reflect.Slice || value.Kind() == reflect.Array:
+		fieldErrors := make([]FieldError, 0)
+		for i := 0; i < value.Len(); i++ {
+			nested, err := validateNested(value.Index(i), fmt.Sprintf("%s[%d]", path, i))
+			if err != nil {
+				return nil, err
+			}
+			fieldErrors = append(fieldErrors, nested...)
+		}
+		return fieldErrors, nil
+	}
+
+	return nil, nil
+}
+
+func isEmpty(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.Slice, reflect.Map:
+		return value.Len() == 0
+	case reflect.Invalid:
+		return true
+	}
+	return value.IsZero()
+}
+
+// email is a loose check, the address has to have a mailbox and a domain with a dot
+var email = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`)
+
+func (c *fieldRules) check(path string, value reflect.Value) []FieldError {
+	if isEmpty(value) {
+		if c.required {
+			return []FieldError{{Field: path, Rule: "required", Message: "is required"}}
+		}
+		if c.omitEmpty {
+			return nil
+		}
+	}
+
+	value = reflect.Indirect(value)
+	if !value.IsValid() {
+		return nil // a nil pointer that isn't required
+	}
+
+	fieldErrors := make([]FieldError, 0)
+	for _, rule := range c.rules {
+		if message := rule.check(value); message != "" {
+			fieldErrors = append(fieldErrors, FieldError{Field: path, Rule: rule.name, Param: rule.param, Message: message})
+		}
+	}
+	return fieldErrors
+}
+
+// check returns what's wrong with the value, or nothing when it follows the rule
+func (c rule) check(value reflect.Value) string {
+	switch c.name {
+	case "email":
+		if value.Kind() != reflect.String || !email.MatchString(value.String()) {
+			return "must be an email address"
+		}
+	case "oneof":
+		options := strings.Fields(c.param)
+		for _, option := range options {
+			if fmt.Sprint(value.Interface()) == option {
+				return ""
+			}
+		}
+		return "must be one of " + strings.Join(options, ", ")
+	case "min", "max", "len":
+		limit, _ := strconv.ParseFloat(c.param, 64)
+		size, unit, ok := measure(value)
+		if !ok {
+			return ""
+		}
+		switch {
+		case c.name == "min" && size < limit:
+			return fmt.Sprintf("must be at least %s%s", c.param, unit)
+		case c.name == "max" && size > limit:
+			return fmt.Sprintf("must be at most %s%s", c.param, unit)
+		case c.name == "len" && size != limit:
+			return fmt.Sprintf("must be exactly %s%s", c.param, unit)
+		}
+	}
+
+	return ""
+}
+
+// measure is what min, max and len compare: lengths of strings and collections, numbers themselves
+func measure(value reflect.Value) (float64, string, bool) {
+	switch value.Kind() {
+	case reflect.String:
+		return float64(utf8.RuneCountInString(value.String())), " characters", true
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return float64(value.Len()), " items", true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(value.Int()), "", true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(value.Uint()), "", true
+	case reflect.Float32, reflect.Float64:
+		return value.Float(), "", true
+	}
+	return 0, "", false
+}
+
+// ----------------
+// JSON schema
+// ----------------
+
+// JSONSchema is the JSON schema of a record type's validate tags, the rule Initialize gives arangodb.
+// Validator methods can't be expressed, so they're only checked by Validate.
+func JSONSchema(obj interface{}) (map[string]interface{}, error) {
+	kind := reflect.TypeOf(obj)
+	for kind != nil && kind.Kind() == reflect.Ptr {
+		kind = kind.Elem()
+	}
+	if kind == nil || kind.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("JSON schemas need a struct, not %v", kind)
+	}
+
+	schema, err := structSchema(kind, true)
+	if err != nil {
+		return nil, err
+	}
+	if schema == nil {
+		schema = map[string]interface{}{}
+	}
+	schema["type"] = "object"
+	return schema, nil
+}
+
+// structSchema is the schema of a struct's fields, nil when none of them have rules
+func structSchema(kind reflect.Type, document bool) (map[string]interface{}, error) {
+	properties := make(map[string]interface{})
+	required := make([]interface{}, 0)
+
+	for i := 0; i < kind.NumField(); i++ {
+		field := kind.Field(i)
+		if squashed(field) {
+			embedded := field.Type
+			for embedded.Kind() == reflect.Ptr {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() != reflect.Struct {
+				continue
+			}
+			flattened, err := structSchema(embedded, document)
+			if err != nil {
+				return nil, err
+			}
+			if flattened != nil {
+				for name, schema := range flattened["properties"].(map[string]interface{}) {
+					properties[name] = schema
+				}
+				if names, ok := flattened["required"].([]interface{}); ok {
+					required = append(required, names...)
+				}
+			}
+			continue
+		}
+
+		name := jsonName(field)
+		if name == "" || (document && (name == "id" || name == RevisionKey)) {
+			continue // the id is stored as _key, and arango sets the revision
+		}
+
+		rules, err := parseRules(field)
+		if err != nil {
+			return nil, err
+		}
+
+		schema, err := fieldSchema(field.Type, rules)
+		if err != nil {
+			return nil, err
+		}
+		if schema != nil {
+			properties[name] = schema
+		}
+		if rules != nil && rules.required {
+			required = append(required, name)
+		}
+	}
+
+	if len(properties) == 0 && len(required) == 0 {
+		return nil, nil
+	}
+
+	schema := map[string]interface{}{"properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema, nil
+}
+
+func fieldSchema(kind reflect.Type, rules *fieldRules) (map[string]interface{}, error) {
+	pointer := kind.Kind() == reflect.Ptr
+	for kind.Kind() == reflect.Ptr {
+		kind = kind.Elem()
+	}
+
+	schema := make(map[string]interface{})
+
+	// nested structs, and structs in slices
+	switch {
+	case kind.Kind() == reflect.Struct && kind.PkgPath() != "time":
+		nested, err := structSchema(kind, false)
+		if err != nil {
+			return nil, err
+		}
+		for name, value := range nested {
+			schema[name] = value
+		}
+	case kind.Kind() == reflect.Slice || kind.Kind() == reflect.Array:
+		items, err := fieldSchema(kind.Elem(), nil)
+		if err != nil {
+			return nil, err
+		}
+		if items != nil {
+			schema["items"] = items
+		}
+	}
+
+	if rules != nil {
+		if rules.required {
+			// required means not empty, and the field is always there since it's marshalled
+			if pointer {
+				schema["not"] = map[string]interface{}{"type": "null"}
+			} else if empty := emptySchema(kind); empty != nil {
+				schema["not"] = empty
+			}
+		}
+
+		for _, rule := range rules.rules {
+			ruleSchema(schema, kind, rule)
+		}
+
+		if rules.omitEmpty && !rules.required && len(schema) > 0 {
+			empty := emptySchema(kind)
+			if pointer || empty == nil {
+				empty = map[string]interface{}{"type": "null"}
+			}
+			schema = map[string]interface{}{"anyOf": []interface{}{empty, schema}}
+		}
+	}
+
+	if len(schema) == 0 {
+		return nil, nil
+	}
+	return schema, nil
+}
+
+// emptySchema matches the empty value of a type, as it's marshalled
+func emptySchema(kind reflect.Type) map[string]interface{} {
+	switch kind.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"enum": []interface{}{""}}
+	case reflect.Slice:
+		return map[string]interface{}{"anyOf": []interface{}{
+			map[string]interface{}{"type": "null"}, map[string]interface{}{"type": "array", "maxItems": 0}}}
+	case reflect.Map:
+		return map[string]interface{}{"anyOf": []interface{}{
+			map[string]interface{}{"type": "null"}, map[string]interface{}{"type": "object", "maxProperties": 0}}}
+	case reflect.Bool:
+		return map[string]interface{}{"enum": []interface{}{false}}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"enum": []interface{}{0}}
+	}
+	return nil
+}
+
+func ruleSchema(schema map[string]interface{}, kind reflect.Type, rule rule) {
+	limit, _ := strconv.ParseFloat(rule.param, 64)
+	lengths := map[reflect.Kind][2]string{
+		reflect.String: {"minLength", "maxLength"},
+		reflect.Slice:  {"minItems", "maxItems"},
+		reflect.Array:  {"minItems", "maxItems"},
+		reflect.Map:    {"minProperties", "maxProperties"},
+	}
+
+	switch rule.name {
+	case "email":
+		schema["pattern"] = email.String()
+	case "oneof":
+		options := make([]interface{}, 0)
+		for _, option := range strings.Fields(rule.param) {
+			var value interface{} = option
+			if kind.Kind() != reflect.String && json.Unmarshal([]byte(option), &value) != nil {
+				value = option
+			}
+			options = append(options, value)
+		}
+		schema["enum"] = options
+	case "min", "max", "len":
+		keywords, isLength := lengths[kind.Kind()]
+		if !isLength {
+			keywords = [2]string{"minimum", "maximum"}
+		}
+		if rule.name != "max" {
+			schema[keywords[0]] = limit
+		}
+		if rule.name != "min" {
+			schema[keywords[1]] = limit
+		}
+	}
+}
+
+// schema is what Initialize gives arangodb when the collection has a SchemaLevel
+func (c *Collection) schema() (*driver.CollectionSchemaOptions, error) {
+	if c.SchemaLevel == "" {
+		return nil, nil
+	}
+	if c.AllocateRecord == nil {
+		return nil, fmt.Errorf("%s: a schema needs AllocateRecord to know the record type", c.TableName)
+	}
+
+	rule, err := JSONSchema(c.AllocateRecord())
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", c.TableName, err)
+	}
+
+	return &driver.CollectionSchemaOptions{
+		Rule:    rule,
+		Level:   c.SchemaLevel,
+		Message: fmt.Sprintf("%s document doesn't match the schema", c.TableName),
+	}, nil
+}
@@ -1,6 +1,9 @@
 package orm
 
-import "fmt"
+import (
+	"fmt"
+	"reflect"
+)
 
 // ---------------------
 // Manage variables
@@ -26,14 +29,17 @@ type VariableFactory struct {
 }
 
 func (c *VariableFactory) MakeVariable(value interface{}) Variable {
-	valHash := fmt.Sprint(value)
+	// equal scalars share a variable, as long as they're the same type (1 isn't "1")
+	valHash, shared := scalarKey(value)
 	var varName string
-	if v, ok := c.keyTracker[valHash]; ok {
+	if v, ok := c.keyTracker[valHash]; ok && shared {
 		varName = v // reuse the variable name for this value
 	} else {
 		varName = fmt.Sprintf("%s_%d", VarPrefix, c.variableCounter)
 		c.variableCounter++
-		c.keyTracker[valHash] = varName
+		if shared {
+			c.keyTracker[valHash] = varName
+		}
 		c.symbolTable[varName] = value
 	}
 
@@ -42,6 +48,38 @@ func (c *VariableFactory) MakeVariable(value interface{}) Variable {
 	}
 }
 
+// MakeCollectionVariable makes a collection bind parameter (@@var_n), used for collections other than @@collection
+func (c *VariableFactory) MakeCollectionVariable(collectionName string) Variable {
+	valHash := "@" + collectionName
+	var varName string
+	if v, ok := c.keyTracker[valHash]; ok {
+		varName = v
+	} else {
+		varName = fmt.Sprintf("@%s_%d", VarPrefix, c.variableCounter)
+		c.variableCounter++
+		c.keyTracker[valHash] = varName
+		c.symbolTable[varName] = collectionName
+	}
+
+	return &QueryVariable{
+		name: varName,
+	}
+}
+
+// scalarKey identifies a scalar value for sharing its variable. Arrays, maps and structs don't
+// print unambiguously (["a b"] and ["a", "b"] both print as [a b]), so they always get their own.
+func scalarKey(value interface{}) (string, bool) {
+	switch reflect.ValueOf(value).Kind() {
+	case reflect.Bool, reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return fmt.Sprintf("%T %v", value, value), true
+	}
+
+	return "", false
+}
+
 func (c *VariableFactory) SymbolTable() map[string]interface{} {
 	return c.symbolTable
 }
@@ -4,17 +4,35 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/arangodb/go-driver"
 	"io"
+	"time"
 )
 
 type MockDatabase struct {
 	LastQuery    string
 	LastBindVars map[string]interface{}
 	MyCursor     *MockCursor
+	MyCollection *MockCollection
+	QueryErrors  []error // returned by the next queries, one each
+
+	// transaction tracking
+	Transactions  []driver.TransactionCollections
+	Committed     []driver.TransactionID
+	Aborted       []driver.TransactionID
+	LastQueryCtx  context.Context
+	transactionId int
+
+	// search views and analyzers
+	MyViews          map[string]*MockView
+	EnsuredAnalyzers []driver.ArangoSearchAnalyzerDefinition
 }
 
 func (c *MockDatabase) Collection(ctx context.Context, name string) (driver.Collection, error) {
+	if c.MyCollection != nil {
+		return c.MyCollection, nil
+	}
 	//TODO implement me
 	panic("implement me1")
 }
@@ -35,13 +53,16 @@ func (c *MockDatabase) CreateCollection(ctx context.Context, name string, option
 }
 
 func (c *MockDatabase) View(ctx context.Context, name string) (driver.View, error) {
-	//TODO implement me
-	panic("implement me5")
+	if view, ok := c.MyViews[name]; ok {
+		return view, nil
+	}
+
+	return nil, driver.ArangoError{HasError: true, Code: 404, ErrorNum: 1203, ErrorMessage: "view not found"}
 }
 
 func (c *MockDatabase) ViewExists(ctx context.Context, name string) (bool, error) {
-	//TODO implement me
-	panic("implement me6")
+	_, ok := c.MyViews[name]
+	return ok, nil
 }
 
 func (c *MockDatabase) Views(ctx context.Context) ([]driver.View, error) {
@@ -50,8 +71,17 @@ func (c *MockDatabase) Views(ctx context.Context) ([]driver.View, error) {
 }
 
 func (c *MockDatabase) CreateArangoSearchView(ctx context.Context, name string, options *driver.ArangoSearchViewProperties) (driver.ArangoSearchView, error) {
-	//TODO implement me
-	panic("implement me8")
+	if c.MyViews == nil {
+		c.MyViews = make(map[string]*MockView)
+	}
+
+	view := &MockView{ViewName: name, Created: true}
+	if options != nil {
+		view.Props = *options
+	}
+	c.MyViews[name] = view
+
+	return view, nil
 }
 
 func (c *MockDatabase) Graph(ctx context.Context, name string) (driver.Graph, error) {
@@ -80,18 +110,19 @@ func (c *MockDatabase) CreateGraphV2(ctx context.Context, name string, options *
 }
 
 func (c *MockDatabase) BeginTransaction(ctx context.Context, cols driver.TransactionCollections, opts *driver.BeginTransactionOptions) (driver.TransactionID, error) {
-	//TODO implement me
-	panic("implement me14")
+	c.transactionId++
+	c.Transactions = append(c.Transactions, cols)
+	return driver.TransactionID(fmt.Sprintf("tx%d", c.transactionId)), nil
 }
 
 func (c *MockDatabase) CommitTransaction(ctx context.Context, tid driver.TransactionID, opts *driver.CommitTransactionOptions) error {
-	//TODO implement me
-	panic("implement me15")
+	c.Committed = append(c.Committed, tid)
+	return nil
 }
 
 func (c *MockDatabase) AbortTransaction(ctx context.Context, tid driver.TransactionID, opts *driver.AbortTransactionOptions) error {
-	//TODO implement me
-	panic("implement me16")
+	c.Aborted = append(c.Aborted, tid)
+	return nil
 }
 
 func (c *MockDatabase) TransactionStatus(ctx context.Context, tid driver.TransactionID) (driver.TransactionStatusRecord, error) {
@@ -100,8 +131,8 @@ func (c *MockDatabase) TransactionStatus(ctx context.Context, tid driver.Transac
 }
 
 func (c *MockDatabase) EnsureAnalyzer(ctx context.Context, analyzer driver.ArangoSearchAnalyzerDefinition) (bool, driver.ArangoSearchAnalyzer, error) {
-	//TODO implement me
-	panic("implement me18")
+	c.EnsuredAnalyzers = append(c.EnsuredAnalyzers, analyzer)
+	return false, nil, nil
 }
 
 func (c *MockDatabase) Analyzer(ctx context.Context, name string) (driver.ArangoSearchAnalyzer, error) {
@@ -143,6 +174,12 @@ func (c *MockDatabase) Remove(ctx context.Context) error {
 func (c *MockDatabase) Query(ctx context.Context, query string, bindVars map[string]interface{}) (driver.Cursor, error) {
 	c.LastQuery = query
 	c.LastBindVars = bindVars
+	c.LastQueryCtx = ctx
+	if len(c.QueryErrors) > 0 {
+		err := c.QueryErrors[0]
+		c.QueryErrors = c.QueryErrors[1:]
+		return nil, err
+	}
 	if c.MyCursor != nil {
 		return c.MyCursor, nil
 	}
@@ -152,11 +189,14 @@ func (c *MockDatabase) Query(ctx context.Context, query string, bindVars map[str
 
 type MockCursor struct {
 	io.Closer
-	Items []string
-	Index int64
+	Items  []string
+	Index  int64
+	Closed bool
+	Stats  *MockStatistics
 }
 
 func (c *MockCursor) Close() error {
+	c.Closed = true
 	return nil
 }
 
@@ -165,8 +205,10 @@ func (c *MockCursor) Count() int64 {
 }
 
 func (c *MockCursor) Statistics() driver.QueryStatistics {
-	//TODO implement me
-	panic("implement me23")
+	if c.Stats == nil {
+		return nil
+	}
+	return c.Stats
 }
 
 func (c *MockCursor) Extra() driver.QueryExtra {
@@ -174,6 +216,44 @@ func (c *MockCursor) Extra() driver.QueryExtra {
 	panic("implement me24")
 }
 
+type MockStatistics struct {
+	Writes      int64
+	Ignored     int64
+	FullScans   int64
+	IndexScans  int64
+	FilteredOut int64
+	Full        int64
+	Time        time.Duration
+}
+
+func (c *MockStatistics) WritesExecuted() int64 {
+	return c.Writes
+}
+
+func (c *MockStatistics) WritesIgnored() int64 {
+	return c.Ignored
+}
+
+func (c *MockStatistics) ScannedFull() int64 {
+	return c.FullScans
+}
+
+func (c *MockStatistics) ScannedIndex() int64 {
+	return c.IndexScans
+}
+
+func (c *MockStatistics) Filtered() int64 {
+	return c.FilteredOut
+}
+
+func (c *MockStatistics) FullCount() int64 {
+	return c.Full
+}
+
+func (c *MockStatistics) ExecutionTime() time.Duration {
+	return c.Time
+}
+
 func (c *MockCursor) HasMore() bool {
 	return c.Index < c.Count()
 }
@@ -199,3 +279,182 @@ type MockClient struct {
 func (c *MockClient) Database(ctx context.Context, name string) (driver.Database, error) {
 	return c.MockDatabase, nil
 }
+
+// MockCollection keeps documents in memory for the single document CRUD calls,
+// anything else falls through to the nil driver.Collection and panics
+type MockCollection struct {
+	driver.Collection
+	Documents map[string]map[string]interface{}
+	revision  int
+
+	RequestErrors []error // returned by the next batch requests, one each, nil lets a request through
+}
+
+func NewMockCollection() *MockCollection {
+	return &MockCollection{Documents: make(map[string]map[string]interface{})}
+}
+
+func (c *MockCollection) nextRev() string {
+	c.revision++
+	return fmt.Sprintf("rev%d", c.revision)
+}
+
+func (c *MockCollection) checkRev(ctx context.Context, doc map[string]interface{}) error {
+	if rev, ok := ctx.Value(driver.ContextKey("arangodb-revision")).(string); ok && rev != doc["_rev"] {
+		return driver.ArangoError{HasError: true, Code: 412, ErrorNum: 1200, ErrorMessage: "conflict, _rev values do not match"}
+	}
+	return nil
+}
+
+func (c *MockCollection) requestError() error {
+	if len(c.RequestErrors) == 0 {
+		return nil
+	}
+	err := c.RequestErrors[0]
+	c.RequestErrors = c.RequestErrors[1:]
+	return err
+}
+
+func notFound() error {
+	return driver.ArangoError{HasError: true, Code: 404, ErrorNum: 1202, ErrorMessage: "document not found"}
+}
+
+func convert(in interface{}, out interface{}) error {
+	data, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
+
+func (c *MockCollection) ReadDocument(ctx context.Context, key string, result interface{}) (driver.DocumentMeta, error) {
+	doc, ok := c.Documents[key]
+	if !ok {
+		return driver.DocumentMeta{}, notFound()
+	}
+	return driver.DocumentMeta{Key: key, Rev: doc["_rev"].(string)}, convert(doc, result)
+}
+
+func (c *MockCollection) CreateDocument(ctx context.Context, document interface{}) (driver.DocumentMeta, error) {
+	doc := make(map[string]interface{})
+	if err := convert(document, &doc); err != nil {
+		return driver.DocumentMeta{}, err
+	}
+	key, _ := doc["_key"].(string)
+	if key == "" {
+		key = fmt.Sprintf("key%d", len(c.Documents)+1)
+		doc["_key"] = key
+	}
+	doc["_rev"] = c.nextRev()
+	c.Documents[key] = doc
+	return driver.DocumentMeta{Key: key, Rev: doc["_rev"].(string)}, nil
+}
+
+func (c *MockCollection) UpdateDocument(ctx context.Context, key string, update interface{}) (driver.DocumentMeta, error) {
+	doc, ok := c.Documents[key]
+	if !ok {
+		return driver.DocumentMeta{}, notFound()
+	}
+	if err := c.checkRev(ctx, doc); err != nil {
+		return driver.DocumentMeta{}, err
+	}
+	changes := make(map[string]interface{})
+	if err := convert(update, &changes); err != nil {
+		return driver.DocumentMeta{}, err
+	}
+	for k, v := range changes {
+		doc[k] = v
+	}
+	doc["_rev"] = c.nextRev()
+	return driver.DocumentMeta{Key: key, Rev: doc["_rev"].(string)}, nil
+}
+
+func (c *MockCollection) RemoveDocument(ctx context.Context, key string) (driver.DocumentMeta, error) {
+	doc, ok := c.Documents[key]
+	if !ok {
+		return driver.DocumentMeta{}, notFound()
+	}
+	if err := c.checkRev(ctx, doc); err != nil {
+		return driver.DocumentMeta{}, err
+	}
+	delete(c.Documents, key)
+	return driver.DocumentMeta{Key: key}, nil
+}
+
+func (c *MockCollection) CreateDocuments(ctx context.Context, documents interface{}) (driver.DocumentMetaSlice, driver.ErrorSlice, error) {
+	if err := c.requestError(); err != nil {
+		return nil, nil, err
+	}
+	docs := make([]map[string]interface{}, 0)
+	if err := convert(documents, &docs); err != nil {
+		return nil, nil, err
+	}
+	metas := make(driver.DocumentMetaSlice, len(docs))
+	errs := make(driver.ErrorSlice, len(docs))
+	for i, doc := range docs {
+		metas[i], errs[i] = c.CreateDocument(ctx, doc)
+	}
+	return metas, errs, nil
+}
+
+func (c *MockCollection) UpdateDocuments(ctx context.Context, keys []string, updates interface{}) (driver.DocumentMetaSlice, driver.ErrorSlice, error) {
+	if err := c.requestError(); err != nil {
+		return nil, nil, err
+	}
+	docs := make([]map[string]interface{}, 0)
+	if err := convert(updates, &docs); err != nil {
+		return nil, nil, err
+	}
+	metas := make(driver.DocumentMetaSlice, len(docs))
+	errs := make(driver.ErrorSlice, len(docs))
+	for i, doc := range docs {
+		docCtx := ctx
+		if rev, ok := doc["_rev"].(string); ok {
+			docCtx = driver.WithRevision(ctx, rev)
+		}
+		metas[i], errs[i] = c.UpdateDocument(docCtx, keys[i], doc)
+	}
+	return metas, errs, nil
+}
+
+func (c *MockCollection) RemoveDocuments(ctx context.Context, keys []string) (driver.DocumentMetaSlice, driver.ErrorSlice, error) {
+	if err := c.requestError(); err != nil {
+		return nil, nil, err
+	}
+	revs, _ := ctx.Value(driver.ContextKey("arangodb-revisions")).([]string)
+	metas := make(driver.DocumentMetaSlice, len(keys))
+	errs := make(driver.ErrorSlice, len(keys))
+	for i, key := range keys {
+		docCtx := context.Background()
+		if revs != nil {
+			docCtx = driver.WithRevision(docCtx, revs[i])
+		}
+		metas[i], errs[i] = c.RemoveDocument(docCtx, key)
+	}
+	return metas, errs, nil
+}
+
+// MockView is an arangosearch view that just keeps its properties
+type MockView struct {
+	driver.View
+	ViewName string
+	Props    driver.ArangoSearchViewProperties
+	Created  bool
+}
+
+func (c *MockView) Name() string {
+	return c.ViewName
+}
+
+func (c *MockView) ArangoSearchView() (driver.ArangoSearchView, error) {
+	return c, nil
+}
+
+func (c *MockView) Properties(ctx context.Context) (driver.ArangoSearchViewProperties, error) {
+	return c.Props, nil
+}
+
+func (c *MockView) SetProperties(ctx context.Context, options driver.ArangoSearchViewProperties) error {
+	c.Props = options
+	return nil
+}